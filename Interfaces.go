@@ -12,31 +12,38 @@ type MsgResp struct {
 }
 
 type LinkUsersRequest struct {
-	ReferredBy int `json:"referredBy"`
+	ReferredBy int    `json:"referredBy"`
+	Reason     string `json:"reason"` // kept in the referral history
 }
 
 type Collection struct {
-	Amount    float64   `json:"amount"`
+	Amount    Money     `json:"amount"`
 	CreatedAt time.Time `json:"createdAt"`
 	Date      time.Time `json:"date"`
 	ID        int       `json:"id"`
 	HandoutId int       `json:"handoutId,omitempty"`
+	Recovery  bool      `json:"recovery"` // recorded on a written-off handout, set by the server
+	BranchID  int       `json:"branchId"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type Handout struct {
-	Amount    float64   `json:"amount"`
+	Amount    Money     `json:"amount"`
 	CreatedAt time.Time `json:"createdAt"`
 	Date      time.Time `json:"date"`
 	ID        int       `json:"id"`
 	Status    string    `json:"status"`
+	// Bond is true while any collateral of the handout is still held
 	Bond      bool      `json:"bond"`
+	BranchID  int       `json:"branchId"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type HandoutResp struct {
-	Handout  Handout                `json:"handout"`
-	Customer HandoutCustomerDetails `json:"customer"`
+	Handout    Handout                `json:"handout"`
+	Customer   HandoutCustomerDetails `json:"customer"`
+	Guarantors []HandoutParty         `json:"guarantors"`
+	Nominees   []HandoutParty         `json:"nominees"`
 }
 
 // HandoutCustomerDetails contains basic customer info for handout responses
@@ -51,12 +58,18 @@ type HandoutCustomerDetails struct {
 type HandoutUserDetails = HandoutCustomerDetails
 
 type HandoutUpdate struct {
-	Amount     float64   `json:"amount"`
-	Date       time.Time `json:"date"`
-	ID         int       `json:"id"`
-	Status     *string   `json:"status,omitempty"`
-	Bond       *bool     `json:"bond,omitempty"`
-	CustomerId int       `json:"customerId"`
+	Amount Money     `json:"amount"`
+	Date   time.Time `json:"date"`
+	ID     int       `json:"id"`
+	Status *string   `json:"status,omitempty"`
+	// Deprecated: ignored since the collateral register replaced the bond
+	// flag, record collateral through /handouts/{id}/collaterals instead
+	Bond       *bool `json:"bond,omitempty"`
+	CustomerId int   `json:"customerId"`
+	// An admin can disburse to a customer that fails eligibility rules by
+	// setting OverrideEligibility with a reason, which is recorded
+	OverrideEligibility bool   `json:"overrideEligibility,omitempty"`
+	OverrideReason      string `json:"overrideReason,omitempty"`
 }
 
 // Customer represents a customer/client in the finance system
@@ -69,7 +82,11 @@ type Customer struct {
 	Mobile     int       `json:"mobile"`
 	Name       string    `json:"name"`
 	ReferredBy int       `json:"referredBy"`
+	BranchID   int       `json:"branchId"` // Set on create (super admin only), changed by transfer
 	UpdatedAt  time.Time `json:"updatedAt"`
+	// Risk score from 0 (most reliable) to 100, nil until the customer has handouts
+	RiskScore      *int       `json:"riskScore"`
+	RiskComputedAt *time.Time `json:"riskComputedAt"`
 }
 
 // User is an alias for Customer to maintain backward compatibility
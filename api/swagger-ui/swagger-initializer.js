@@ -0,0 +1,7 @@
+window.onload = function () {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    persistAuthorization: true,
+  });
+};
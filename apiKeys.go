@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// APIKey represents a machine-to-machine credential owned by an admin.
+// The secret itself is never stored; only its SHA-256 hash and a short prefix.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	AdminID    int        `json:"adminId"`
+	Username   string     `json:"username"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// Create API key request structure
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Create API key response structure - Key is only ever returned here
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// apiKeyPrincipal is the identity resolved from a valid API key
+type apiKeyPrincipal struct {
+	ID         int
+	AdminID    int
+	Username   string
+	Role       string
+	BranchID   int
+	SuperAdmin bool
+	Scopes     []string
+}
+
+const apiKeyPrefixLen = 8
+
+// Scopes that can be granted to an API key, one read/write pair per resource
+var validAPIKeyScopes = map[string]bool{
+	"customers:read":    true,
+	"customers:write":   true,
+	"handouts:read":     true,
+	"handouts:write":    true,
+	"collections:read":  true,
+	"collections:write": true,
+}
+
+var errInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+var errInactiveAPIKeyOwner = errors.New("admin account is not active")
+
+// hashAPIKey returns the hex encoded SHA-256 digest stored for a key.
+// Keys are 256 bits of randomness so a fast hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope maps a request to the scope an API key needs to call it.
+// An empty result means the route is not available to API keys at all.
+func requiredScope(r *http.Request) string {
+	resource := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	switch resource {
+	case "customers", "handouts", "collections":
+	default:
+		return ""
+	}
+
+	if r.Method == http.MethodGet {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAPIKey looks up a presented key and checks it is usable
+func verifyAPIKey(ctx context.Context, key string) (*apiKeyPrincipal, error) {
+	var principal apiKeyPrincipal
+	var active bool
+	var expiresAt, revokedAt *time.Time
+
+	err := db.QueryRowContext(ctx, GET_API_KEY_FOR_AUTH, hashAPIKey(key)).Scan(
+		&principal.ID, &principal.AdminID, &principal.Username, &principal.Role,
+		&principal.BranchID, &active, &principal.SuperAdmin, pq.Array(&principal.Scopes), &expiresAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+
+	if revokedAt != nil || (expiresAt != nil && time.Now().After(*expiresAt)) {
+		return nil, errInvalidAPIKey
+	}
+
+	if !active {
+		return nil, errInactiveAPIKeyOwner
+	}
+
+	// Best effort - a failed timestamp update should not block the request
+	db.ExecContext(ctx, TOUCH_API_KEY, principal.ID)
+
+	return &principal, nil
+}
+
+// authenticateAPIKey handles the "Authorization: ApiKey <key>" scheme for authMiddleware
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string) (*http.Request, bool) {
+	principal, err := verifyAPIKey(r.Context(), key)
+	if err != nil {
+		if err == errInactiveAPIKeyOwner {
+			sendError(w, http.StatusUnauthorized, ACCOUNT_INACTIVE, "Admin account is not active")
+			return nil, false
+		}
+		sendError(w, http.StatusUnauthorized, API_KEY_INVALID, "Invalid, expired or revoked API key")
+		return nil, false
+	}
+
+	scope := requiredScope(r)
+	if scope == "" {
+		sendErrorResponse(w, "This endpoint is not available to API keys", http.StatusForbidden)
+		return nil, false
+	}
+	if !hasScope(principal.Scopes, scope) {
+		sendError(w, http.StatusForbidden, INSUFFICIENT_SCOPE, "API key is missing the '"+scope+"' scope")
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), "adminID", principal.AdminID)
+	ctx = context.WithValue(ctx, "username", principal.Username)
+	ctx = context.WithValue(ctx, "role", principal.Role)
+	ctx = context.WithValue(ctx, "branchID", principal.BranchID)
+	ctx = context.WithValue(ctx, "superAdmin", principal.SuperAdmin)
+	ctx = context.WithValue(ctx, "apiKeyID", principal.ID)
+	info := getRequestInfo(r.Context())
+	info.AdminID = principal.AdminID
+	info.APIKeyID = principal.ID
+	return r.WithContext(ctx), true
+}
+
+// Create a new API key for the current admin
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("adminID").(int)
+	if !ok {
+		sendErrorResponse(w, "Admin not authenticated", http.StatusUnauthorized)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+
+	var req CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Validate input
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		sendErrorResponse(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		sendErrorResponse(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !validAPIKeyScopes[scope] {
+			sendErrorResponse(w, "Invalid scope '"+scope+"'", http.StatusBadRequest)
+			return
+		}
+		// Viewers cannot hand out more access than they have themselves
+		if role == "viewer" && strings.HasSuffix(scope, ":write") {
+			sendErrorResponse(w, "Viewers can only create read-only API keys", http.StatusForbidden)
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		sendErrorResponse(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		sendErrorResponse(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := APIKey{
+		Name:      req.Name,
+		AdminID:   adminID,
+		Prefix:    key[:apiKeyPrefixLen],
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	apiKey.Username, _ = r.Context().Value("username").(string)
+
+	err = db.QueryRowContext(r.Context(),
+		CREATE_API_KEY,
+		apiKey.Name,
+		apiKey.AdminID,
+		apiKey.Prefix,
+		hashAPIKey(key),
+		pq.Array(apiKey.Scopes),
+		apiKey.ExpiresAt,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.UpdatedAt)
+
+	if err != nil {
+		sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	response := DataResp[CreateAPIKeyResponse]{
+		D: CreateAPIKeyResponse{
+			Key:    key,
+			APIKey: apiKey,
+		},
+		Msg: "API key created successfully. Store the key now, it will not be shown again",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// List API keys - admins see every key, everyone else only their own
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("adminID").(int)
+	if !ok {
+		sendErrorResponse(w, "Admin not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	ownerFilter := adminID
+	if role, _ := r.Context().Value("role").(string); role == "admin" {
+		ownerFilter = 0
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_API_KEYS, ownerFilter)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	apiKeys := []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		err := rows.Scan(
+			&apiKey.ID, &apiKey.Name, &apiKey.AdminID, &apiKey.Username, &apiKey.Prefix,
+			pq.Array(&apiKey.Scopes), &apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.RevokedAt,
+			&apiKey.CreatedAt, &apiKey.UpdatedAt,
+		)
+		if err != nil {
+			sendErrorResponse(w, "Failed to scan API key", http.StatusInternalServerError)
+			return
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	response := DataResp[[]APIKey]{
+		D:   apiKeys,
+		Msg: "API keys retrieved successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Revoke an API key - admins can revoke any key, everyone else only their own
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("adminID").(int)
+	if !ok {
+		sendErrorResponse(w, "Admin not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	keyID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	ownerFilter := adminID
+	if role, _ := r.Context().Value("role").(string); role == "admin" {
+		ownerFilter = 0
+	}
+
+	result, err := db.ExecContext(r.Context(), REVOKE_API_KEY, keyID, ownerFilter)
+	if err != nil {
+		sendErrorResponse(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		sendError(w, http.StatusNotFound, API_KEY_NOT_FOUND, API_KEY_NOT_FOUND_MSG)
+		return
+	}
+
+	response := MsgResp{
+		Msg: "API key revoked successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
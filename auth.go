@@ -8,7 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +23,7 @@ type Admin struct {
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
 	Role         string    `json:"role"` // e.g., "admin", "manager", "viewer"
+	BranchID     int       `json:"branchId"`
 	Active       bool      `json:"active"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
@@ -33,20 +34,31 @@ type Claims struct {
 	AdminID  int    `json:"admin_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	BranchID int    `json:"branch_id"`
 	jwt.RegisteredClaims
 }
 
 // Login request structure
 type LoginRequest struct {
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"password" trim:"false"`
 }
 
 // Register request structure
 type RegisterAdminRequest struct {
 	Username string `json:"username"`
-	Password string `json:"password"`
-	Role     string `json:"role,omitempty"` // Optional, defaults to "admin"
+	Password string `json:"password" trim:"false"`
+	Role     string `json:"role,omitempty"`     // Optional, defaults to "admin"
+	BranchID int    `json:"branchId,omitempty"` // Optional, defaults to the caller's branch
+}
+
+// Update admin request structure, only the fields sent are changed
+type AdminUpdate struct {
+	Username *string `json:"username"`
+	Password *string `json:"password" trim:"false"`
+	Role     *string `json:"role"`
+	BranchID *int    `json:"branchId"`
+	Active   *bool   `json:"active"`
 }
 
 // Login response structure
@@ -60,20 +72,17 @@ type AdminInfo struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	BranchID int    `json:"branchId"`
 }
 
-// Get JWT secret from environment or use a default (change in production!)
+// Get JWT secret from the configuration (JWT_SECRET)
 func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-secret-key-change-this-in-production"
-	}
-	return []byte(secret)
+	return []byte(appConfig.Auth.JWTSecret)
 }
 
-// Hash password using bcrypt
+// Hash password using bcrypt with the configured cost (BCRYPT_COST)
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), appConfig.Auth.BcryptCost)
 	return string(bytes), err
 }
 
@@ -92,17 +101,19 @@ func generateAPIKey() (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
-// Generate JWT token for admin
-func generateToken(adminID int, username, role string) (string, time.Time, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// Generate JWT token for admin, tokenID identifies the session it belongs to
+func generateToken(adminID int, username, role string, branchID int, tokenID string) (string, time.Time, error) {
+	expirationTime := time.Now().Add(appConfig.Auth.TokenTTL)
 	claims := &Claims{
 		AdminID:  adminID,
 		Username: username,
 		Role:     role,
+		BranchID: branchID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "middleware-finance-app",
+			ID:        tokenID,
 		},
 	}
 
@@ -134,7 +145,7 @@ func verifyToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
-// Authentication middleware - requires JWT token
+// Authentication middleware - requires JWT token or API key
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -143,31 +154,58 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// API keys for machine-to-machine integrations
+		if strings.HasPrefix(authHeader, "ApiKey ") {
+			r, ok := authenticateAPIKey(w, r, strings.TrimPrefix(authHeader, "ApiKey "))
+			if ok {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
 		// Check if it's a Bearer token (JWT)
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			sendErrorResponse(w, "Invalid authorization format. Use 'Bearer <token>'", http.StatusUnauthorized)
+			sendErrorResponse(w, "Invalid authorization format. Use 'Bearer <token>' or 'ApiKey <key>'", http.StatusUnauthorized)
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		claims, err := verifyToken(tokenString)
 		if err != nil {
-			sendErrorResponse(w, "Invalid or expired token", http.StatusUnauthorized)
+			sendError(w, http.StatusUnauthorized, TOKEN_INVALID, "Invalid or expired token")
 			return
 		}
 
-		// Verify admin is still active
-		var active bool
-		err = db.QueryRow("SELECT active FROM admins WHERE id = $1", claims.AdminID).Scan(&active)
-		if err != nil || !active {
-			sendErrorResponse(w, "Admin account is not active", http.StatusUnauthorized)
+		// Verify the session is still live and the admin is still active
+		var sessionID, branchID int
+		var active, superAdmin bool
+		err = db.QueryRowContext(r.Context(), GET_SESSION_FOR_AUTH, claims.ID, claims.AdminID).Scan(&sessionID, &active, &branchID, &superAdmin)
+		if err != nil {
+			sendError(w, http.StatusUnauthorized, SESSION_EXPIRED, "Session has expired or been signed out")
+			return
+		}
+		// Every query is scoped to the token's branch, so a token issued before
+		// the admin moved branch (or before branches existed) must not be used
+		if branchID != claims.BranchID {
+			sendError(w, http.StatusUnauthorized, SESSION_EXPIRED, "Branch has changed, sign in again")
+			return
+		}
+		if !active {
+			sendError(w, http.StatusUnauthorized, ACCOUNT_INACTIVE, "Admin account is not active")
 			return
 		}
 
+		// Best effort - a failed timestamp update should not block the request
+		db.ExecContext(r.Context(), TOUCH_ADMIN_SESSION, sessionID)
+
 		// Add admin info to request context
 		ctx := context.WithValue(r.Context(), "adminID", claims.AdminID)
 		ctx = context.WithValue(ctx, "username", claims.Username)
 		ctx = context.WithValue(ctx, "role", claims.Role)
+		ctx = context.WithValue(ctx, "branchID", claims.BranchID)
+		ctx = context.WithValue(ctx, "superAdmin", superAdmin)
+		ctx = context.WithValue(ctx, "sessionID", sessionID)
+		getRequestInfo(r.Context()).AdminID = claims.AdminID
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -175,12 +213,9 @@ func authMiddleware(next http.Handler) http.Handler {
 // Login handler for admins
 func adminLogin(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
 	// Validate input
 	if req.Username == "" || req.Password == "" {
@@ -190,36 +225,51 @@ func adminLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Get admin from database
 	var admin Admin
-	err = db.QueryRow(`
-		SELECT id, username, password_hash, role, active 
+	err := db.QueryRowContext(r.Context(), `
+		SELECT id, username, password_hash, role, branch_id, active 
 		FROM admins 
 		WHERE username = $1
-	`, req.Username).Scan(&admin.ID, &admin.Username, &admin.PasswordHash, &admin.Role, &admin.Active)
+	`, req.Username).Scan(&admin.ID, &admin.Username, &admin.PasswordHash, &admin.Role, &admin.BranchID, &admin.Active)
 
 	if err != nil {
-		sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
+		recordLogin(false)
+		sendError(w, http.StatusUnauthorized, INVALID_CREDENTIALS, "Invalid credentials")
 		return
 	}
 
 	// Check if admin is active
 	if !admin.Active {
-		sendErrorResponse(w, "Admin account is not active", http.StatusUnauthorized)
+		recordLogin(false)
+		sendError(w, http.StatusUnauthorized, ACCOUNT_INACTIVE, "Admin account is not active")
 		return
 	}
 
 	// Verify password
 	if !checkPasswordHash(req.Password, admin.PasswordHash) {
-		sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
+		recordLogin(false)
+		sendError(w, http.StatusUnauthorized, INVALID_CREDENTIALS, "Invalid credentials")
 		return
 	}
 
 	// Generate JWT token
-	token, expiresAt, err := generateToken(admin.ID, admin.Username, admin.Role)
+	tokenID, err := generateTokenID()
 	if err != nil {
 		sendErrorResponse(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
+	token, expiresAt, err := generateToken(admin.ID, admin.Username, admin.Role, admin.BranchID, tokenID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Track the login as a session so it can be listed and signed out remotely
+	if err := createSession(admin.ID, tokenID, expiresAt, r); err != nil {
+		sendErrorResponse(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
 	// Send response
 	response := DataResp[LoginResponse]{
 		D: LoginResponse{
@@ -229,10 +279,12 @@ func adminLogin(w http.ResponseWriter, r *http.Request) {
 				ID:       admin.ID,
 				Username: admin.Username,
 				Role:     admin.Role,
+				BranchID: admin.BranchID,
 			},
 		},
 		Msg: "Login successful",
 	}
+	recordLogin(true)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -248,12 +300,9 @@ func registerAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req RegisterAdminRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
 	// Validate input
 	if req.Username == "" || req.Password == "" {
@@ -278,6 +327,11 @@ func registerAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	branchID, ok := resolveBranch(w, r, req.BranchID)
+	if !ok {
+		return
+	}
+
 	// Hash the password
 	passwordHash, err := hashPassword(req.Password)
 	if err != nil {
@@ -287,18 +341,15 @@ func registerAdmin(w http.ResponseWriter, r *http.Request) {
 
 	// Insert admin
 	var adminID int
-	err = db.QueryRow(`
-		INSERT INTO admins (username, password_hash, role, active, created_at, updated_at)
-		VALUES ($1, $2, $3, true, NOW(), NOW())
+	err = db.QueryRowContext(r.Context(), `
+		INSERT INTO admins (username, password_hash, role, branch_id, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, true, NOW(), NOW())
 		RETURNING id
-	`, req.Username, passwordHash, req.Role).Scan(&adminID)
+	`, req.Username, passwordHash, req.Role, branchID).Scan(&adminID)
 
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
-			sendErrorResponse(w, "Username already exists", http.StatusConflict)
-		} else {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
-		}
+		// A taken username is reported as USERNAME_TAKEN, an unknown branch as BRANCH_NOT_FOUND
+		sendDBError(w, r, err)
 		return
 	}
 
@@ -308,6 +359,7 @@ func registerAdmin(w http.ResponseWriter, r *http.Request) {
 			ID:       adminID,
 			Username: req.Username,
 			Role:     req.Role,
+			BranchID: branchID,
 		},
 		Msg: "Admin registered successfully",
 	}
@@ -326,14 +378,14 @@ func getCurrentAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var admin Admin
-	err := db.QueryRow(`
-		SELECT id, username, role, active, created_at, updated_at 
+	err := db.QueryRowContext(r.Context(), `
+		SELECT id, username, role, branch_id, active, created_at, updated_at 
 		FROM admins 
 		WHERE id = $1
-	`, adminID).Scan(&admin.ID, &admin.Username, &admin.Role, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
+	`, adminID).Scan(&admin.ID, &admin.Username, &admin.Role, &admin.BranchID, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
 
 	if err != nil {
-		sendErrorResponse(w, "Admin not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, ADMIN_NOT_FOUND, ADMIN_NOT_FOUND_MSG)
 		return
 	}
 
@@ -355,11 +407,12 @@ func getAllAdmins(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.Query(`
-		SELECT id, username, role, active, created_at, updated_at 
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT id, username, role, branch_id, active, created_at, updated_at 
 		FROM admins 
+		WHERE ($1 = 0 OR branch_id = $1)
 		ORDER BY id
-	`)
+	`, branchScope(r))
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch admins", http.StatusInternalServerError)
 		return
@@ -369,7 +422,7 @@ func getAllAdmins(w http.ResponseWriter, r *http.Request) {
 	var admins []Admin
 	for rows.Next() {
 		var admin Admin
-		err := rows.Scan(&admin.ID, &admin.Username, &admin.Role, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
+		err := rows.Scan(&admin.ID, &admin.Username, &admin.Role, &admin.BranchID, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
 		if err != nil {
 			sendErrorResponse(w, "Failed to scan admin", http.StatusInternalServerError)
 			return
@@ -386,6 +439,19 @@ func getAllAdmins(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// superAdminUpdate rejects the changes that cannot be made to the super admin:
+// its role and active status are fixed, and only it can change its own
+// username or password
+func superAdminUpdate(req AdminUpdate, bySuperAdmin bool) *APIError {
+	if req.Role != nil || req.Active != nil {
+		return newAPIError(http.StatusForbidden, FORBIDDEN, "Cannot modify role or active status of super admin")
+	}
+	if (req.Username != nil || req.Password != nil) && !bySuperAdmin {
+		return newAPIError(http.StatusForbidden, FORBIDDEN, "Only the super admin can change its username or password")
+	}
+	return nil
+}
+
 // Update admin
 func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	// Check if requester is admin
@@ -400,31 +466,22 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	adminID := vars["id"]
 
 	// Check if target is the super admin
-	var targetUsername string
-	err := db.QueryRow("SELECT username FROM admins WHERE id = $1", adminID).Scan(&targetUsername)
+	var targetSuperAdmin bool
+	err := db.QueryRowContext(r.Context(), "SELECT is_super_admin FROM admins WHERE id = $1 AND ($2 = 0 OR branch_id = $2)", adminID, branchScope(r)).Scan(&targetSuperAdmin)
 	if err != nil {
-		sendErrorResponse(w, "Admin not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, ADMIN_NOT_FOUND, ADMIN_NOT_FOUND_MSG)
 		return
 	}
 
-	var req struct {
-		Username *string `json:"username"`
-		Password *string `json:"password"`
-		Role     *string `json:"role"`
-		Active   *bool   `json:"active"`
-	}
-
-	err = json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+	var req AdminUpdate
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
 	// Prevent modifying super admin's critical fields
-	if targetUsername == "admin" {
-		if req.Role != nil || req.Active != nil {
-			sendErrorResponse(w, "Cannot modify role or active status of super admin", http.StatusForbidden)
+	if targetSuperAdmin {
+		if apiErr := superAdminUpdate(req, isSuperAdmin(r)); apiErr != nil {
+			sendAPIError(w, apiErr)
 			return
 		}
 	}
@@ -463,6 +520,17 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		paramCount++
 	}
 
+	if req.BranchID != nil {
+		// Moving an admin between branches widens what they can see
+		if !isSuperAdmin(r) {
+			sendError(w, http.StatusForbidden, BRANCH_ACCESS_DENIED, "Only the super admin can move admins between branches")
+			return
+		}
+		updates = append(updates, "branch_id = $"+fmt.Sprint(paramCount))
+		args = append(args, *req.BranchID)
+		paramCount++
+	}
+
 	if req.Active != nil {
 		updates = append(updates, "active = $"+fmt.Sprint(paramCount))
 		args = append(args, *req.Active)
@@ -482,25 +550,34 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 
 	query := "UPDATE admins SET " + strings.Join(updates, ", ") + " WHERE id = $" + fmt.Sprint(paramCount)
 
-	result, err := db.Exec(query, args...)
+	result, err := db.ExecContext(r.Context(), query, args...)
 	if err != nil {
-		sendErrorResponse(w, "Failed to update admin", http.StatusInternalServerError)
+		sendDBError(w, r, err)
 		return
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil || rowsAffected == 0 {
-		sendErrorResponse(w, "Admin not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, ADMIN_NOT_FOUND, ADMIN_NOT_FOUND_MSG)
 		return
 	}
 
+	// A password change, branch move or deactivation signs the admin out everywhere
+	if req.Password != nil || req.BranchID != nil || (req.Active != nil && !*req.Active) {
+		targetID, _ := strconv.Atoi(adminID)
+		if err := revokeAllSessions(r.Context(), targetID); err != nil {
+			sendErrorResponse(w, "Failed to terminate sessions", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Fetch updated admin
 	var admin Admin
-	err = db.QueryRow(`
-		SELECT id, username, role, active, created_at, updated_at 
+	err = db.QueryRowContext(r.Context(), `
+		SELECT id, username, role, branch_id, active, created_at, updated_at 
 		FROM admins 
 		WHERE id = $1
-	`, adminID).Scan(&admin.ID, &admin.Username, &admin.Role, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
+	`, adminID).Scan(&admin.ID, &admin.Username, &admin.Role, &admin.BranchID, &admin.Active, &admin.CreatedAt, &admin.UpdatedAt)
 
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch updated admin", http.StatusInternalServerError)
@@ -530,15 +607,15 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 	adminID := vars["id"]
 
 	// Check if target is the super admin
-	var targetUsername string
-	err := db.QueryRow("SELECT username FROM admins WHERE id = $1", adminID).Scan(&targetUsername)
+	var targetSuperAdmin bool
+	err := db.QueryRowContext(r.Context(), "SELECT is_super_admin FROM admins WHERE id = $1 AND ($2 = 0 OR branch_id = $2)", adminID, branchScope(r)).Scan(&targetSuperAdmin)
 	if err != nil {
-		sendErrorResponse(w, "Admin not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, ADMIN_NOT_FOUND, ADMIN_NOT_FOUND_MSG)
 		return
 	}
 
 	// Prevent deleting super admin
-	if targetUsername == "admin" {
+	if targetSuperAdmin {
 		sendErrorResponse(w, "Cannot delete the super admin account", http.StatusForbidden)
 		return
 	}
@@ -550,7 +627,7 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM admins WHERE id = $1", adminID)
+	result, err := db.ExecContext(r.Context(), "DELETE FROM admins WHERE id = $1", adminID)
 	if err != nil {
 		sendErrorResponse(w, "Failed to delete admin", http.StatusInternalServerError)
 		return
@@ -558,7 +635,7 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil || rowsAffected == 0 {
-		sendErrorResponse(w, "Admin not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, ADMIN_NOT_FOUND, ADMIN_NOT_FOUND_MSG)
 		return
 	}
 
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSuperAdminUpdate(t *testing.T) {
+	username := "root"
+	password := "correct horse battery staple"
+	role := "viewer"
+	active := false
+
+	tests := []struct {
+		name         string
+		req          AdminUpdate
+		bySuperAdmin bool
+		wantErr      bool
+	}{
+		{"own username", AdminUpdate{Username: &username}, true, false},
+		{"own password", AdminUpdate{Password: &password}, true, false},
+		{"renamed by another admin", AdminUpdate{Username: &username}, false, true},
+		{"password reset by another admin", AdminUpdate{Password: &password}, false, true},
+		{"demoted", AdminUpdate{Role: &role}, true, true},
+		{"deactivated", AdminUpdate{Active: &active}, false, true},
+		{"nothing", AdminUpdate{}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := superAdminUpdate(tt.req, tt.bySuperAdmin)
+			if (apiErr != nil) != tt.wantErr {
+				t.Errorf("expected an error %v, got %+v", tt.wantErr, apiErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Branch is an office of the business. Customers, handouts, collections and
+// admins each belong to one branch.
+type Branch struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Code      string    `json:"code"`
+	Address   string    `json:"address"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type CreateBranchRequest struct {
+	Name    string `json:"name"`
+	Code    string `json:"code"`
+	Address string `json:"address"`
+}
+
+// UpdateBranchRequest only changes the fields that are present
+type UpdateBranchRequest struct {
+	Name    *string `json:"name"`
+	Code    *string `json:"code"`
+	Address *string `json:"address"`
+	Active  *bool   `json:"active"`
+}
+
+type TransferCustomerRequest struct {
+	BranchID int    `json:"branchId"`
+	Reason   string `json:"reason"`
+}
+
+// CustomerTransfer records a customer moved to another branch with their loans
+type CustomerTransfer struct {
+	ID            int       `json:"id"`
+	CustomerID    int       `json:"customerId"`
+	FromBranchID  int       `json:"fromBranchId"`
+	ToBranchID    int       `json:"toBranchId"`
+	HandoutsMoved int       `json:"handoutsMoved"`
+	Reason        string    `json:"reason"`
+	TransferredBy int       `json:"transferredBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// callerBranch is the branch of the authenticated admin
+func callerBranch(r *http.Request) int {
+	branchID, _ := r.Context().Value("branchID").(int)
+	return branchID
+}
+
+// branchScope returns the branch the caller's queries are limited to. The
+// super admin works across branches and gets 0, which the scoped queries in
+// querys.go treat as every branch.
+func branchScope(r *http.Request) int {
+	if isSuperAdmin(r) {
+		return 0
+	}
+	return callerBranch(r)
+}
+
+// resolveBranch picks the branch for a new record: the requested one for the
+// super admin, otherwise the caller's own. It writes a 403 and returns false
+// when someone else asks for a branch other than their own.
+func resolveBranch(w http.ResponseWriter, r *http.Request, requested int) (int, bool) {
+	if requested == 0 {
+		return callerBranch(r), true
+	}
+	if requested != callerBranch(r) && !isSuperAdmin(r) {
+		sendError(w, http.StatusForbidden, BRANCH_ACCESS_DENIED, "Only the super admin can create records in another branch")
+		return 0, false
+	}
+	return requested, true
+}
+
+// validateBranch returns every invalid field of a new branch, nil when it is valid
+func validateBranch(branch CreateBranchRequest) []FieldError {
+	var fields []FieldError
+
+	if branch.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Code: REQUIRED, Message: "name cannot be empty"})
+	}
+
+	if branch.Code == "" {
+		fields = append(fields, FieldError{Field: "code", Code: REQUIRED, Message: "code cannot be empty"})
+	}
+	return fields
+}
+
+func scanBranch(row interface{ Scan(...any) error }) (branch Branch, err error) {
+	err = row.Scan(&branch.ID, &branch.Name, &branch.Code, &branch.Address,
+		&branch.Active, &branch.CreatedAt, &branch.UpdatedAt)
+	return branch, err
+}
+
+func getBranches(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), GET_ALL_BRANCHES)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	branches := []Branch{}
+	for rows.Next() {
+		branch, err := scanBranch(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		branches = append(branches, branch)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]Branch]{
+		D:   branches,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func createBranch(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can create branches", http.StatusForbidden)
+		return
+	}
+
+	var req CreateBranchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if fields := validateBranch(req); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	branch, err := scanBranch(db.QueryRowContext(r.Context(), CREATE_BRANCH, req.Name, req.Code, req.Address))
+	if err != nil {
+		// Taken names and codes are reported as BRANCH_NAME_TAKEN / BRANCH_CODE_TAKEN
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[Branch]{
+		D:   branch,
+		Msg: "Branch created successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func updateBranch(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can update branches", http.StatusForbidden)
+		return
+	}
+
+	branchID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req UpdateBranchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.Name != nil && *req.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Code: REQUIRED, Message: "name cannot be empty"})
+	}
+	if req.Code != nil && *req.Code == "" {
+		fields = append(fields, FieldError{Field: "code", Code: REQUIRED, Message: "code cannot be empty"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	branch, err := scanBranch(db.QueryRowContext(r.Context(), UPDATE_BRANCH, req.Name, req.Code, req.Address, req.Active, branchID))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, BRANCH_NOT_FOUND, BRANCH_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[Branch]{
+		D:   branch,
+		Msg: "Branch updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// transferCustomer moves a customer to another branch together with their
+// handouts and collections, in one transaction
+func transferCustomer(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can transfer customers between branches", http.StatusForbidden)
+		return
+	}
+
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req TransferCustomerRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.BranchID <= 0 {
+		fields = append(fields, FieldError{Field: "branchId", Code: REQUIRED, Message: "branch cannot be empty"})
+	}
+	if req.Reason == "" {
+		fields = append(fields, FieldError{Field: "reason", Code: REQUIRED, Message: "reason cannot be empty"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	target, err := scanBranch(db.QueryRowContext(r.Context(), GET_BRANCH_BY_ID, req.BranchID))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, BRANCH_NOT_FOUND, BRANCH_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !target.Active {
+		sendError(w, http.StatusConflict, BRANCH_INACTIVE, "Cannot transfer to an inactive branch")
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	transfer := CustomerTransfer{
+		CustomerID: customerID,
+		ToBranchID: target.ID,
+		Reason:     req.Reason,
+	}
+	transfer.TransferredBy, _ = r.Context().Value("adminID").(int)
+
+	err = tx.QueryRow(LOCK_CUSTOMER_BRANCH, customerID).Scan(&transfer.FromBranchID)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if transfer.FromBranchID == transfer.ToBranchID {
+		sendError(w, http.StatusConflict, SAME_BRANCH, "Customer already belongs to this branch")
+		return
+	}
+
+	if _, err = tx.Exec(TRANSFER_CUSTOMER, transfer.ToBranchID, customerID); err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	result, err := tx.Exec(TRANSFER_CUSTOMER_HANDOUTS, transfer.ToBranchID, customerID)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	transfer.HandoutsMoved = int(moved)
+	if _, err = tx.Exec(TRANSFER_CUSTOMER_COLLECTIONS, transfer.ToBranchID, customerID); err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	err = tx.QueryRow(CREATE_CUSTOMER_TRANSFER,
+		customerID, transfer.FromBranchID, transfer.ToBranchID,
+		transfer.HandoutsMoved, transfer.Reason, transfer.TransferredBy,
+	).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[CustomerTransfer]{
+		D:   transfer,
+		Msg: "Customer transferred successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getCustomerTransfers lists the branch transfers of a customer visible to the caller
+func getCustomerTransfers(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_CUSTOMER_TRANSFERS, customerID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	transfers := []CustomerTransfer{}
+	for rows.Next() {
+		var transfer CustomerTransfer
+		err := rows.Scan(&transfer.ID, &transfer.CustomerID, &transfer.FromBranchID, &transfer.ToBranchID,
+			&transfer.HandoutsMoved, &transfer.Reason, &transfer.TransferredBy, &transfer.CreatedAt)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]CustomerTransfer]{
+		D:   transfers,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
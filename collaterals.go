@@ -0,0 +1,483 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// validCollateralTypes mirrors the collateral_type enum in sql/migration-13.sql
+var validCollateralTypes = map[string]bool{
+	"PROMISSORY_NOTE": true,
+	"CHEQUE":          true,
+	"GOLD":            true,
+	"VEHICLE_PAPERS":  true,
+	"PROPERTY_PAPERS": true,
+	"OTHER":           true,
+}
+
+// Collateral is an item held against a handout until it is returned
+type Collateral struct {
+	ID              int        `json:"id"`
+	HandoutID       int        `json:"handoutId"`
+	Type            string     `json:"type"`
+	Description     string     `json:"description"`
+	DeclaredValue   Money      `json:"declaredValue"`
+	AssessedValue   *Money     `json:"assessedValue"` // nil until the branch has valued it
+	CustodyLocation string     `json:"custodyLocation"`
+	ReceivedOn      time.Time  `json:"receivedOn"`
+	ReceivedBy      int        `json:"receivedBy"` // 0 for bonds recorded before the register
+	ReturnedOn      *time.Time `json:"returnedOn"` // nil while held
+	ReturnedBy      int        `json:"returnedBy"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// CollateralDetails are the fields of a collateral that can be changed while it is held
+type CollateralDetails struct {
+	Type            string `json:"type"`
+	Description     string `json:"description"`
+	DeclaredValue   Money  `json:"declaredValue"`
+	AssessedValue   *Money `json:"assessedValue"`
+	CustodyLocation string `json:"custodyLocation"`
+}
+
+type CreateCollateralRequest struct {
+	CollateralDetails
+	ReceivedOn string `json:"receivedOn"` // YYYY-MM-DD, defaults to today
+}
+
+type ReleaseCollateralRequest struct {
+	ReturnedOn string `json:"returnedOn"` // YYYY-MM-DD, defaults to today
+}
+
+// CollateralCoverage compares what is still owed on a handout with the value of the collateral held
+type CollateralCoverage struct {
+	HandoutID       int                    `json:"handoutId"`
+	Amount          Money                  `json:"amount"`
+	Status          string                 `json:"status"`
+	BranchID        int                    `json:"branchId"`
+	Customer        HandoutCustomerDetails `json:"customer"`
+	Collected       Money                  `json:"collected"`
+	Outstanding     Money                  `json:"outstanding"`
+	CollateralValue Money                  `json:"collateralValue"`
+	CollateralItems int                    `json:"collateralItems"`
+	LoanToValue     *float64               `json:"loanToValue"` // percent, nil without valued collateral
+}
+
+type CollateralRegister struct {
+	Collaterals []Collateral       `json:"collaterals"`
+	Coverage    CollateralCoverage `json:"coverage"`
+}
+
+type LoanToValueReport struct {
+	Loans                []CollateralCoverage `json:"loans"`
+	TotalOutstanding     Money                `json:"totalOutstanding"`
+	TotalCollateralValue Money                `json:"totalCollateralValue"`
+	LoanToValue          *float64             `json:"loanToValue"`
+	UnsecuredLoans       int                  `json:"unsecuredLoans"` // loans without valued collateral
+}
+
+// loanToValue is outstanding as a percent of value, rounded to two decimals.
+// It is nil when there is no collateral value to compare with.
+func loanToValue(outstanding, value Money) *float64 {
+	if value <= 0 {
+		return nil
+	}
+	ratio := math.Round(float64(outstanding)*10000/float64(value)) / 100
+	return &ratio
+}
+
+// validateCollateral returns every invalid field of a collateral, nil when it is valid
+func validateCollateral(collateral CollateralDetails) []FieldError {
+	var fields []FieldError
+
+	if !validCollateralTypes[collateral.Type] {
+		fields = append(fields, FieldError{Field: "type", Code: INVALID_VALUE, Message: "type must be PROMISSORY_NOTE, CHEQUE, GOLD, VEHICLE_PAPERS, PROPERTY_PAPERS or OTHER"})
+	}
+
+	if collateral.Description == "" {
+		fields = append(fields, FieldError{Field: "description", Code: REQUIRED, Message: "description cannot be empty"})
+	}
+
+	if collateral.DeclaredValue < 0 {
+		fields = append(fields, FieldError{Field: "declaredValue", Code: INVALID_VALUE, Message: "enter a valid amount"})
+	}
+
+	if collateral.AssessedValue != nil && *collateral.AssessedValue < 0 {
+		fields = append(fields, FieldError{Field: "assessedValue", Code: INVALID_VALUE, Message: "enter a valid amount"})
+	}
+	return fields
+}
+
+// parsePastDate parses an optional YYYY-MM-DD date that cannot be in the
+// future, defaulting to today. It returns a field error when it is invalid.
+func parsePastDate(field, raw string) (time.Time, *FieldError) {
+	if raw == "" {
+		return today(), nil
+	}
+	date, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, &FieldError{Field: field, Code: INVALID_VALUE, Message: "must be a date like 2025-12-31"}
+	}
+	if date.After(today()) {
+		return time.Time{}, &FieldError{Field: field, Code: INVALID_VALUE, Message: "cannot be in the future"}
+	}
+	return date, nil
+}
+
+func scanCollateral(row interface{ Scan(...any) error }) (collateral Collateral, err error) {
+	err = row.Scan(&collateral.ID, &collateral.HandoutID, &collateral.Type, &collateral.Description,
+		&collateral.DeclaredValue, &collateral.AssessedValue, &collateral.CustodyLocation,
+		&collateral.ReceivedOn, &collateral.ReceivedBy, &collateral.ReturnedOn, &collateral.ReturnedBy,
+		&collateral.CreatedAt, &collateral.UpdatedAt)
+	return collateral, err
+}
+
+// collateralIDs reads the handout and collateral IDs from the URL
+func collateralIDs(w http.ResponseWriter, r *http.Request) (handoutID, collateralID int, ok bool) {
+	vars := mux.Vars(r)
+	handoutID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return 0, 0, false
+	}
+	collateralID, err = strconv.Atoi(vars["collateralId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return 0, 0, false
+	}
+	return handoutID, collateralID, true
+}
+
+// collateralCoverage reads the rows of GET_COLLATERAL_EXPOSURE
+func collateralCoverage(r *http.Request, handoutID int) ([]CollateralCoverage, error) {
+	rows, err := db.QueryContext(r.Context(), GET_COLLATERAL_EXPOSURE, handoutID, branchScope(r))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []CollateralCoverage{}
+	for rows.Next() {
+		var loan CollateralCoverage
+		err := rows.Scan(&loan.HandoutID, &loan.Amount, &loan.Status, &loan.BranchID,
+			&loan.Customer.ID, &loan.Customer.Name, &loan.Customer.Mobile,
+			&loan.Collected, &loan.CollateralValue, &loan.CollateralItems)
+		if err != nil {
+			return nil, err
+		}
+		loan.Outstanding = outstanding(loan.Status, loan.Amount, loan.Collected)
+		loan.LoanToValue = loanToValue(loan.Outstanding, loan.CollateralValue)
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+// getHandoutCollaterals writes the collateral register of a handout with its loan-to-value
+func getHandoutCollaterals(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	coverage, err := collateralCoverage(r, handoutID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if len(coverage) == 0 {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_HANDOUT_COLLATERALS, handoutID, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	register := CollateralRegister{Collaterals: []Collateral{}, Coverage: coverage[0]}
+	for rows.Next() {
+		collateral, err := scanCollateral(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		register.Collaterals = append(register.Collaterals, collateral)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[CollateralRegister]{
+		D:   register,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// addHandoutCollateral records collateral received for a handout by the calling admin
+func addHandoutCollateral(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var input CreateCollateralRequest
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	fields := validateCollateral(input.CollateralDetails)
+	receivedOn, fieldErr := parsePastDate("receivedOn", input.ReceivedOn)
+	if fieldErr != nil {
+		fields = append(fields, *fieldErr)
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	receivedBy, _ := r.Context().Value("adminID").(int)
+	var collateralID int
+	err = db.QueryRowContext(r.Context(), CREATE_COLLATERAL, handoutID, input.Type, input.Description,
+		input.DeclaredValue, input.AssessedValue, input.CustodyLocation, receivedOn, receivedBy).Scan(&collateralID)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	collateral, err := scanCollateral(db.QueryRowContext(r.Context(), GET_COLLATERAL, collateralID, handoutID, branchScope(r)))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[Collateral]{
+		D:   collateral,
+		Msg: "Collateral recorded successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// putHandoutCollateral replaces the details of a collateral that is still held,
+// e.g. once it has been assessed or moved to another vault
+func putHandoutCollateral(w http.ResponseWriter, r *http.Request) {
+	handoutID, collateralID, ok := collateralIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var input CollateralDetails
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	if fields := validateCollateral(input); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), UPDATE_COLLATERAL, input.Type, input.Description, input.DeclaredValue,
+		input.AssessedValue, input.CustodyLocation, collateralID, handoutID, branchScope(r))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	if !collateralChanged(w, r, result, collateralID, handoutID) {
+		return
+	}
+
+	resp := MsgResp{
+		Msg: "Collateral updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deleteHandoutCollateral removes a collateral recorded by mistake. Released
+// collateral stays in the register.
+func deleteHandoutCollateral(w http.ResponseWriter, r *http.Request) {
+	handoutID, collateralID, ok := collateralIDs(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), DELETE_COLLATERAL, collateralID, handoutID, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !collateralChanged(w, r, result, collateralID, handoutID) {
+		return
+	}
+
+	resp := MsgResp{
+		Msg: "Collateral deleted successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// collateralChanged checks that an update or delete of a held collateral
+// matched a row. Otherwise it writes 404 when the collateral does not exist,
+// or 409 when it has already been released, and returns false.
+func collateralChanged(w http.ResponseWriter, r *http.Request, result sql.Result, collateralID, handoutID int) bool {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return false
+	}
+	if rowsAffected > 0 {
+		return true
+	}
+
+	_, err = scanCollateral(db.QueryRowContext(r.Context(), GET_COLLATERAL, collateralID, handoutID, branchScope(r)))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, COLLATERAL_NOT_FOUND, COLLATERAL_NOT_FOUND_MSG)
+		return false
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return false
+	}
+	sendError(w, http.StatusConflict, COLLATERAL_RELEASED, "Collateral has already been returned")
+	return false
+}
+
+// releaseHandoutCollateral returns a collateral to the customer, which is only
+// allowed once the handout is COMPLETED
+func releaseHandoutCollateral(w http.ResponseWriter, r *http.Request) {
+	handoutID, collateralID, ok := collateralIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var input ReleaseCollateralRequest
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	returnedOn, fieldErr := parsePastDate("returnedOn", input.ReturnedOn)
+	if fieldErr != nil {
+		sendValidationErrors(w, []FieldError{*fieldErr})
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the handout keeps it from being reopened while the collateral goes back
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_STATUS, handoutID, branchScope(r)).Scan(&status)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	collateral, err := scanCollateral(tx.QueryRow(GET_COLLATERAL, collateralID, handoutID, branchScope(r)))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, COLLATERAL_NOT_FOUND, COLLATERAL_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if collateral.ReturnedOn != nil {
+		sendError(w, http.StatusConflict, COLLATERAL_RELEASED, "Collateral has already been returned")
+		return
+	}
+	if status != "COMPLETED" {
+		sendError(w, http.StatusConflict, HANDOUT_NOT_COMPLETED, "Collateral can only be returned once the handout is COMPLETED, it is "+status)
+		return
+	}
+	if returnedOn.Before(collateral.ReceivedOn) {
+		sendValidationErrors(w, []FieldError{{Field: "returnedOn", Code: INVALID_VALUE, Message: "cannot be before the collateral was received"}})
+		return
+	}
+
+	returnedBy, _ := r.Context().Value("adminID").(int)
+	if _, err := tx.Exec(RELEASE_COLLATERAL, returnedOn, returnedBy, collateralID, handoutID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := MsgResp{
+		Msg: "Collateral returned successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getLoanToValueReport lists the ACTIVE and PENDING handouts with their
+// loan-to-value. ?above= keeps only loans above that percent, plus unsecured ones.
+func getLoanToValueReport(w http.ResponseWriter, r *http.Request) {
+	var above *float64
+	if raw := r.URL.Query().Get("above"); raw != "" {
+		threshold, err := strconv.ParseFloat(raw, 64)
+		if err != nil || threshold < 0 {
+			sendValidationErrors(w, []FieldError{{Field: "above", Code: INVALID_VALUE, Message: "must be a percent, e.g. 80"}})
+			return
+		}
+		above = &threshold
+	}
+
+	loans, err := collateralCoverage(r, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	report := LoanToValueReport{Loans: []CollateralCoverage{}}
+	for _, loan := range loans {
+		if loan.Outstanding == 0 {
+			continue
+		}
+		report.TotalOutstanding += loan.Outstanding
+		report.TotalCollateralValue += loan.CollateralValue
+		if loan.LoanToValue == nil {
+			report.UnsecuredLoans++
+		} else if above != nil && *loan.LoanToValue <= *above {
+			continue
+		}
+		report.Loans = append(report.Loans, loan)
+	}
+	report.LoanToValue = loanToValue(report.TotalOutstanding, report.TotalCollateralValue)
+
+	resp := DataResp[LoanToValueReport]{
+		D:   report,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
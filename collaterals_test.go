@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoanToValue(t *testing.T) {
+	if got := loanToValue(5000, 0); got != nil {
+		t.Errorf("loanToValue without collateral = %v, want nil", *got)
+	}
+
+	tests := []struct {
+		outstanding, value Money
+		want               float64
+	}{
+		{75000, 100000, 75},
+		{100000, 30000, 333.33},
+		{0, 100000, 0},
+	}
+	for _, tt := range tests {
+		got := loanToValue(tt.outstanding, tt.value)
+		if got == nil || *got != tt.want {
+			t.Errorf("loanToValue(%d, %d) = %v, want %v", tt.outstanding, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestOutstanding(t *testing.T) {
+	tests := []struct {
+		status            string
+		amount, collected Money
+		want              Money
+	}{
+		{"ACTIVE", 10000, 2500, 7500},
+		{"PENDING", 10000, 0, 10000},
+		{"ACTIVE", 10000, 12000, 0},
+		{"COMPLETED", 10000, 2500, 0},
+		{"CANCELLED", 10000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := outstanding(tt.status, tt.amount, tt.collected); got != tt.want {
+			t.Errorf("outstanding(%s, %d, %d) = %d, want %d", tt.status, tt.amount, tt.collected, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCollateral(t *testing.T) {
+	negative := Money(-1)
+	fields := validateCollateral(CollateralDetails{Type: "HOUSE", DeclaredValue: -1, AssessedValue: &negative})
+	if len(fields) != 4 {
+		t.Fatalf("got %d field errors, want 4: %+v", len(fields), fields)
+	}
+
+	fields = validateCollateral(CollateralDetails{Type: "GOLD", Description: "2 bangles, 22 carat", DeclaredValue: 8000000})
+	if len(fields) != 0 {
+		t.Errorf("expected a valid collateral, got %+v", fields)
+	}
+}
+
+func TestParsePastDate(t *testing.T) {
+	if date, fieldErr := parsePastDate("receivedOn", ""); fieldErr != nil || !date.Equal(today()) {
+		t.Errorf("empty date = %v, %v, want today", date, fieldErr)
+	}
+	if date, fieldErr := parsePastDate("receivedOn", "2025-03-01"); fieldErr != nil || date.Format(time.DateOnly) != "2025-03-01" {
+		t.Errorf("2025-03-01 = %v, %v", date, fieldErr)
+	}
+	tomorrow := today().AddDate(0, 0, 1).Format(time.DateOnly)
+	for _, raw := range []string{"01/03/2025", tomorrow} {
+		if _, fieldErr := parsePastDate("receivedOn", raw); fieldErr == nil || fieldErr.Field != "receivedOn" {
+			t.Errorf("%s: expected a receivedOn field error, got %+v", raw, fieldErr)
+		}
+	}
+}
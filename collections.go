@@ -10,11 +10,10 @@ import (
 
 func getCollections(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	rows, err := db.Query(GET_ALL_COLLECTIONS)
+	rows, err := db.QueryContext(r.Context(), GET_ALL_COLLECTIONS, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
@@ -26,10 +25,10 @@ func getCollections(w http.ResponseWriter, r *http.Request) {
 
 		err = rows.Scan(
 			&collection.ID, &collection.Date, &collection.Amount, &collection.HandoutId,
-			&collection.CreatedAt, &collection.UpdatedAt,
+			&collection.Recovery, &collection.BranchID, &collection.CreatedAt, &collection.UpdatedAt,
 		)
 		if err != nil {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendInternalError(w, r, err)
 			return
 		}
 
@@ -37,7 +36,7 @@ func getCollections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = rows.Err(); err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -50,18 +49,17 @@ func getCollections(w http.ResponseWriter, r *http.Request) {
 
 func getHandoutCollections(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
-	rows, err := db.Query(GET_HANDOUT_COLLECTIONS, id)
+	rows, err := db.QueryContext(r.Context(), GET_HANDOUT_COLLECTIONS, id, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
@@ -73,10 +71,10 @@ func getHandoutCollections(w http.ResponseWriter, r *http.Request) {
 
 		err = rows.Scan(
 			&collection.ID, &collection.Date, &collection.Amount,
-			&collection.CreatedAt, &collection.UpdatedAt,
+			&collection.Recovery, &collection.BranchID, &collection.CreatedAt, &collection.UpdatedAt,
 		)
 		if err != nil {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendInternalError(w, r, err)
 			return
 		}
 
@@ -84,7 +82,7 @@ func getHandoutCollections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = rows.Err(); err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -97,7 +95,6 @@ func getHandoutCollections(w http.ResponseWriter, r *http.Request) {
 
 func createCollection(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if r.Method != http.MethodPost {
 		sendErrorResponse(w, "Method not allow", http.StatusMethodNotAllowed)
@@ -105,28 +102,34 @@ func createCollection(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var collection Collection
-	err := json.NewDecoder(r.Body).Decode(&collection)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &collection) {
 		return
 	}
-	err = validateCollection(collection)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if fields := validateCollection(collection); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	// The collection belongs to the handout's branch
+	branchID, ok := handoutBranch(w, r, collection.HandoutId)
+	if !ok {
 		return
 	}
 
-	_, dbErr := db.Exec(
+	_, dbErr := db.ExecContext(r.Context(),
 		CREATE_COLLECTION,
 		collection.Date,
 		collection.Amount,
 		collection.HandoutId,
+		branchID,
 	)
 
 	if dbErr != nil {
-		sendErrorResponse(w, dbErr.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, dbErr)
 		return
 	}
+	refreshCustomerRisk(r, riskCustomer(r, GET_HANDOUT_CUSTOMER, collection.HandoutId))
+
 	resp := MsgResp{
 		Msg: "Collection created successfully",
 	}
@@ -136,12 +139,11 @@ func createCollection(w http.ResponseWriter, r *http.Request) {
 
 func deleteCollection(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
@@ -150,11 +152,13 @@ func deleteCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec(DELETE_COLLECTION, id)
+	customerID := riskCustomer(r, GET_COLLECTION_CUSTOMER, id)
+	_, err = db.ExecContext(r.Context(), DELETE_COLLECTION, id, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
+	refreshCustomerRisk(r, customerID)
 
 	resp := MsgResp{
 		Msg: "collection deleted successfully",
@@ -164,38 +168,44 @@ func deleteCollection(w http.ResponseWriter, r *http.Request) {
 
 func putCollection(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	var collection Collection
-	err = json.NewDecoder(r.Body).Decode(&collection)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &collection) {
 		return
 	}
-	err = validateCollection(collection)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if fields := validateCollection(collection); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	branchID, ok := handoutBranch(w, r, collection.HandoutId)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec(
+	// The collection may move to another customer's handout, both are rescored
+	previousCustomerID := riskCustomer(r, GET_COLLECTION_CUSTOMER, id)
+	_, err = db.ExecContext(r.Context(),
 		UPDATE_COLLECTION,
 		collection.Date,
 		collection.Amount,
 		collection.HandoutId,
+		branchID,
 		id,
+		branchScope(r),
 	)
 
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, err)
 		return
 	}
+	refreshCustomerRisk(r, previousCustomerID, riskCustomer(r, GET_HANDOUT_CUSTOMER, collection.HandoutId))
 
 	resp := MsgResp{
 		Msg: "Collection updated successfully",
@@ -0,0 +1,403 @@
+// Package config loads the application settings from defaults, an optional
+// YAML file, a .env file and environment variables, in that order of precedence
+// (environment variables win).
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultJWTSecret is only meant for local development
+const DefaultJWTSecret = "your-secret-key-change-this-in-production"
+
+// SystemResolver selects the operating system DNS resolver for the database host
+const SystemResolver = "system"
+
+// Config is the complete application configuration.
+// Every field declares its YAML key, environment variable, default and description.
+type Config struct {
+	Database DatabaseConfig `yaml:"database"`
+	Server   ServerConfig   `yaml:"server"`
+	Auth     AuthConfig     `yaml:"auth"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Logging  LoggingConfig  `yaml:"logging"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Risk     RiskConfig     `yaml:"risk"`
+	Penalty  PenaltyConfig  `yaml:"penalty"`
+}
+
+type DatabaseConfig struct {
+	URL            string        `yaml:"url" env:"DATABASE_URL" secret:"true" desc:"Postgres connection string, sslmode=require is added when missing"`
+	DNSResolver    string        `yaml:"dnsResolver" env:"DB_DNS_RESOLVER" default:"system" desc:"DNS server (host:port) used to resolve the database host, or \"system\" for the OS resolver"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout" env:"DB_CONNECT_TIMEOUT" default:"5s" desc:"How long startup waits for the first database ping"`
+}
+
+type ServerConfig struct {
+	Port              string        `yaml:"port" env:"PORT" default:"9000" desc:"Port the HTTP server listens on"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"HTTP_READ_TIMEOUT" default:"15s" desc:"Max time to read a full request"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"HTTP_READ_HEADER_TIMEOUT" default:"5s" desc:"Max time to read request headers"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"HTTP_WRITE_TIMEOUT" default:"30s" desc:"Max time to write a response"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"HTTP_IDLE_TIMEOUT" default:"120s" desc:"Keep-alive idle timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"HTTP_SHUTDOWN_TIMEOUT" default:"25s" desc:"How long SIGTERM waits for in-flight requests and background jobs"`
+	MaxHeaderBytes    int           `yaml:"maxHeaderBytes" env:"HTTP_MAX_HEADER_BYTES" default:"1048576" desc:"Max request header size in bytes"`
+	MaxBodyBytes      int           `yaml:"maxBodyBytes" env:"HTTP_MAX_BODY_BYTES" default:"1048576" desc:"Max request body size in bytes, larger bodies get 413"`
+	MaxUploadBytes    int           `yaml:"maxUploadBytes" env:"HTTP_MAX_UPLOAD_BYTES" default:"10485760" desc:"Max size of multipart/form-data request bodies (document uploads)"`
+	HSTSMaxAge        time.Duration `yaml:"hstsMaxAge" env:"HTTP_HSTS_MAX_AGE" default:"8760h" desc:"Strict-Transport-Security max-age sent with every response, 0 disables it"`
+	TLSCertFile       string        `yaml:"tlsCertFile" env:"TLS_CERT_FILE" desc:"PEM certificate to serve HTTPS directly"`
+	TLSKeyFile        string        `yaml:"tlsKeyFile" env:"TLS_KEY_FILE" desc:"PEM private key for tlsCertFile"`
+	TLSClientCAFile   string        `yaml:"tlsClientCaFile" env:"TLS_CLIENT_CA_FILE" desc:"Require client certificates signed by this CA (mutual TLS)"`
+}
+
+type AuthConfig struct {
+	JWTSecret  string        `yaml:"jwtSecret" env:"JWT_SECRET" secret:"true" default:"your-secret-key-change-this-in-production" desc:"HMAC secret used to sign login tokens"`
+	TokenTTL   time.Duration `yaml:"tokenTtl" env:"JWT_TTL" default:"24h" desc:"Lifetime of a login token and its session"`
+	BcryptCost int           `yaml:"bcryptCost" env:"BCRYPT_COST" default:"14" desc:"bcrypt cost factor for admin passwords"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins" env:"CORS_ALLOWED_ORIGINS" default:"http://localhost:5173,https://yogesh-k64.github.io" desc:"Comma separated list of origins allowed to call the API"`
+	TrustedProxies []string `yaml:"trustedProxies" env:"TRUSTED_PROXIES" desc:"Comma separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted, empty trusts none"`
+}
+
+// TrustsProxy reports whether ip belongs to one of the trusted proxies
+func (c CORSConfig) TrustsProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" default:"info" desc:"Minimum log level: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" default:"json" desc:"Log output format: json or text"`
+}
+
+type MetricsConfig struct {
+	Addr  string `yaml:"addr" env:"METRICS_ADDR" desc:"Separate listen address for /metrics (e.g. :9100), keeps it off the public port"`
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true" desc:"Bearer token required to scrape /metrics; /metrics is disabled when neither addr nor token is set"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" default:"none" desc:"Where spans are sent: none, otlp, stdout or file"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_OTLP_ENDPOINT" desc:"OTLP/HTTP collector URL (e.g. http://localhost:4318), defaults to the standard OTEL_EXPORTER_OTLP_* variables"`
+	File        string  `yaml:"file" env:"TRACING_FILE" default:"traces.json" desc:"Output file for the file exporter"`
+	ServiceName string  `yaml:"serviceName" env:"TRACING_SERVICE_NAME" default:"middleware-finance-app" desc:"service.name resource attribute"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"TRACING_SAMPLE_RATIO" default:"1" desc:"Fraction of new traces to sample, between 0 and 1"`
+}
+
+type StorageConfig struct {
+	Backend           string   `yaml:"backend" env:"STORAGE_BACKEND" default:"local" desc:"Where uploaded documents are kept: local or s3"`
+	LocalDir          string   `yaml:"localDir" env:"STORAGE_LOCAL_DIR" default:"uploads" desc:"Directory for the local backend, created when missing"`
+	S3Bucket          string   `yaml:"s3Bucket" env:"STORAGE_S3_BUCKET" desc:"Bucket for the s3 backend"`
+	S3Region          string   `yaml:"s3Region" env:"STORAGE_S3_REGION" default:"us-east-1" desc:"Region of the bucket"`
+	S3Endpoint        string   `yaml:"s3Endpoint" env:"STORAGE_S3_ENDPOINT" desc:"Endpoint URL of an S3-compatible service (MinIO, R2, ...), empty for AWS"`
+	S3AccessKeyID     string   `yaml:"s3AccessKeyId" env:"STORAGE_S3_ACCESS_KEY_ID" desc:"Access key for the s3 backend"`
+	S3SecretAccessKey string   `yaml:"s3SecretAccessKey" env:"STORAGE_S3_SECRET_ACCESS_KEY" secret:"true" desc:"Secret key for the s3 backend"`
+	S3PathStyle       bool     `yaml:"s3PathStyle" env:"STORAGE_S3_PATH_STYLE" default:"false" desc:"Address the bucket in the path instead of the host name, needed by most S3-compatible services"`
+	MaxDocumentBytes  int      `yaml:"maxDocumentBytes" env:"DOCUMENT_MAX_BYTES" default:"5242880" desc:"Max size of one uploaded document"`
+	AllowedTypes      []string `yaml:"allowedTypes" env:"DOCUMENT_ALLOWED_TYPES" default:"image/jpeg,image/png,application/pdf" desc:"Content types accepted for documents, detected from the file contents"`
+}
+
+type RiskConfig struct {
+	CollectionIntervalDays int    `yaml:"collectionIntervalDays" env:"RISK_COLLECTION_INTERVAL_DAYS" default:"30" desc:"Days a borrower may go without a collection before the gap counts as late in risk scores"`
+	RefreshTime            string `yaml:"refreshTime" env:"RISK_REFRESH_TIME" default:"02:00" desc:"Local time of day (HH:MM) the nightly risk score refresh runs"`
+}
+
+type PenaltyConfig struct {
+	AccrualTime string `yaml:"accrualTime" env:"PENALTY_ACCRUAL_TIME" default:"01:00" desc:"Local time of day (HH:MM) the nightly late payment penalty accrual runs"`
+}
+
+// Load builds the configuration. path is an optional YAML file, when empty
+// the CONFIG_FILE environment variable is used. A missing .env file is not an error.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	err := walk(cfg, func(_ string, field reflect.StructField, value reflect.Value) error {
+		if def, ok := field.Tag.Lookup("default"); ok {
+			return setFromString(value, def)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// .env never overrides variables that are already set
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env: %w", err)
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	err = walk(cfg, func(_ string, field reflect.StructField, value reflect.Value) error {
+		name := field.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			return nil
+		}
+		if err := setFromString(value, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Database.URL != "", "DATABASE_URL is required")
+	if c.Database.DNSResolver != "" && c.Database.DNSResolver != SystemResolver {
+		_, _, err := net.SplitHostPort(c.Database.DNSResolver)
+		check(err == nil, "DB_DNS_RESOLVER must be host:port or %q", SystemResolver)
+	}
+	check(c.Database.ConnectTimeout > 0, "DB_CONNECT_TIMEOUT must be positive")
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT must be a number between 1 and 65535")
+	check(c.Server.ReadTimeout > 0, "HTTP_READ_TIMEOUT must be positive")
+	check(c.Server.ReadHeaderTimeout > 0, "HTTP_READ_HEADER_TIMEOUT must be positive")
+	check(c.Server.WriteTimeout > 0, "HTTP_WRITE_TIMEOUT must be positive")
+	check(c.Server.IdleTimeout > 0, "HTTP_IDLE_TIMEOUT must be positive")
+	check(c.Server.ShutdownTimeout > 0, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+	check(c.Server.MaxHeaderBytes > 0, "HTTP_MAX_HEADER_BYTES must be positive")
+	check(c.Server.MaxBodyBytes > 0, "HTTP_MAX_BODY_BYTES must be positive")
+	check(c.Server.MaxUploadBytes > 0, "HTTP_MAX_UPLOAD_BYTES must be positive")
+	check(c.Server.HSTSMaxAge >= 0, "HTTP_HSTS_MAX_AGE cannot be negative")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.Server.TLSClientCAFile == "" || c.Server.TLSCertFile != "", "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+
+	check(c.Auth.JWTSecret != "", "JWT_SECRET is required")
+	check(c.Auth.TokenTTL > 0, "JWT_TTL must be positive")
+	check(c.Auth.BcryptCost >= bcrypt.MinCost && c.Auth.BcryptCost <= bcrypt.MaxCost,
+		"BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+
+	check(len(c.CORS.AllowedOrigins) > 0, "CORS_ALLOWED_ORIGINS needs at least one origin")
+	for _, proxy := range c.CORS.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES entry %q must be an IP or CIDR range", proxy)
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "LOG_LEVEL must be debug, info, warn or error")
+	check(c.Logging.Format == "json" || c.Logging.Format == "text", "LOG_FORMAT must be json or text")
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout", "file":
+	default:
+		errs = append(errs, errors.New("TRACING_EXPORTER must be none, otlp, stdout or file"))
+	}
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "TRACING_FILE is required for the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	switch c.Storage.Backend {
+	case "local":
+		check(c.Storage.LocalDir != "", "STORAGE_LOCAL_DIR is required for the local backend")
+	case "s3":
+		check(c.Storage.S3Bucket != "", "STORAGE_S3_BUCKET is required for the s3 backend")
+		check(c.Storage.S3Region != "", "STORAGE_S3_REGION is required for the s3 backend")
+		check(c.Storage.S3AccessKeyID != "" && c.Storage.S3SecretAccessKey != "",
+			"STORAGE_S3_ACCESS_KEY_ID and STORAGE_S3_SECRET_ACCESS_KEY are required for the s3 backend")
+		if c.Storage.S3Endpoint != "" {
+			u, err := url.Parse(c.Storage.S3Endpoint)
+			check(err == nil && u.Scheme != "" && u.Host != "", "STORAGE_S3_ENDPOINT must be a URL like https://minio.example.com")
+		}
+	default:
+		errs = append(errs, errors.New("STORAGE_BACKEND must be local or s3"))
+	}
+	check(c.Storage.MaxDocumentBytes > 0 && c.Storage.MaxDocumentBytes < c.Server.MaxUploadBytes,
+		"DOCUMENT_MAX_BYTES must be positive and below HTTP_MAX_UPLOAD_BYTES")
+	check(len(c.Storage.AllowedTypes) > 0, "DOCUMENT_ALLOWED_TYPES needs at least one content type")
+
+	check(c.Risk.CollectionIntervalDays > 0, "RISK_COLLECTION_INTERVAL_DAYS must be positive")
+	_, err = time.Parse("15:04", c.Risk.RefreshTime)
+	check(err == nil, "RISK_REFRESH_TIME must be a time of day like 02:00")
+	_, err = time.Parse("15:04", c.Penalty.AccrualTime)
+	check(err == nil, "PENALTY_ACCRUAL_TIME must be a time of day like 01:00")
+
+	if c.Metrics.Addr != "" {
+		_, _, err := net.SplitHostPort(c.Metrics.Addr)
+		check(err == nil, "METRICS_ADDR must be host:port or :port")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Warnings lists settings that are valid but unsafe for production
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.Auth.JWTSecret == DefaultJWTSecret {
+		warnings = append(warnings, "JWT_SECRET is using the built-in development default")
+	}
+	return warnings
+}
+
+// Print writes the effective configuration as commented YAML with secrets masked
+func (c *Config) Print(w io.Writer) error {
+	section := ""
+	return walk(c, func(name string, field reflect.StructField, value reflect.Value) error {
+		if name != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s:\n", name)
+			section = name
+		}
+
+		comment := field.Tag.Get("env") + " - " + field.Tag.Get("desc")
+		if def, ok := field.Tag.Lookup("default"); ok && field.Tag.Get("secret") != "true" {
+			comment += " (default: " + def + ")"
+		}
+		fmt.Fprintf(w, "  # %s\n", comment)
+
+		display := formatValue(value)
+		if field.Tag.Get("secret") == "true" {
+			display = maskSecret(field.Tag.Get("env"), display)
+		}
+		_, err := fmt.Fprintf(w, "  %s: %s\n", strings.Split(field.Tag.Get("yaml"), ",")[0], display)
+		return err
+	})
+}
+
+// walk calls fn for every leaf setting, name is the YAML key of its section
+func walk(cfg *Config, fn func(name string, field reflect.StructField, value reflect.Value) error) error {
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		name := strings.Split(root.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		for j := 0; j < section.NumField(); j++ {
+			if err := fn(name, section.Type().Field(j), section.Field(j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setFromString(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use values like 15s or 2m", raw)
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		value.SetInt(int64(number))
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		value.SetFloat(number)
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		value.SetBool(flag)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
+
+func formatValue(value reflect.Value) string {
+	if value.Type() == durationType {
+		return time.Duration(value.Int()).String()
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return strconv.Quote(value.String())
+	case reflect.Slice:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = strconv.Quote(value.Index(i).String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
+
+// maskSecret hides secrets but keeps enough to tell which database is in use
+func maskSecret(env, quoted string) string {
+	raw, _ := strconv.Unquote(quoted)
+	if raw == "" {
+		return `""`
+	}
+	if env == "DATABASE_URL" {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return strconv.Quote(u.Redacted())
+		}
+	}
+	return `"********"`
+}
@@ -14,4 +14,109 @@ const (
 	REFERRER_NOT_FOUND_MSG            = "Referred customer not found"
 	INVALID_ID_MSG                    = "Invalid ID"
 	SAME_CUSTOMER_LINK_MSG            = "Cannot link same customer to each other"
+	API_KEY_NOT_FOUND_MSG             = "API key not found"
+	SESSION_NOT_FOUND_MSG             = "Session not found"
+	COLLECTION_NOT_FOUND_MSG          = "Collection not found"
+	ADMIN_NOT_FOUND_MSG               = "Admin not found"
+	USERNAME_TAKEN_MSG                = "Username already exists"
+	VALIDATION_FAILED_MSG             = "Validation failed"
+	INVALID_JSON_MSG                  = "Invalid request body"
+	BRANCH_NOT_FOUND_MSG              = "Branch not found"
+	BRANCH_MISMATCH_MSG               = "Handout and customer belong to different branches, transfer the customer instead"
+	DOCUMENT_NOT_FOUND_MSG            = "Document not found"
+	PARTY_NOT_FOUND_MSG               = "Guarantor or nominee not found"
+	COLLATERAL_NOT_FOUND_MSG          = "Collateral not found"
+)
+
+// Stable error codes returned in the "code" field of error responses.
+// Never rename one, clients depend on them.
+const (
+	// Generic codes, chosen from the HTTP status when no specific code applies
+	BAD_REQUEST            ErrorCode = "BAD_REQUEST"
+	UNAUTHORIZED           ErrorCode = "UNAUTHORIZED"
+	FORBIDDEN              ErrorCode = "FORBIDDEN"
+	NOT_FOUND              ErrorCode = "NOT_FOUND"
+	METHOD_NOT_ALLOWED     ErrorCode = "METHOD_NOT_ALLOWED"
+	CONFLICT               ErrorCode = "CONFLICT"
+	PAYLOAD_TOO_LARGE      ErrorCode = "PAYLOAD_TOO_LARGE"
+	UNSUPPORTED_MEDIA_TYPE ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	UNPROCESSABLE          ErrorCode = "UNPROCESSABLE"
+	INTERNAL_ERROR         ErrorCode = "INTERNAL_ERROR"
+	SERVICE_UNAVAILABLE    ErrorCode = "SERVICE_UNAVAILABLE"
+
+	// Request validation
+	VALIDATION_FAILED ErrorCode = "VALIDATION_FAILED"
+	INVALID_JSON      ErrorCode = "INVALID_JSON"
+	INVALID_ID        ErrorCode = "INVALID_ID"
+	INVALID_VALUE     ErrorCode = "INVALID_VALUE"
+	REQUIRED          ErrorCode = "REQUIRED"
+	UNKNOWN_FIELD     ErrorCode = "UNKNOWN_FIELD"
+
+	// Database constraints without a more specific code
+	ALREADY_EXISTS              ErrorCode = "ALREADY_EXISTS"
+	REFERENCED_RECORD_NOT_FOUND ErrorCode = "REFERENCED_RECORD_NOT_FOUND"
+	RECORD_IN_USE               ErrorCode = "RECORD_IN_USE"
+
+	// Authentication
+	INVALID_CREDENTIALS ErrorCode = "INVALID_CREDENTIALS"
+	ACCOUNT_INACTIVE    ErrorCode = "ACCOUNT_INACTIVE"
+	TOKEN_INVALID       ErrorCode = "TOKEN_INVALID"
+	SESSION_EXPIRED     ErrorCode = "SESSION_EXPIRED"
+	API_KEY_INVALID     ErrorCode = "API_KEY_INVALID"
+	INSUFFICIENT_SCOPE  ErrorCode = "INSUFFICIENT_SCOPE"
+
+	// Resources
+	ADMIN_NOT_FOUND             ErrorCode = "ADMIN_NOT_FOUND"
+	USERNAME_TAKEN              ErrorCode = "USERNAME_TAKEN"
+	SESSION_NOT_FOUND           ErrorCode = "SESSION_NOT_FOUND"
+	API_KEY_NOT_FOUND           ErrorCode = "API_KEY_NOT_FOUND"
+	CUSTOMER_NOT_FOUND          ErrorCode = "CUSTOMER_NOT_FOUND"
+	CUSTOMER_HAS_HANDOUTS       ErrorCode = "CUSTOMER_HAS_HANDOUTS"
+	REFERRER_NOT_FOUND          ErrorCode = "REFERRER_NOT_FOUND"
+	SAME_CUSTOMER_LINK          ErrorCode = "SAME_CUSTOMER_LINK"
+	NO_REFERRER                 ErrorCode = "NO_REFERRER"
+	HANDOUT_NOT_FOUND           ErrorCode = "HANDOUT_NOT_FOUND"
+	HANDOUT_HAS_COLLECTIONS     ErrorCode = "HANDOUT_HAS_COLLECTIONS"
+	COLLECTION_NOT_FOUND        ErrorCode = "COLLECTION_NOT_FOUND"
+	BRANCH_NOT_FOUND            ErrorCode = "BRANCH_NOT_FOUND"
+	BRANCH_INACTIVE             ErrorCode = "BRANCH_INACTIVE"
+	BRANCH_NAME_TAKEN           ErrorCode = "BRANCH_NAME_TAKEN"
+	BRANCH_CODE_TAKEN           ErrorCode = "BRANCH_CODE_TAKEN"
+	BRANCH_ACCESS_DENIED        ErrorCode = "BRANCH_ACCESS_DENIED"
+	BRANCH_MISMATCH             ErrorCode = "BRANCH_MISMATCH"
+	SAME_BRANCH                 ErrorCode = "SAME_BRANCH"
+	DOCUMENT_NOT_FOUND          ErrorCode = "DOCUMENT_NOT_FOUND"
+	DOCUMENT_CORRUPTED          ErrorCode = "DOCUMENT_CORRUPTED"
+	CUSTOMER_HAS_DOCUMENTS      ErrorCode = "CUSTOMER_HAS_DOCUMENTS"
+	PARTY_NOT_FOUND             ErrorCode = "PARTY_NOT_FOUND"
+	PARTY_ALREADY_LINKED        ErrorCode = "PARTY_ALREADY_LINKED"
+	LIABILITY_SHARE_EXCEEDED    ErrorCode = "LIABILITY_SHARE_EXCEEDED"
+	CUSTOMER_IS_PARTY           ErrorCode = "CUSTOMER_IS_PARTY"
+	COLLATERAL_NOT_FOUND        ErrorCode = "COLLATERAL_NOT_FOUND"
+	COLLATERAL_RELEASED         ErrorCode = "COLLATERAL_RELEASED"
+	HANDOUT_NOT_COMPLETED       ErrorCode = "HANDOUT_NOT_COMPLETED"
+	HANDOUT_HAS_COLLATERAL      ErrorCode = "HANDOUT_HAS_COLLATERAL"
+	REFERRAL_CYCLE              ErrorCode = "REFERRAL_CYCLE"
+	REWARD_RULE_NOT_FOUND       ErrorCode = "REWARD_RULE_NOT_FOUND"
+	INSUFFICIENT_REWARD_BALANCE ErrorCode = "INSUFFICIENT_REWARD_BALANCE"
+	CUSTOMER_HAS_REWARDS        ErrorCode = "CUSTOMER_HAS_REWARDS"
+	HANDOUT_HAS_REWARDS         ErrorCode = "HANDOUT_HAS_REWARDS"
+	DUPLICATE_MOBILE            ErrorCode = "DUPLICATE_MOBILE"
+	POSSIBLE_DUPLICATE          ErrorCode = "POSSIBLE_DUPLICATE"
+	MERGE_CONFLICT              ErrorCode = "MERGE_CONFLICT"
+	CUSTOMER_NOT_ELIGIBLE       ErrorCode = "CUSTOMER_NOT_ELIGIBLE"
+	RISK_SCORE_NOT_FOUND        ErrorCode = "RISK_SCORE_NOT_FOUND"
+	SCHEDULE_NOT_FOUND          ErrorCode = "SCHEDULE_NOT_FOUND"
+	SCHEDULE_HAS_PENALTIES      ErrorCode = "SCHEDULE_HAS_PENALTIES"
+	WAIVER_EXCEEDS_PENALTIES    ErrorCode = "WAIVER_EXCEEDS_PENALTIES"
+	SCHEDULE_RESTRUCTURED       ErrorCode = "SCHEDULE_RESTRUCTURED"
+	HANDOUT_NOT_ACTIVE          ErrorCode = "HANDOUT_NOT_ACTIVE"
+	HANDOUT_IN_ARREARS          ErrorCode = "HANDOUT_IN_ARREARS"
+	HANDOUT_SCHEDULED           ErrorCode = "HANDOUT_SCHEDULED"
+	HANDOUT_WRITTEN_OFF         ErrorCode = "HANDOUT_WRITTEN_OFF"
+	NOTHING_DUE                 ErrorCode = "NOTHING_DUE"
+	WRITE_OFF_NOT_FOUND         ErrorCode = "WRITE_OFF_NOT_FOUND"
+	HANDOUT_SETTLED             ErrorCode = "HANDOUT_SETTLED"
+	SETTLEMENT_AMOUNT_MISMATCH  ErrorCode = "SETTLEMENT_AMOUNT_MISMATCH"
+	SETTLEMENT_NOT_FOUND        ErrorCode = "SETTLEMENT_NOT_FOUND"
 )
@@ -1,15 +1,11 @@
 package main
 
-import (
-	"strings"
+import "context"
 
-	"github.com/lib/pq"
-)
+// getCustomerById retrieves a customer by their ID within branchID (0 for any branch)
+func getCustomerById(ctx context.Context, customerId, branchID int) (customer Customer, err error) {
 
-// getCustomerById retrieves a customer by their ID
-func getCustomerById(customerId int) (customer Customer, err error) {
-
-	err = db.QueryRow(GET_CUSTOMER_BY_ID, customerId).Scan(
+	err = db.QueryRowContext(ctx, GET_CUSTOMER_BY_ID, customerId, branchID).Scan(
 		&customer.ID,
 		&customer.Address,
 		&customer.CreatedAt,
@@ -17,7 +13,10 @@ func getCustomerById(customerId int) (customer Customer, err error) {
 		&customer.Mobile,
 		&customer.Name,
 		&customer.ReferredBy, // Will be -1 if NULL
+		&customer.BranchID,
 		&customer.UpdatedAt,
+		&customer.RiskScore,
+		&customer.RiskComputedAt,
 	)
 
 	if err != nil {
@@ -26,10 +25,16 @@ func getCustomerById(customerId int) (customer Customer, err error) {
 	return customer, nil
 }
 
-// isForeignKeyViolation checks if an error is a foreign key constraint violation
-func isForeignKeyViolation(err error) bool {
-	if pqErr, ok := err.(*pq.Error); ok {
-		return pqErr.Code == "23503" // foreign_key_violation
+// validateCustomer returns every invalid field of a customer request, nil when it is valid
+func validateCustomer(customer Customer) []FieldError {
+	var fields []FieldError
+
+	if customer.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Code: REQUIRED, Message: "Name is required"})
+	}
+
+	if customer.Mobile < 1000000000 || customer.Mobile > 9999999999 {
+		fields = append(fields, FieldError{Field: "mobile", Code: INVALID_VALUE, Message: "Enter a valid mobile number"})
 	}
-	return strings.Contains(strings.ToLower(err.Error()), "foreign key")
+	return fields
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 
@@ -12,34 +11,35 @@ import (
 
 func createCustomer(w http.ResponseWriter, r *http.Request) {
 	var customer Customer
-	err := json.NewDecoder(r.Body).Decode(&customer)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &customer) {
+		return
+	}
+
+	if fields := validateCustomer(customer); len(fields) > 0 {
+		sendValidationErrors(w, fields)
 		return
 	}
-	defer r.Body.Close()
 
-	// Validate required fields
-	if customer.Name == "" {
-		sendErrorResponse(w, "Name is required", http.StatusBadRequest)
+	branchID, ok := resolveBranch(w, r, customer.BranchID)
+	if !ok {
 		return
 	}
 
-	if customer.Mobile < 1000000000 || customer.Mobile > 9999999999 {
-		sendErrorResponse(w, "Enter a valid mobile number", http.StatusBadRequest)
+	if !checkDuplicateCustomers(w, r, customer, 0, true, true) {
 		return
 	}
 
 	// Insert customer
-	_, err = db.Exec(
+	_, err := db.ExecContext(r.Context(),
 		CREATE_CUSTOMER,
 		customer.Address,
 		customer.Info,
 		customer.Mobile,
-		customer.Name)
+		customer.Name,
+		branchID)
 
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, err)
 		return
 	}
 
@@ -52,10 +52,27 @@ func createCustomer(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// getAllCustomers lists customers, newest first. ?sort=riskScore (or
+// -riskScore for riskiest first) orders them by risk score, ?minRiskScore= and
+// ?maxRiskScore= keep scored customers within those bounds.
 func getAllCustomers(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query(GET_ALL_CUSTOMERS)
+	query := r.URL.Query()
+	var fields []FieldError
+	sort := query.Get("sort")
+	if sort != "" && sort != "riskScore" && sort != "-riskScore" {
+		fields = append(fields, FieldError{Field: "sort", Code: INVALID_VALUE, Message: "sort must be riskScore or -riskScore"})
+	}
+	minScore := parseRiskScoreBound(query.Get("minRiskScore"), "minRiskScore", &fields)
+	maxScore := parseRiskScoreBound(query.Get("maxRiskScore"), "maxRiskScore", &fields)
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_ALL_CUSTOMERS, branchScope(r),
+		minScore, maxScore, sort)
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -73,9 +90,12 @@ func getAllCustomers(w http.ResponseWriter, r *http.Request) {
 			&customer.Mobile,
 			&customer.Name,
 			&customer.ReferredBy,
-			&customer.UpdatedAt)
+			&customer.BranchID,
+			&customer.UpdatedAt,
+			&customer.RiskScore,
+			&customer.RiskComputedAt)
 		if err != nil {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendInternalError(w, r, err)
 			return
 		}
 		customers = append(customers, customer)
@@ -94,18 +114,18 @@ func getCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
-	customer, err := getCustomerById(customerID)
+	customer, err := getCustomerById(r.Context(), customerID, branchScope(r))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			sendErrorResponse(w, CUSTOMER_NOT_FOUND_MSG, http.StatusNotFound)
+			sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
 			return
 		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -123,54 +143,51 @@ func updateCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	var customer Customer
-	err = json.NewDecoder(r.Body).Decode(&customer)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &customer) {
 		return
 	}
-	defer r.Body.Close()
 
-	// Validate required fields
-	if customer.Name == "" {
-		sendErrorResponse(w, "Name is required", http.StatusBadRequest)
-		return
-	}
-
-	if customer.Mobile < 1000000000 || customer.Mobile > 9999999999 {
-		sendErrorResponse(w, "Enter a valid mobile number", http.StatusBadRequest)
+	if fields := validateCustomer(customer); len(fields) > 0 {
+		sendValidationErrors(w, fields)
 		return
 	}
 
 	// Check if customer exists first
-	var exists bool
-	err = db.QueryRow(CHECK_CUSTOMER_EXISTS, customerID).Scan(&exists)
+	current, err := getCustomerById(r.Context(), customerID, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+			return
+		}
+		sendInternalError(w, r, err)
 		return
 	}
 
-	if !exists {
-		sendErrorResponse(w, CUSTOMER_NOT_FOUND_MSG, http.StatusNotFound)
+	// Only what changed is checked, so known duplicates can still be edited until they are merged
+	mobileChanged := customer.Mobile != current.Mobile
+	detailsChanged := customer.Name != current.Name || customer.Address != current.Address
+	if !checkDuplicateCustomers(w, r, customer, customerID, mobileChanged, detailsChanged) {
 		return
 	}
 
 	// Update customer
-	_, err = db.Exec(
+	_, err = db.ExecContext(r.Context(),
 		UPDATE_CUSTOMER,
 		customer.Address,
 		customer.Info,
 		customer.Mobile,
 		customer.Name,
 		customerID,
+		branchScope(r),
 	)
 
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, err)
 		return
 	}
 
@@ -187,31 +204,28 @@ func deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	// Check if customer exists first
 	var exists bool
-	err = db.QueryRow(CHECK_CUSTOMER_EXISTS, customerID).Scan(&exists)
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
 	if !exists {
-		sendErrorResponse(w, CUSTOMER_NOT_FOUND_MSG, http.StatusNotFound)
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
 		return
 	}
 
 	// Delete customer
-	_, err = db.Exec(DELETE_CUSTOMER, customerID)
+	_, err = db.ExecContext(r.Context(), DELETE_CUSTOMER, customerID, branchScope(r))
 	if err != nil {
-		if isForeignKeyViolation(err) {
-			sendErrorResponse(w, CUSTOMER_HANDOUT_LINK_ERROR_MSG, http.StatusInternalServerError)
-			return
-		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		// Customers with handouts are rejected with CUSTOMER_HAS_HANDOUTS
+		sendDBError(w, r, err)
 		return
 	}
 
@@ -223,100 +237,67 @@ func deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// linkCustomerReferral links or relinks who referred a customer, see changeCustomerReferral
 func linkCustomerReferral(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	var request LinkUsersRequest
 
-	err = json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	if customerID == request.ReferredBy {
-		sendErrorResponse(w, errors.New(SAME_CUSTOMER_LINK_MSG).Error(), http.StatusBadRequest)
-		return
-	}
-	// Check if both customers exist
-	var customerExists, referredCustomerExists bool
-
-	err = db.QueryRow(CHECK_CUSTOMER_EXISTS, customerID).Scan(&customerExists)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	err = db.QueryRow(CHECK_CUSTOMER_EXISTS, request.ReferredBy).Scan(&referredCustomerExists)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	if !decodeJSON(w, r, &request) {
 		return
 	}
 
-	if !customerExists {
-		sendErrorResponse(w, CUSTOMER_NOT_FOUND_MSG, http.StatusNotFound)
+	if request.ReferredBy <= 0 {
+		sendValidationErrors(w, []FieldError{{Field: "referredBy", Code: REQUIRED, Message: "referrer cannot be empty"}})
 		return
 	}
 
-	if !referredCustomerExists {
-		sendErrorResponse(w, REFERRER_NOT_FOUND_MSG, http.StatusNotFound)
-		return
-	}
-
-	// Update the customer's referred_by field
-	_, err = db.Exec(UPDATE_CUSTOMER_REFERRAL, request.ReferredBy, customerID)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	if customerID == request.ReferredBy {
+		sendError(w, http.StatusBadRequest, SAME_CUSTOMER_LINK, SAME_CUSTOMER_LINK_MSG)
 		return
 	}
 
-	resp := MsgResp{
-		Msg: REFERRAL_LINKED_SUCCESS_MSG,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	changeCustomerReferral(w, r, customerID, request.ReferredBy, request.Reason, REFERRAL_LINKED_SUCCESS_MSG)
 }
 
 func getReferredByCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	// First get the customer to find their referred_by ID
-	customer, err := getCustomerById(customerID)
+	customer, err := getCustomerById(r.Context(), customerID, branchScope(r))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			sendErrorResponse(w, CUSTOMER_NOT_FOUND_MSG, http.StatusNotFound)
+			sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
 			return
 		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
 	// Check if customer has a referrer
 	if customer.ReferredBy == 0 || customer.ReferredBy == -1 {
-		sendErrorResponse(w, "Customer has no referrer", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, NO_REFERRER, "Customer has no referrer")
 		return
 	}
 
 	// Get the referrer's details
-	referrer, err := getCustomerById(customer.ReferredBy)
+	referrer, err := getCustomerById(r.Context(), customer.ReferredBy, branchScope(r))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			sendErrorResponse(w, "Referrer not found", http.StatusNotFound)
+			sendError(w, http.StatusNotFound, REFERRER_NOT_FOUND, "Referrer not found")
 			return
 		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
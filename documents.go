@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// validDocumentTypes mirrors the document_type enum in sql/migration-11.sql
+var validDocumentTypes = map[string]bool{
+	"ID_PROOF":      true,
+	"ADDRESS_PROOF": true,
+	"PHOTO":         true,
+}
+
+// mandatoryDocumentTypes must each have an unexpired document for KYC to be complete
+var mandatoryDocumentTypes = []string{"ID_PROOF", "ADDRESS_PROOF", "PHOTO"}
+
+// CustomerDocument is the metadata of an uploaded KYC document
+type CustomerDocument struct {
+	ID          int        `json:"id"`
+	CustomerID  int        `json:"customerId"`
+	Type        string     `json:"type"`
+	FileName    string     `json:"fileName"`
+	ContentType string     `json:"contentType"`
+	Size        int64      `json:"size"`
+	SHA256      string     `json:"sha256"`
+	ExpiresOn   *time.Time `json:"expiresOn"`
+	Expired     bool       `json:"expired"`
+	UploadedBy  int        `json:"uploadedBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+
+	storageBackend string
+	storageKey     string
+}
+
+// MissingKYC is a customer without an unexpired document of every mandatory type
+type MissingKYC struct {
+	CustomerID int      `json:"customerId"`
+	Name       string   `json:"name"`
+	Mobile     int      `json:"mobile"`
+	BranchID   int      `json:"branchId"`
+	Missing    []string `json:"missing"`
+	Expired    []string `json:"expired"` // Missing types that only have expired documents
+}
+
+func scanDocument(row interface{ Scan(...any) error }) (doc CustomerDocument, err error) {
+	err = row.Scan(&doc.ID, &doc.CustomerID, &doc.Type, &doc.FileName, &doc.ContentType, &doc.Size,
+		&doc.SHA256, &doc.ExpiresOn, &doc.UploadedBy, &doc.CreatedAt, &doc.storageBackend, &doc.storageKey)
+	if err == nil && doc.ExpiresOn != nil {
+		doc.Expired = doc.ExpiresOn.Before(today())
+	}
+	return doc, err
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// documentIDs reads the customer and document IDs from the URL
+func documentIDs(w http.ResponseWriter, r *http.Request) (customerID, documentID int, ok bool) {
+	vars := mux.Vars(r)
+	customerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return 0, 0, false
+	}
+	documentID, err = strconv.Atoi(vars["documentId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return 0, 0, false
+	}
+	return customerID, documentID, true
+}
+
+func getCustomerDocuments(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_CUSTOMER_DOCUMENTS, customerID, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	documents := []CustomerDocument{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		documents = append(documents, doc)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]CustomerDocument]{
+		D:   documents,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// uploadCustomerDocument stores a multipart upload with the fields "type",
+// "file" and optionally "expiresOn" (YYYY-MM-DD)
+func uploadCustomerDocument(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	maxBytes := int64(appConfig.Storage.MaxDocumentBytes)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendAPIError(w, decodeError(tooLarge))
+			return
+		}
+		sendError(w, http.StatusBadRequest, BAD_REQUEST, "Request body must be multipart/form-data")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	doc := CustomerDocument{CustomerID: customerID, Type: strings.TrimSpace(r.FormValue("type"))}
+	doc.UploadedBy, _ = r.Context().Value("adminID").(int)
+
+	var fields []FieldError
+	if !validDocumentTypes[doc.Type] {
+		fields = append(fields, FieldError{Field: "type", Code: INVALID_VALUE, Message: "type must be ID_PROOF, ADDRESS_PROOF or PHOTO"})
+	}
+	if raw := strings.TrimSpace(r.FormValue("expiresOn")); raw != "" {
+		expiresOn, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "expiresOn", Code: INVALID_VALUE, Message: "must be a date like 2030-12-31"})
+		} else if expiresOn.Before(today()) {
+			fields = append(fields, FieldError{Field: "expiresOn", Code: INVALID_VALUE, Message: "document has already expired"})
+		} else {
+			doc.ExpiresOn = &expiresOn
+		}
+	}
+
+	var content []byte
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		fields = append(fields, FieldError{Field: "file", Code: REQUIRED, Message: "file cannot be empty"})
+	} else {
+		defer file.Close()
+		content, err = io.ReadAll(io.LimitReader(file, maxBytes+1))
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		doc.FileName = filepath.Base(header.Filename)
+		fields = append(fields, validateDocumentContent(content, maxBytes)...)
+	}
+
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	// The type is detected from the contents, the declared Content-Type is not trusted
+	doc.ContentType = http.DetectContentType(content)
+	doc.Size = int64(len(content))
+	checksum := sha256.Sum256(content)
+	doc.SHA256 = hex.EncodeToString(checksum[:])
+
+	suffix, err := generateTokenID()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	doc.storageBackend = documentStore.Name()
+	doc.storageKey = fmt.Sprintf("customers/%d/%s", customerID, suffix)
+
+	if err := documentStore.Put(r.Context(), doc.storageKey, bytes.NewReader(content), doc.Size, doc.ContentType); err != nil {
+		sendInternalError(w, r, fmt.Errorf("failed to store document: %w", err))
+		return
+	}
+
+	err = db.QueryRowContext(r.Context(), CREATE_CUSTOMER_DOCUMENT,
+		doc.CustomerID, doc.Type, doc.FileName, doc.ContentType, doc.Size,
+		doc.SHA256, doc.storageBackend, doc.storageKey, doc.ExpiresOn, doc.UploadedBy,
+	).Scan(&doc.ID, &doc.CreatedAt)
+	if err != nil {
+		// Best effort - do not leave unreferenced content behind
+		documentStore.Delete(r.Context(), doc.storageKey)
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[CustomerDocument]{
+		D:   doc,
+		Msg: "Document uploaded successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validateDocumentContent checks the size and detected content type of an upload
+func validateDocumentContent(content []byte, maxBytes int64) []FieldError {
+	if len(content) == 0 {
+		return []FieldError{{Field: "file", Code: REQUIRED, Message: "file is empty"}}
+	}
+	if int64(len(content)) > maxBytes {
+		return []FieldError{{Field: "file", Code: INVALID_VALUE, Message: "file must not exceed " + strconv.FormatInt(maxBytes, 10) + " bytes"}}
+	}
+
+	detected := http.DetectContentType(content)
+	for _, allowed := range appConfig.Storage.AllowedTypes {
+		if detected == allowed {
+			return nil
+		}
+	}
+	return []FieldError{{Field: "file", Code: INVALID_VALUE,
+		Message: "file must be one of " + strings.Join(appConfig.Storage.AllowedTypes, ", ") + ", got " + detected}}
+}
+
+// downloadCustomerDocument streams a document after verifying its checksum
+func downloadCustomerDocument(w http.ResponseWriter, r *http.Request) {
+	customerID, documentID, ok := documentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	doc, err := scanDocument(db.QueryRowContext(r.Context(), GET_CUSTOMER_DOCUMENT, documentID, customerID, branchScope(r)))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, DOCUMENT_NOT_FOUND, DOCUMENT_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if doc.storageBackend != documentStore.Name() {
+		sendInternalError(w, r, fmt.Errorf("document %d is in the %s backend but %s is configured", doc.ID, doc.storageBackend, documentStore.Name()))
+		return
+	}
+
+	body, err := documentStore.Get(r.Context(), doc.storageKey)
+	if err != nil {
+		sendInternalError(w, r, fmt.Errorf("failed to read document %d: %w", doc.ID, err))
+		return
+	}
+	defer body.Close()
+
+	// Documents are small (DOCUMENT_MAX_BYTES), so verify before sending anything
+	content, err := io.ReadAll(io.LimitReader(body, doc.Size+1))
+	if err != nil {
+		sendInternalError(w, r, fmt.Errorf("failed to read document %d: %w", doc.ID, err))
+		return
+	}
+	checksum := sha256.Sum256(content)
+	if hex.EncodeToString(checksum[:]) != doc.SHA256 {
+		slog.ErrorContext(r.Context(), "document checksum mismatch",
+			slog.Int("document_id", doc.ID),
+			slog.String("storage_key", doc.storageKey),
+		)
+		sendError(w, http.StatusInternalServerError, DOCUMENT_CORRUPTED, "Stored document does not match its checksum")
+		return
+	}
+
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.FileName}))
+	w.Header().Set("ETag", `"`+doc.SHA256+`"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(content)
+}
+
+func deleteCustomerDocument(w http.ResponseWriter, r *http.Request) {
+	customerID, documentID, ok := documentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	// The row goes first so metadata never points at deleted content
+	var storageKey string
+	err := db.QueryRowContext(r.Context(), DELETE_CUSTOMER_DOCUMENT, documentID, customerID, branchScope(r)).Scan(&storageKey)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, DOCUMENT_NOT_FOUND, DOCUMENT_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := documentStore.Delete(r.Context(), storageKey); err != nil {
+		// The document is gone for the API, the orphaned content can be removed later
+		slog.WarnContext(r.Context(), "failed to delete document content",
+			slog.String("storage_key", storageKey),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	resp := MsgResp{
+		Msg: "Document deleted successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getMissingKYC reports customers without an unexpired document of every mandatory type
+func getMissingKYC(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), GET_MISSING_KYC, pq.Array(mandatoryDocumentTypes), branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	report := []MissingKYC{}
+	for rows.Next() {
+		var entry MissingKYC
+		err := rows.Scan(&entry.CustomerID, &entry.Name, &entry.Mobile, &entry.BranchID,
+			pq.Array(&entry.Missing), pq.Array(&entry.Expired))
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		report = append(report, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]MissingKYC]{
+		D:   report,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
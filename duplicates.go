@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// A customer with a name at least duplicateNameSimilarity similar and an
+	// address at least duplicateAddressSimilarity similar is a possible duplicate
+	duplicateNameSimilarity    = 0.6
+	duplicateAddressSimilarity = 0.5
+)
+
+// DuplicateCustomer is the part of a customer shown when comparing duplicates
+type DuplicateCustomer struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Mobile   int    `json:"mobile"`
+	Address  string `json:"address"`
+	BranchID int    `json:"branchId"`
+}
+
+// DuplicateMatch is an existing customer that looks like the one being saved
+type DuplicateMatch struct {
+	DuplicateCustomer
+	SameMobile        bool    `json:"sameMobile"`
+	NameSimilarity    float64 `json:"nameSimilarity"`
+	AddressSimilarity float64 `json:"addressSimilarity"`
+}
+
+// similar reports whether the match is close enough by name and address
+func (m DuplicateMatch) similar() bool {
+	return m.NameSimilarity >= duplicateNameSimilarity && m.AddressSimilarity >= duplicateAddressSimilarity
+}
+
+// DuplicatePair is one entry of the duplicates report, Customer has the lower ID
+type DuplicatePair struct {
+	Customer          DuplicateCustomer `json:"customer"`
+	Duplicate         DuplicateCustomer `json:"duplicate"`
+	SameMobile        bool              `json:"sameMobile"`
+	NameSimilarity    float64           `json:"nameSimilarity"`
+	AddressSimilarity float64           `json:"addressSimilarity"`
+}
+
+type CustomerMergeRequest struct {
+	DuplicateID int    `json:"duplicateId"`
+	Reason      string `json:"reason"`
+}
+
+// CustomerMerge records a duplicate merged into the survivor. Merged is the
+// duplicate as it was just before it was deleted.
+type CustomerMerge struct {
+	ID             int       `json:"id"`
+	SurvivorID     int       `json:"survivorId"`
+	MergedID       int       `json:"mergedId"`
+	Merged         Customer  `json:"merged"`
+	HandoutsMoved  int       `json:"handoutsMoved"`
+	DocumentsMoved int       `json:"documentsMoved"`
+	ReferralsMoved int       `json:"referralsMoved"`
+	Reason         string    `json:"reason"`
+	MergedBy       int       `json:"mergedBy"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// duplicateConflict picks the error for saving a customer that matches
+// existing ones. A shared mobile is always rejected, a similar name and
+// address only unless allowSimilar is set. It returns nil when nothing blocks.
+func duplicateConflict(matches []DuplicateMatch, checkMobile, checkSimilar, allowSimilar bool) *APIError {
+	sameMobile := []DuplicateMatch{}
+	similar := []DuplicateMatch{}
+	for _, match := range matches {
+		if checkMobile && match.SameMobile {
+			sameMobile = append(sameMobile, match)
+		} else if checkSimilar && match.similar() {
+			similar = append(similar, match)
+		}
+	}
+
+	if len(sameMobile) > 0 {
+		apiErr := newAPIError(http.StatusConflict, DUPLICATE_MOBILE, "Another customer has this mobile, merge them instead")
+		apiErr.Details = sameMobile
+		return apiErr
+	}
+	if len(similar) > 0 && !allowSimilar {
+		apiErr := newAPIError(http.StatusConflict, POSSIBLE_DUPLICATE, "Customers with a similar name and address exist, pass allowDuplicate=true to save anyway")
+		apiErr.Details = similar
+		return apiErr
+	}
+	return nil
+}
+
+// checkDuplicateCustomers looks for customers other than excludeID that match
+// customer, see duplicateConflict. It writes the response and returns false
+// when the customer cannot be saved.
+func checkDuplicateCustomers(w http.ResponseWriter, r *http.Request, customer Customer, excludeID int, checkMobile, checkSimilar bool) bool {
+	if !checkMobile && !checkSimilar {
+		return true
+	}
+
+	allowSimilar := false
+	if raw := r.URL.Query().Get("allowDuplicate"); raw != "" {
+		var err error
+		allowSimilar, err = strconv.ParseBool(raw)
+		if err != nil {
+			sendValidationErrors(w, []FieldError{{Field: "allowDuplicate", Code: INVALID_VALUE, Message: "allowDuplicate must be true or false"}})
+			return false
+		}
+	}
+
+	rows, err := db.QueryContext(r.Context(), FIND_DUPLICATE_CUSTOMERS, customer.Mobile, customer.Name, customer.Address,
+		excludeID, branchScope(r), duplicateNameSimilarity, duplicateAddressSimilarity)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return false
+	}
+	defer rows.Close()
+
+	var matches []DuplicateMatch
+	for rows.Next() {
+		var match DuplicateMatch
+		err := rows.Scan(&match.ID, &match.Name, &match.Mobile, &match.Address, &match.BranchID,
+			&match.SameMobile, &match.NameSimilarity, &match.AddressSimilarity)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return false
+		}
+		matches = append(matches, match)
+	}
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return false
+	}
+
+	if apiErr := duplicateConflict(matches, checkMobile, checkSimilar, allowSimilar); apiErr != nil {
+		sendAPIError(w, apiErr)
+		return false
+	}
+	return true
+}
+
+// getDuplicateCustomers reports pairs of customers in the caller's branch that
+// share a mobile or have a similar name and address
+func getDuplicateCustomers(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), GET_DUPLICATE_CUSTOMERS, branchScope(r),
+		duplicateNameSimilarity, duplicateAddressSimilarity)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	pairs := []DuplicatePair{}
+	for rows.Next() {
+		var pair DuplicatePair
+		a, b := &pair.Customer, &pair.Duplicate
+		err := rows.Scan(&a.ID, &a.Name, &a.Mobile, &a.Address, &a.BranchID,
+			&b.ID, &b.Name, &b.Mobile, &b.Address, &b.BranchID,
+			&pair.SameMobile, &pair.NameSimilarity, &pair.AddressSimilarity)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		pairs = append(pairs, pair)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]DuplicatePair]{
+		D:   pairs,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// survivorReferrer is who refers the survivor after a merge: its own referrer,
+// or the duplicate's when it had none or was referred by the duplicate. 0 is
+// no referrer, never the survivor itself.
+func survivorReferrer(survivor, duplicate Customer) int {
+	referrer := survivor.ReferredBy
+	if referrer <= 0 || referrer == duplicate.ID {
+		referrer = duplicate.ReferredBy
+	}
+	if referrer <= 0 || referrer == survivor.ID {
+		return 0
+	}
+	return referrer
+}
+
+// mergeMove is an update run with the survivor and duplicate IDs, count takes
+// the rows it changed when the merge reports them
+type mergeMove struct {
+	query string
+	count *int
+}
+
+// mergeMoves lists what moves from the duplicate to the survivor before the
+// duplicate is deleted, anything left behind would be lost to ON DELETE CASCADE
+func mergeMoves(merge *CustomerMerge) []mergeMove {
+	return []mergeMove{
+		{MERGE_CUSTOMER_HANDOUTS, &merge.HandoutsMoved},
+		{MERGE_CUSTOMER_DOCUMENTS, &merge.DocumentsMoved},
+		{MERGE_CUSTOMER_PARTIES, nil},
+		{MERGE_CUSTOMER_DUPLICATE_REWARDS, nil},
+		{MERGE_CUSTOMER_REWARDS, nil},
+		{MERGE_CUSTOMER_MERGES, nil},
+		{MERGE_CUSTOMER_OVERRIDES, nil},
+		{MERGE_CUSTOMER_TRANSFERS, nil},
+		{MERGE_CUSTOMER_REFERRAL_CHANGES, nil},
+		{MERGE_REFERRAL_CHANGE_REFERRERS, nil},
+	}
+}
+
+// mergeCustomer merges the duplicate in the body into the customer in the
+// path. Handouts, documents, guarantees, referrals, referral rewards and the
+// transfer and referral history move to the survivor, the duplicate is
+// deleted and the merge recorded, all in one transaction. Both customers must
+// be in the same branch.
+func mergeCustomer(w http.ResponseWriter, r *http.Request) {
+	survivorID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req CustomerMergeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.DuplicateID <= 0 {
+		fields = append(fields, FieldError{Field: "duplicateId", Code: REQUIRED, Message: "duplicate customer cannot be empty"})
+	} else if req.DuplicateID == survivorID {
+		fields = append(fields, FieldError{Field: "duplicateId", Code: INVALID_VALUE, Message: "cannot merge a customer into itself"})
+	}
+	if req.Reason == "" {
+		fields = append(fields, FieldError{Field: "reason", Code: REQUIRED, Message: "reason cannot be empty"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(LOCK_REFERRALS); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	rows, err := tx.Query(LOCK_MERGE_CUSTOMERS, survivorID, req.DuplicateID, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	locked := map[int]Customer{}
+	for rows.Next() {
+		var c Customer
+		err := rows.Scan(&c.ID, &c.Address, &c.CreatedAt, &c.Info, &c.Mobile, &c.Name, &c.ReferredBy, &c.BranchID, &c.UpdatedAt)
+		if err != nil {
+			rows.Close()
+			sendInternalError(w, r, err)
+			return
+		}
+		locked[c.ID] = c
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	survivor, ok := locked[survivorID]
+	if !ok {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	duplicate, ok := locked[req.DuplicateID]
+	if !ok {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, "Duplicate customer not found")
+		return
+	}
+	if survivor.BranchID != duplicate.BranchID {
+		sendError(w, http.StatusConflict, BRANCH_MISMATCH, "Customers belong to different branches, transfer the duplicate first")
+		return
+	}
+
+	var conflict bool
+	if err := tx.QueryRow(CHECK_MERGE_PARTY_CONFLICT, survivorID, duplicate.ID).Scan(&conflict); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if conflict {
+		sendError(w, http.StatusConflict, MERGE_CONFLICT, "The customers guarantee each other's handouts or share one in the same role, remove the party first")
+		return
+	}
+
+	merge := CustomerMerge{
+		SurvivorID: survivorID,
+		MergedID:   duplicate.ID,
+		Merged:     duplicate,
+		Reason:     req.Reason,
+	}
+	merge.MergedBy, _ = r.Context().Value("adminID").(int)
+	referralReason := "Merged customer " + strconv.Itoa(duplicate.ID) + " into " + strconv.Itoa(survivorID) + ": " + req.Reason
+
+	for _, move := range mergeMoves(&merge) {
+		result, err := tx.Exec(move.query, survivorID, duplicate.ID)
+		if err != nil {
+			sendDBError(w, r, err)
+			return
+		}
+		if move.count != nil {
+			n, err := result.RowsAffected()
+			if err != nil {
+				sendInternalError(w, r, err)
+				return
+			}
+			*move.count = int(n)
+		}
+	}
+
+	result, err := tx.Exec(MERGE_CUSTOMER_REFERRALS, survivorID, duplicate.ID, referralReason, merge.MergedBy)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	referrals, err := result.RowsAffected()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	merge.ReferralsMoved = int(referrals)
+
+	// The survivor takes over the duplicate's referrer unless that would close a cycle
+	previous := max(survivor.ReferredBy, 0)
+	referrer := survivorReferrer(survivor, duplicate)
+	if referrer != 0 && referrer != previous {
+		var cycle bool
+		if err := tx.QueryRow(CHECK_REFERRAL_CYCLE, referrer, survivorID).Scan(&cycle); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		if cycle {
+			referrer = 0
+		}
+	}
+	if referrer != previous {
+		if _, err := tx.Exec(UPDATE_CUSTOMER_REFERRAL, referrer, survivorID, branchScope(r)); err != nil {
+			sendDBError(w, r, err)
+			return
+		}
+		if _, err := tx.Exec(CREATE_REFERRAL_CHANGE, survivorID, previous, referrer, referralReason, merge.MergedBy); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	snapshot, err := json.Marshal(duplicate)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	err = tx.QueryRow(CREATE_CUSTOMER_MERGE, survivorID, duplicate.ID, string(snapshot), merge.HandoutsMoved,
+		merge.DocumentsMoved, merge.ReferralsMoved, merge.Reason, merge.MergedBy).Scan(&merge.ID, &merge.CreatedAt)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if _, err := tx.Exec(DELETE_CUSTOMER, duplicate.ID, branchScope(r)); err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	// The survivor now carries the duplicate's repayment history
+	refreshCustomerRisk(r, survivorID)
+
+	resp := DataResp[CustomerMerge]{
+		D:   merge,
+		Msg: "Customers merged successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getCustomerMerges lists the duplicates merged into a customer
+func getCustomerMerges(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_CUSTOMER_MERGES, customerID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	merges := []CustomerMerge{}
+	for rows.Next() {
+		var merge CustomerMerge
+		var snapshot []byte
+		err := rows.Scan(&merge.ID, &merge.SurvivorID, &merge.MergedID, &snapshot, &merge.HandoutsMoved,
+			&merge.DocumentsMoved, &merge.ReferralsMoved, &merge.Reason, &merge.MergedBy, &merge.CreatedAt)
+		if err == nil {
+			err = json.Unmarshal(snapshot, &merge.Merged)
+		}
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		merges = append(merges, merge)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]CustomerMerge]{
+		D:   merges,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestDuplicateConflict(t *testing.T) {
+	sameMobile := DuplicateMatch{DuplicateCustomer: DuplicateCustomer{ID: 1}, SameMobile: true, NameSimilarity: 0.2}
+	similar := DuplicateMatch{DuplicateCustomer: DuplicateCustomer{ID: 2}, NameSimilarity: 0.8, AddressSimilarity: 0.6}
+	nameOnly := DuplicateMatch{DuplicateCustomer: DuplicateCustomer{ID: 3}, NameSimilarity: 0.9, AddressSimilarity: 0.1}
+
+	tests := []struct {
+		name                      string
+		matches                   []DuplicateMatch
+		checkMobile, checkSimilar bool
+		allowSimilar              bool
+		want                      ErrorCode
+		wantIDs                   []int
+	}{
+		{"no matches", nil, true, true, false, "", nil},
+		{"same mobile", []DuplicateMatch{similar, sameMobile}, true, true, false, DUPLICATE_MOBILE, []int{1}},
+		{"same mobile cannot be allowed", []DuplicateMatch{sameMobile}, true, true, true, DUPLICATE_MOBILE, []int{1}},
+		{"similar", []DuplicateMatch{similar, nameOnly}, true, true, false, POSSIBLE_DUPLICATE, []int{2}},
+		{"similar allowed", []DuplicateMatch{similar}, true, true, true, "", nil},
+		{"name alone is not a duplicate", []DuplicateMatch{nameOnly}, true, true, false, "", nil},
+		{"unchanged mobile", []DuplicateMatch{sameMobile}, false, true, false, "", nil},
+		{"unchanged name and address", []DuplicateMatch{similar}, true, false, false, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := duplicateConflict(tt.matches, tt.checkMobile, tt.checkSimilar, tt.allowSimilar)
+			if tt.want == "" {
+				if apiErr != nil {
+					t.Fatalf("expected no conflict, got %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.Code != tt.want {
+				t.Fatalf("expected %s, got %v", tt.want, apiErr)
+			}
+			details, _ := apiErr.Details.([]DuplicateMatch)
+			if len(details) != len(tt.wantIDs) {
+				t.Fatalf("expected matches %v, got %+v", tt.wantIDs, details)
+			}
+			for i, id := range tt.wantIDs {
+				if details[i].ID != id {
+					t.Errorf("expected matches %v, got %+v", tt.wantIDs, details)
+				}
+			}
+		})
+	}
+}
+
+func TestSurvivorReferrer(t *testing.T) {
+	tests := []struct {
+		name                string
+		survivor, duplicate int // referred_by, -1 for none
+		want                int
+	}{
+		{"keeps its own referrer", 7, 8, 7},
+		{"takes the duplicate's", -1, 8, 8},
+		{"neither has one", -1, -1, 0},
+		{"was referred by the duplicate", 2, 8, 8},
+		{"duplicate was referred by the survivor", -1, 1, 0},
+		{"referred by the duplicate, who was referred by the survivor", 2, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			survivor := Customer{ID: 1, ReferredBy: tt.survivor}
+			duplicate := Customer{ID: 2, ReferredBy: tt.duplicate}
+			if got := survivorReferrer(survivor, duplicate); got != tt.want {
+				t.Errorf("expected referrer %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeMovesCascadingRows(t *testing.T) {
+	files, err := filepath.Glob("sql/migration-*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`)
+	cascade := regexp.MustCompile(`(\w+) BIGINT[^,\n]*REFERENCES customers\(id\) ON DELETE CASCADE`)
+	// Risk scores are recomputed for the survivor after the merge
+	recomputed := map[string]bool{"customer_risk_scores": true}
+
+	var merge CustomerMerge
+	var queries []string
+	for _, move := range mergeMoves(&merge) {
+		queries = append(queries, move.query)
+	}
+
+	found := 0
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range table.FindAllStringSubmatch(string(sql), -1) {
+			if recomputed[m[1]] {
+				continue
+			}
+			for _, column := range cascade.FindAllStringSubmatch(m[2], -1) {
+				found++
+				update := "UPDATE " + m[1] + " SET " + column[1] + " = $1 WHERE " + column[1] + " = $2"
+				if !slices.Contains(queries, update) {
+					t.Errorf("%s.%s is deleted with the duplicate, expected a move %q", m[1], column[1], update)
+				}
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("no cascading customer references found in sql/")
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Eligibility rule names, reported in checks and recorded with overrides
+const (
+	RULE_CREDIT_LIMIT        = "CREDIT_LIMIT"
+	RULE_MAX_ACTIVE_HANDOUTS = "MAX_ACTIVE_HANDOUTS"
+	RULE_MAX_TOTAL_EXPOSURE  = "MAX_TOTAL_EXPOSURE"
+	RULE_MAX_ARREARS_DAYS    = "MAX_ARREARS_DAYS"
+	RULE_KYC_COMPLETE        = "KYC_COMPLETE"
+	RULE_NO_WRITE_OFF        = "NO_WRITE_OFF" // checked whenever the customer has an unrecovered write-off
+)
+
+// EligibilityRules are checked before every disbursement, a nil limit
+// disables its rule. Customers' own credit limits are checked as well.
+type EligibilityRules struct {
+	MaxActiveHandouts *int      `json:"maxActiveHandouts"`
+	MaxTotalExposure  *Money    `json:"maxTotalExposure"`
+	MaxArrearsDays    *int      `json:"maxArrearsDays"`
+	RequireKYC        bool      `json:"requireKyc"`
+	UpdatedBy         int       `json:"updatedBy"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+type UpdateEligibilityRulesRequest struct {
+	MaxActiveHandouts *int   `json:"maxActiveHandouts"`
+	MaxTotalExposure  *Money `json:"maxTotalExposure"`
+	MaxArrearsDays    *int   `json:"maxArrearsDays"`
+	RequireKYC        bool   `json:"requireKyc"`
+}
+
+type CreditLimitRequest struct {
+	CreditLimit *Money `json:"creditLimit"` // null removes the limit
+}
+
+// CustomerCredit is what a customer already owes, leaving out the handout
+// being updated
+type CustomerCredit struct {
+	CreditLimit    *Money   `json:"creditLimit"`
+	ActiveHandouts int      `json:"activeHandouts"` // ACTIVE or PENDING handouts that still owe money
+	Exposure       Money    `json:"exposure"`       // outstanding on those handouts
+	ArrearsDays    int      `json:"arrearsDays"`    // longest any of them is overdue
+	WrittenOff     Money    `json:"writtenOff"`     // written off on their handouts and not recovered yet
+	MissingKYC     []string `json:"missingKyc"`
+}
+
+type EligibilityCheck struct {
+	Rule    string `json:"rule"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// Eligibility tells whether a customer can be given one more handout of Amount
+type Eligibility struct {
+	CustomerID int   `json:"customerId"`
+	Amount     Money `json:"amount"`
+	Eligible   bool  `json:"eligible"`
+	CustomerCredit
+	Checks []EligibilityCheck `json:"rules"`
+}
+
+// failedRules lists the rules the customer does not meet
+func (e Eligibility) failedRules() []string {
+	failed := []string{}
+	for _, check := range e.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Rule)
+		}
+	}
+	return failed
+}
+
+// evaluateEligibility checks one more handout of amount against the rules.
+// Only enabled rules, a credit limit the customer has and a write-off they
+// have not repaid are reported.
+func evaluateEligibility(rules EligibilityRules, credit CustomerCredit, amount Money) Eligibility {
+	result := Eligibility{Amount: amount, Eligible: true, CustomerCredit: credit, Checks: []EligibilityCheck{}}
+	check := func(rule string, passed bool, message string) {
+		result.Checks = append(result.Checks, EligibilityCheck{Rule: rule, Passed: passed, Message: message})
+		result.Eligible = result.Eligible && passed
+	}
+	exposure := credit.Exposure + amount
+
+	if credit.CreditLimit != nil {
+		check(RULE_CREDIT_LIMIT, exposure <= *credit.CreditLimit,
+			"Outstanding would be "+exposure.String()+", the customer's credit limit is "+credit.CreditLimit.String())
+	}
+	if rules.MaxActiveHandouts != nil {
+		check(RULE_MAX_ACTIVE_HANDOUTS, credit.ActiveHandouts+1 <= *rules.MaxActiveHandouts,
+			strconv.Itoa(credit.ActiveHandouts)+" handouts still owe money, at most "+strconv.Itoa(*rules.MaxActiveHandouts)+" are allowed at a time")
+	}
+	if rules.MaxTotalExposure != nil {
+		check(RULE_MAX_TOTAL_EXPOSURE, exposure <= *rules.MaxTotalExposure,
+			"Outstanding would be "+exposure.String()+", at most "+rules.MaxTotalExposure.String()+" is allowed")
+	}
+	if rules.MaxArrearsDays != nil {
+		check(RULE_MAX_ARREARS_DAYS, credit.ArrearsDays <= *rules.MaxArrearsDays,
+			"A handout is "+strconv.Itoa(credit.ArrearsDays)+" days overdue, at most "+strconv.Itoa(*rules.MaxArrearsDays)+" are allowed")
+	}
+	if credit.WrittenOff > 0 {
+		check(RULE_NO_WRITE_OFF, false, credit.WrittenOff.String()+" written off on the customer's handouts has not been recovered")
+	}
+	if rules.RequireKYC {
+		message := "KYC is complete"
+		if len(credit.MissingKYC) > 0 {
+			message = "Missing or expired KYC documents: " + strings.Join(credit.MissingKYC, ", ")
+		}
+		check(RULE_KYC_COMPLETE, len(credit.MissingKYC) == 0, message)
+	}
+	return result
+}
+
+// arrearsDays is how many days the oldest unpaid installment is overdue, 0
+// when none is
+func arrearsDays(plan []Installment) int {
+	for _, item := range plan {
+		if item.Status == INSTALLMENT_OVERDUE {
+			return item.DaysLate
+		}
+	}
+	return 0
+}
+
+func scanEligibilityRules(row interface{ Scan(...any) error }) (rules EligibilityRules, err error) {
+	var maxActive, maxArrears sql.NullInt64
+	err = row.Scan(&maxActive, &rules.MaxTotalExposure, &maxArrears, &rules.RequireKYC, &rules.UpdatedBy, &rules.UpdatedAt)
+	if maxActive.Valid {
+		n := int(maxActive.Int64)
+		rules.MaxActiveHandouts = &n
+	}
+	if maxArrears.Valid {
+		n := int(maxArrears.Int64)
+		rules.MaxArrearsDays = &n
+	}
+	return rules, err
+}
+
+// loadEligibility evaluates one more handout of amount for a customer,
+// leaving out excludeHandoutID. It returns sql.ErrNoRows for a missing customer.
+func loadEligibility(tx *tracedTx, customerID, excludeHandoutID int, amount Money) (Eligibility, error) {
+	rules, err := scanEligibilityRules(tx.QueryRow(GET_ELIGIBILITY_RULES))
+	if err != nil {
+		return Eligibility{}, err
+	}
+
+	var credit CustomerCredit
+	var scheduled []int64
+	err = tx.QueryRow(GET_CUSTOMER_CREDIT, customerID, excludeHandoutID).Scan(
+		&credit.CreditLimit, &credit.ActiveHandouts, &credit.Exposure, &credit.ArrearsDays, pq.Array(&scheduled), &credit.WrittenOff)
+	if err != nil {
+		return Eligibility{}, err
+	}
+	// A handout on a schedule is only in arrears past an installment's due date
+	for _, handoutID := range scheduled {
+		schedule, err := loadSchedule(tx, int(handoutID), 0, 0)
+		if err != nil {
+			return Eligibility{}, err
+		}
+		credit.ArrearsDays = max(credit.ArrearsDays, arrearsDays(schedule.Schedule))
+	}
+	err = tx.QueryRow(GET_CUSTOMER_MISSING_KYC, pq.Array(mandatoryDocumentTypes), customerID).Scan(pq.Array(&credit.MissingKYC))
+	if err != nil {
+		return Eligibility{}, err
+	}
+	if credit.MissingKYC == nil {
+		credit.MissingKYC = []string{}
+	}
+
+	eligibility := evaluateEligibility(rules, credit, amount)
+	eligibility.CustomerID = customerID
+	return eligibility, nil
+}
+
+// checkEligibility runs before a disbursement of amount inside tx, after the
+// customer is locked. An admin can disburse anyway with overrideEligibility
+// and a reason, it returns the failed rules to record with the override. It
+// writes the response and returns false when the disbursement must stop.
+func checkEligibility(w http.ResponseWriter, r *http.Request, tx *tracedTx, handout HandoutUpdate, excludeHandoutID int, amount Money) ([]string, bool) {
+	eligibility, err := loadEligibility(tx, handout.CustomerId, excludeHandoutID, amount)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return nil, false
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return nil, false
+	}
+	if eligibility.Eligible {
+		return nil, true
+	}
+
+	if !handout.OverrideEligibility {
+		apiErr := newAPIError(http.StatusUnprocessableEntity, CUSTOMER_NOT_ELIGIBLE, "Customer is not eligible for this handout, see the failed rules")
+		apiErr.Details = eligibility
+		sendAPIError(w, apiErr)
+		return nil, false
+	}
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		sendErrorResponse(w, "Only admins can override eligibility rules", http.StatusForbidden)
+		return nil, false
+	}
+	return eligibility.failedRules(), true
+}
+
+// recordEligibilityOverride keeps who disbursed handoutID despite failed rules and why
+func recordEligibilityOverride(tx *tracedTx, r *http.Request, handoutID int, handout HandoutUpdate, amount Money, failed []string) error {
+	adminID, _ := r.Context().Value("adminID").(int)
+	_, err := tx.Exec(CREATE_ELIGIBILITY_OVERRIDE, handoutID, handout.CustomerId, amount, pq.Array(failed),
+		handout.OverrideReason, adminID)
+	return err
+}
+
+// getCustomerEligibility explains which rules pass or fail for one more
+// handout of ?amount= (0 when omitted)
+func getCustomerEligibility(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var amount Money
+	if raw := r.URL.Query().Get("amount"); raw != "" {
+		amount, err = parseMoney(raw)
+		if err != nil || amount <= 0 {
+			sendValidationErrors(w, []FieldError{{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"}})
+			return
+		}
+	}
+
+	if _, ok := customerBranch(w, r, customerID); !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	eligibility, err := loadEligibility(tx, customerID, 0, amount)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[Eligibility]{
+		D:   eligibility,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setCustomerCreditLimit sets or, with null, removes a customer's credit limit
+func setCustomerCreditLimit(w http.ResponseWriter, r *http.Request) {
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		sendErrorResponse(w, "Only admins can set credit limits", http.StatusForbidden)
+		return
+	}
+
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req CreditLimitRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.CreditLimit != nil && *req.CreditLimit < 0 {
+		sendValidationErrors(w, []FieldError{{Field: "creditLimit", Code: INVALID_VALUE, Message: "credit limit cannot be negative"}})
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), UPDATE_CUSTOMER_CREDIT_LIMIT, req.CreditLimit, customerID, branchScope(r))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if updated == 0 {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	resp := MsgResp{
+		Msg: "Credit limit updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getEligibilityRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := scanEligibilityRules(db.QueryRowContext(r.Context(), GET_ELIGIBILITY_RULES))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[EligibilityRules]{
+		D:   rules,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// updateEligibilityRules replaces the rules, which apply to every branch. An
+// omitted or null limit disables its rule.
+func updateEligibilityRules(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can manage eligibility rules", http.StatusForbidden)
+		return
+	}
+
+	var req UpdateEligibilityRulesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.MaxActiveHandouts != nil && *req.MaxActiveHandouts < 0 {
+		fields = append(fields, FieldError{Field: "maxActiveHandouts", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if req.MaxTotalExposure != nil && *req.MaxTotalExposure < 0 {
+		fields = append(fields, FieldError{Field: "maxTotalExposure", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if req.MaxArrearsDays != nil && *req.MaxArrearsDays < 0 {
+		fields = append(fields, FieldError{Field: "maxArrearsDays", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	rules, err := scanEligibilityRules(db.QueryRowContext(r.Context(), UPDATE_ELIGIBILITY_RULES,
+		req.MaxActiveHandouts, req.MaxTotalExposure, req.MaxArrearsDays, req.RequireKYC, adminID))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[EligibilityRules]{
+		D:   rules,
+		Msg: "Eligibility rules updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
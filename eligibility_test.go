@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestEvaluateEligibility(t *testing.T) {
+	three, thirty := 3, 30
+	limit, maxExposure := Money(5000000), Money(8000000)
+	rules := EligibilityRules{MaxActiveHandouts: &three, MaxTotalExposure: &maxExposure, MaxArrearsDays: &thirty, RequireKYC: true}
+
+	tests := []struct {
+		name   string
+		rules  EligibilityRules
+		credit CustomerCredit
+		amount Money
+		failed []string
+	}{
+		{"first loan", rules, CustomerCredit{MissingKYC: []string{}}, 2000000, []string{}},
+		{"at the credit limit", rules, CustomerCredit{CreditLimit: &limit, Exposure: 3000000}, 2000000, []string{}},
+		{"over the credit limit", rules, CustomerCredit{CreditLimit: &limit, Exposure: 3000000}, 2000001, []string{RULE_CREDIT_LIMIT}},
+		{"too many loans", rules, CustomerCredit{ActiveHandouts: 3, Exposure: 1000000}, 100000, []string{RULE_MAX_ACTIVE_HANDOUTS}},
+		{"total exposure", rules, CustomerCredit{ActiveHandouts: 1, Exposure: 7000000}, 1000001, []string{RULE_MAX_TOTAL_EXPOSURE}},
+		{"in arrears without KYC", rules, CustomerCredit{ArrearsDays: 31, MissingKYC: []string{"PHOTO"}}, 100000,
+			[]string{RULE_MAX_ARREARS_DAYS, RULE_KYC_COMPLETE}},
+		{"rules off", EligibilityRules{}, CustomerCredit{ActiveHandouts: 9, Exposure: 90000000, ArrearsDays: 400, MissingKYC: []string{"PHOTO"}}, 100000, []string{}},
+		{"unrecovered write-off", rules, CustomerCredit{WrittenOff: 150000}, 100000, []string{RULE_NO_WRITE_OFF}},
+		{"write-off with rules off", EligibilityRules{}, CustomerCredit{WrittenOff: 1}, 100000, []string{RULE_NO_WRITE_OFF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluateEligibility(tt.rules, tt.credit, tt.amount)
+			failed := result.failedRules()
+			if result.Eligible != (len(tt.failed) == 0) {
+				t.Errorf("expected eligible %v, got %v", len(tt.failed) == 0, result.Eligible)
+			}
+			if len(failed) != len(tt.failed) {
+				t.Fatalf("expected failed rules %v, got %v", tt.failed, failed)
+			}
+			for i := range failed {
+				if failed[i] != tt.failed[i] {
+					t.Errorf("expected failed rules %v, got %v", tt.failed, failed)
+				}
+			}
+		})
+	}
+
+	if checks := evaluateEligibility(EligibilityRules{}, CustomerCredit{}, 0).Checks; checks == nil || len(checks) != 0 {
+		t.Errorf("expected an empty list of checks when every rule is off, got %v", checks)
+	}
+}
+
+func TestArrearsDays(t *testing.T) {
+	schedule := RepaymentSchedule{Principal: 100000, Installments: 4, Frequency: FREQUENCY_MONTHLY, FirstDueDate: date("2026-01-10")}
+
+	tests := []struct {
+		name        string
+		collections []Collection
+		asOf        string
+		want        int
+	}{
+		{"nothing due yet", nil, "2026-01-10", 0},
+		{"paid up", []Collection{{Date: date("2026-01-10"), Amount: 50000}}, "2026-03-01", 0},
+		// Months without a collection are not arrears while installments are met
+		{"paid ahead", []Collection{{Date: date("2025-12-20"), Amount: 75000}}, "2026-03-20", 0},
+		{"oldest unpaid counts", nil, "2026-02-20", 41},
+		{"partly paid", []Collection{{Date: date("2026-01-10"), Amount: 30000}}, "2026-02-20", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := schedule.plan()
+			applyPayments(plan, tt.collections, date(tt.asOf))
+			if got := arrearsDays(plan); got != tt.want {
+				t.Errorf("expected %d days in arrears, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ErrorCode is a stable, machine readable identifier for an error response.
+// Clients should branch on the code, the message is for humans and may change.
+type ErrorCode string
+
+// FieldError describes one invalid field of a request body
+type FieldError struct {
+	Field   string    `json:"field"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// APIError is the body of every error response
+type APIError struct {
+	Status  int          `json:"-"`
+	Code    ErrorCode    `json:"code"`
+	Msg     string       `json:"message"`
+	Details any          `json:"details,omitempty"`
+	Fields  []FieldError `json:"errors,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return string(e.Code) + ": " + e.Msg
+}
+
+func newAPIError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Msg: message}
+}
+
+// statusErrorCodes is the fallback code for responses sent without a specific one
+var statusErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            BAD_REQUEST,
+	http.StatusUnauthorized:          UNAUTHORIZED,
+	http.StatusForbidden:             FORBIDDEN,
+	http.StatusNotFound:              NOT_FOUND,
+	http.StatusMethodNotAllowed:      METHOD_NOT_ALLOWED,
+	http.StatusConflict:              CONFLICT,
+	http.StatusRequestEntityTooLarge: PAYLOAD_TOO_LARGE,
+	http.StatusUnsupportedMediaType:  UNSUPPORTED_MEDIA_TYPE,
+	http.StatusUnprocessableEntity:   UNPROCESSABLE,
+	http.StatusServiceUnavailable:    SERVICE_UNAVAILABLE,
+}
+
+func codeForStatus(status int) ErrorCode {
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return INTERNAL_ERROR
+	}
+	return BAD_REQUEST
+}
+
+func sendAPIError(w http.ResponseWriter, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+// sendError writes an error response with a specific code
+func sendError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	sendAPIError(w, newAPIError(status, code, message))
+}
+
+// sendValidationErrors reports every invalid field of a request at once
+func sendValidationErrors(w http.ResponseWriter, fields []FieldError) {
+	apiErr := newAPIError(http.StatusBadRequest, VALIDATION_FAILED, VALIDATION_FAILED_MSG)
+	apiErr.Fields = fields
+	sendAPIError(w, apiErr)
+}
+
+// sendDBError maps constraint violations to 4xx responses and anything else to a sanitized 500
+func sendDBError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := dbError(err)
+	if apiErr == nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	logRequestError(r, "request rejected by database constraint", err)
+	sendAPIError(w, apiErr)
+}
+
+// PostgreSQL SQLSTATE codes mapped by dbError
+const (
+	pqUniqueViolation           = "23505"
+	pqForeignKeyViolation       = "23503"
+	pqCheckViolation            = "23514"
+	pqNotNullViolation          = "23502"
+	pqInvalidTextRepresentation = "22P02"
+	pqStringDataRightTruncation = "22001"
+	pqNumericValueOutOfRange    = "22003"
+	pqInvalidDatetimeFormat     = "22007"
+	pqDatetimeFieldOverflow     = "22008"
+)
+
+// foreignKeyError is returned for a foreign key constraint, depending on
+// whether the referenced row is missing or is still referenced by others
+type foreignKeyError struct {
+	missing    *APIError
+	referenced *APIError
+}
+
+var foreignKeyErrors = map[string]foreignKeyError{
+	"fk_handouts_user": {
+		missing:    newAPIError(http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, CUSTOMER_HAS_HANDOUTS, CUSTOMER_HANDOUT_LINK_ERROR_MSG),
+	},
+	"collections_handout_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, HANDOUT_HAS_COLLECTIONS, HANDOUT_COLLECTION_LINK_ERROR_MSG),
+	},
+	"users_referred_by_fkey": {
+		missing: newAPIError(http.StatusNotFound, REFERRER_NOT_FOUND, REFERRER_NOT_FOUND_MSG),
+	},
+	"customer_documents_customer_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, CUSTOMER_HAS_DOCUMENTS, "Cannot delete, customer has KYC documents, delete them first"),
+	},
+	"collaterals_handout_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, HANDOUT_HAS_COLLATERAL, "Cannot delete, handout has collateral in its register"),
+	},
+	"referral_reward_ledger_referrer_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, CUSTOMER_HAS_REWARDS, "Cannot delete, customer has referral rewards in the ledger"),
+	},
+	"referral_reward_ledger_handout_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, HANDOUT_HAS_REWARDS, "Cannot delete, referral rewards were accrued on this handout, cancel it instead"),
+	},
+	"handout_parties_customer_id_fkey": {
+		missing:    newAPIError(http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG),
+		referenced: newAPIError(http.StatusConflict, CUSTOMER_IS_PARTY, "Cannot delete, customer is a guarantor or nominee of a handout"),
+	},
+	"customers_branch_id_fkey": {
+		missing: newAPIError(http.StatusNotFound, BRANCH_NOT_FOUND, BRANCH_NOT_FOUND_MSG),
+	},
+	"admins_branch_id_fkey": {
+		missing: newAPIError(http.StatusNotFound, BRANCH_NOT_FOUND, BRANCH_NOT_FOUND_MSG),
+	},
+}
+
+var uniqueErrors = map[string]*APIError{
+	"admins_username_key":          newAPIError(http.StatusConflict, USERNAME_TAKEN, USERNAME_TAKEN_MSG),
+	"branches_name_key":            newAPIError(http.StatusConflict, BRANCH_NAME_TAKEN, "A branch with this name already exists"),
+	"branches_code_key":            newAPIError(http.StatusConflict, BRANCH_CODE_TAKEN, "A branch with this code already exists"),
+	"handout_parties_customer_key": newAPIError(http.StatusConflict, PARTY_ALREADY_LINKED, "This customer is already linked to the handout in this role"),
+}
+
+// dbError translates a PostgreSQL error caused by the request's data into an
+// APIError. It returns nil for errors that are the server's fault.
+func dbError(err error) *APIError {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil
+	}
+
+	var apiErr *APIError
+	switch string(pqErr.Code) {
+	case pqUniqueViolation:
+		if known, ok := uniqueErrors[pqErr.Constraint]; ok {
+			return known
+		}
+		apiErr = newAPIError(http.StatusConflict, ALREADY_EXISTS, "A record with the same value already exists")
+	case pqForeignKeyViolation:
+		// Postgres reports deletes of a still referenced row as "update or delete on table ..."
+		stillReferenced := strings.HasPrefix(pqErr.Message, "update or delete")
+		if known, ok := foreignKeyErrors[pqErr.Constraint]; ok {
+			if stillReferenced && known.referenced != nil {
+				return known.referenced
+			}
+			if !stillReferenced && known.missing != nil {
+				return known.missing
+			}
+		}
+		if stillReferenced {
+			apiErr = newAPIError(http.StatusConflict, RECORD_IN_USE, "The record is still referenced by other records")
+		} else {
+			apiErr = newAPIError(http.StatusNotFound, REFERENCED_RECORD_NOT_FOUND, "A referenced record does not exist")
+		}
+	case pqCheckViolation, pqNotNullViolation:
+		apiErr = newAPIError(http.StatusBadRequest, VALIDATION_FAILED, VALIDATION_FAILED_MSG)
+		if pqErr.Column != "" {
+			apiErr.Fields = []FieldError{{Field: pqErr.Column, Code: INVALID_VALUE, Message: "value is not allowed"}}
+		}
+	case pqInvalidTextRepresentation, pqStringDataRightTruncation, pqNumericValueOutOfRange,
+		pqInvalidDatetimeFormat, pqDatetimeFieldOverflow:
+		// Includes values outside a Postgres enum such as order_status
+		apiErr = newAPIError(http.StatusBadRequest, INVALID_VALUE, "A value is invalid or out of range")
+	default:
+		return nil
+	}
+
+	if pqErr.Constraint != "" {
+		apiErr.Details = map[string]string{"constraint": pqErr.Constraint}
+	}
+	return apiErr
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestDBError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{"customer still has handouts", &pq.Error{Code: "23503", Constraint: "fk_handouts_user",
+			Message: `update or delete on table "customers" violates foreign key constraint "fk_handouts_user" on table "handouts"`},
+			http.StatusConflict, CUSTOMER_HAS_HANDOUTS},
+		{"handout still has collections", &pq.Error{Code: "23503", Constraint: "collections_handout_id_fkey",
+			Message: `update or delete on table "handouts" violates foreign key constraint "collections_handout_id_fkey" on table "collections"`},
+			http.StatusConflict, HANDOUT_HAS_COLLECTIONS},
+		{"collection for missing handout", &pq.Error{Code: "23503", Constraint: "collections_handout_id_fkey",
+			Message: `insert or update on table "collections" violates foreign key constraint "collections_handout_id_fkey"`},
+			http.StatusNotFound, HANDOUT_NOT_FOUND},
+		{"unknown foreign key", &pq.Error{Code: "23503", Constraint: "other_fkey", Message: "insert or update"},
+			http.StatusNotFound, REFERENCED_RECORD_NOT_FOUND},
+		{"taken username", &pq.Error{Code: "23505", Constraint: "admins_username_key"},
+			http.StatusConflict, USERNAME_TAKEN},
+		{"bad enum value", &pq.Error{Code: "22P02"}, http.StatusBadRequest, INVALID_VALUE},
+		{"check constraint", &pq.Error{Code: "23514", Column: "amount"}, http.StatusBadRequest, VALIDATION_FAILED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := dbError(tt.err)
+			if apiErr == nil {
+				t.Fatal("expected an APIError")
+			}
+			if apiErr.Status != tt.status || apiErr.Code != tt.code {
+				t.Errorf("got %d %s, want %d %s", apiErr.Status, apiErr.Code, tt.status, tt.code)
+			}
+		})
+	}
+
+	if dbError(&pq.Error{Code: "53300"}) != nil {
+		t.Error("too_many_connections should stay a 500")
+	}
+}
+
+func TestValidateHandoutReturnsAllFields(t *testing.T) {
+	status := "LOST"
+	fields := validateHandout(HandoutUpdate{Amount: -1, Status: &status})
+	if len(fields) != 4 {
+		t.Fatalf("got %d field errors, want 4: %+v", len(fields), fields)
+	}
+
+	fields = validateHandout(HandoutUpdate{CustomerId: 1, Amount: 100, Date: time.Now()})
+	if len(fields) != 0 {
+		t.Errorf("expected a valid handout, got %+v", fields)
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Party roles, mirroring the party_role enum in sql/migration-12.sql
+const (
+	ROLE_GUARANTOR = "GUARANTOR"
+	ROLE_NOMINEE   = "NOMINEE"
+)
+
+// HandoutParty is a guarantor or nominee of a handout: either an existing
+// customer (CustomerID set) or an external person
+type HandoutParty struct {
+	ID           int    `json:"id"`
+	HandoutID    int    `json:"handoutId"`
+	Role         string `json:"role"`
+	CustomerID   *int   `json:"customerId"` // nil for external persons
+	Name         string `json:"name"`
+	Mobile       int    `json:"mobile"`
+	Address      string `json:"address"`
+	Relationship string `json:"relationship"`
+	// Percent of the loan a guarantor is liable for, or a nominee's entitlement
+	LiabilityShare float64   `json:"liabilityShare"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// HandoutPartyInput links a customer (CustomerID) or describes an external
+// person (Name, Mobile, Address), never both
+type HandoutPartyInput struct {
+	CustomerID     int     `json:"customerId"`
+	Name           string  `json:"name"`
+	Mobile         int     `json:"mobile"`
+	Address        string  `json:"address"`
+	Relationship   string  `json:"relationship"`
+	LiabilityShare float64 `json:"liabilityShare"`
+}
+
+// GuaranteedLoan is one loan a person guarantees, with their share of what is still owed
+type GuaranteedLoan struct {
+	PartyID        int                    `json:"partyId"`
+	Relationship   string                 `json:"relationship"`
+	LiabilityShare float64                `json:"liabilityShare"`
+	HandoutID      int                    `json:"handoutId"`
+	Date           time.Time              `json:"date"`
+	Amount         Money                  `json:"amount"`
+	Status         string                 `json:"status"`
+	BranchID       int                    `json:"branchId"`
+	Borrower       HandoutCustomerDetails `json:"borrower"`
+	Collected      Money                  `json:"collected"`
+	Outstanding    Money                  `json:"outstanding"`
+	Liability      Money                  `json:"liability"`
+}
+
+// GuaranteeExposure sums up every loan a person guarantees
+type GuaranteeExposure struct {
+	Loans            []GuaranteedLoan `json:"loans"`
+	ActiveLoans      int              `json:"activeLoans"`
+	TotalOutstanding Money            `json:"totalOutstanding"`
+	TotalLiability   Money            `json:"totalLiability"`
+}
+
+// shareBasisPoints converts a percent with at most two decimals to basis
+// points, e.g. 12.5 to 1250. ok is false for anything else.
+func shareBasisPoints(share float64) (int64, bool) {
+	basisPoints := math.Round(share * 100)
+	if math.Abs(share*100-basisPoints) > 1e-6 || basisPoints <= 0 || basisPoints > 10000 {
+		return 0, false
+	}
+	return int64(basisPoints), true
+}
+
+// validateHandoutParty returns every invalid field of a guarantor or nominee, nil when it is valid
+func validateHandoutParty(party HandoutPartyInput) []FieldError {
+	var fields []FieldError
+
+	if party.CustomerID > 0 {
+		if party.Name != "" || party.Mobile != 0 || party.Address != "" {
+			fields = append(fields, FieldError{Field: "customerId", Code: INVALID_VALUE, Message: "give either a customer or the name and mobile of an external person"})
+		}
+	} else {
+		if party.Name == "" {
+			fields = append(fields, FieldError{Field: "name", Code: REQUIRED, Message: "name or customer cannot be empty"})
+		}
+		if party.Mobile < 1000000000 || party.Mobile > 9999999999 {
+			fields = append(fields, FieldError{Field: "mobile", Code: INVALID_VALUE, Message: "Enter a valid mobile number"})
+		}
+	}
+
+	if party.Relationship == "" {
+		fields = append(fields, FieldError{Field: "relationship", Code: REQUIRED, Message: "relationship cannot be empty"})
+	}
+
+	if _, ok := shareBasisPoints(party.LiabilityShare); !ok {
+		fields = append(fields, FieldError{Field: "liabilityShare", Code: INVALID_VALUE, Message: "must be a percent above 0 and at most 100 with at most two decimals"})
+	}
+	return fields
+}
+
+func scanHandoutParty(row interface{ Scan(...any) error }) (party HandoutParty, err error) {
+	err = row.Scan(&party.ID, &party.HandoutID, &party.Role, &party.CustomerID, &party.Name, &party.Mobile,
+		&party.Address, &party.Relationship, &party.LiabilityShare, &party.CreatedAt, &party.UpdatedAt)
+	return party, err
+}
+
+// branchHandoutParties loads the guarantors and nominees of every handout the caller can see
+func branchHandoutParties(r *http.Request) (guarantors, nominees map[int][]HandoutParty, err error) {
+	rows, err := db.QueryContext(r.Context(), GET_BRANCH_HANDOUT_PARTIES, branchScope(r))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	guarantors = map[int][]HandoutParty{}
+	nominees = map[int][]HandoutParty{}
+	for rows.Next() {
+		party, err := scanHandoutParty(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if party.Role == ROLE_GUARANTOR {
+			guarantors[party.HandoutID] = append(guarantors[party.HandoutID], party)
+		} else {
+			nominees[party.HandoutID] = append(nominees[party.HandoutID], party)
+		}
+	}
+	return guarantors, nominees, rows.Err()
+}
+
+// partiesOrEmpty keeps handouts without parties encoded as [] rather than null
+func partiesOrEmpty(parties []HandoutParty) []HandoutParty {
+	if parties == nil {
+		return []HandoutParty{}
+	}
+	return parties
+}
+
+func getHandoutGuarantors(w http.ResponseWriter, r *http.Request) {
+	listHandoutParties(w, r, ROLE_GUARANTOR)
+}
+
+func getHandoutNominees(w http.ResponseWriter, r *http.Request) {
+	listHandoutParties(w, r, ROLE_NOMINEE)
+}
+
+func addHandoutGuarantor(w http.ResponseWriter, r *http.Request) {
+	addHandoutParty(w, r, ROLE_GUARANTOR)
+}
+
+func addHandoutNominee(w http.ResponseWriter, r *http.Request) {
+	addHandoutParty(w, r, ROLE_NOMINEE)
+}
+
+func deleteHandoutGuarantor(w http.ResponseWriter, r *http.Request) {
+	deleteHandoutParty(w, r, ROLE_GUARANTOR)
+}
+
+func deleteHandoutNominee(w http.ResponseWriter, r *http.Request) {
+	deleteHandoutParty(w, r, ROLE_NOMINEE)
+}
+
+// listHandoutParties writes the guarantors or nominees of a handout
+func listHandoutParties(w http.ResponseWriter, r *http.Request, role string) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_HANDOUT_PARTIES, handoutID, role, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	parties := []HandoutParty{}
+	for rows.Next() {
+		party, err := scanHandoutParty(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		parties = append(parties, party)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]HandoutParty]{
+		D:   parties,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// addHandoutParty links a guarantor or nominee. The shares of one role on a
+// handout may not add up to more than 100%.
+func addHandoutParty(w http.ResponseWriter, r *http.Request, role string) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var input HandoutPartyInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	if fields := validateHandoutParty(input); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the handout serialises concurrent additions for the share check
+	var borrowerID int
+	err = tx.QueryRow(LOCK_HANDOUT, handoutID, branchScope(r)).Scan(&borrowerID)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	var customerID, name, mobile any
+	if input.CustomerID > 0 {
+		if input.CustomerID == borrowerID {
+			sendValidationErrors(w, []FieldError{{Field: "customerId", Code: INVALID_VALUE, Message: "the borrower cannot be their own guarantor or nominee"}})
+			return
+		}
+		var exists bool
+		if err := tx.QueryRow(CHECK_CUSTOMER_EXISTS, input.CustomerID, branchScope(r)).Scan(&exists); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		if !exists {
+			sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+			return
+		}
+		customerID = input.CustomerID
+	} else {
+		name, mobile = input.Name, input.Mobile
+	}
+
+	var total float64
+	if err := tx.QueryRow(GET_PARTY_SHARE_TOTAL, handoutID, role).Scan(&total); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	existing, _ := shareBasisPoints(total)
+	added, _ := shareBasisPoints(input.LiabilityShare)
+	if existing+added > 10000 {
+		sendError(w, http.StatusConflict, LIABILITY_SHARE_EXCEEDED,
+			"Shares of this handout would exceed 100%, "+strconv.FormatFloat(total, 'f', -1, 64)+"% is already assigned")
+		return
+	}
+
+	createdBy, _ := r.Context().Value("adminID").(int)
+	var partyID int
+	err = tx.QueryRow(CREATE_HANDOUT_PARTY, handoutID, role, customerID, name, mobile,
+		input.Address, input.Relationship, input.LiabilityShare, createdBy).Scan(&partyID)
+	if err != nil {
+		// A customer linked twice is reported as PARTY_ALREADY_LINKED
+		sendDBError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	party, err := scanHandoutParty(db.QueryRowContext(r.Context(), GET_HANDOUT_PARTY, partyID))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[HandoutParty]{
+		D:   party,
+		Msg: "Added successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteHandoutParty(w http.ResponseWriter, r *http.Request, role string) {
+	vars := mux.Vars(r)
+	handoutID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+	partyID, err := strconv.Atoi(vars["partyId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), DELETE_HANDOUT_PARTY, partyID, handoutID, role, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if rowsAffected == 0 {
+		sendError(w, http.StatusNotFound, PARTY_NOT_FOUND, PARTY_NOT_FOUND_MSG)
+		return
+	}
+
+	resp := MsgResp{
+		Msg: "Removed successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getCustomerGuarantees lists the loans a customer guarantees, including those
+// where they were recorded as an external person with the same mobile number
+func getCustomerGuarantees(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	customer, err := getCustomerById(r.Context(), customerID, branchScope(r))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	sendGuaranteeExposure(w, r, customer.ID, customer.Mobile)
+}
+
+// getGuaranteesByMobile lists the loans guaranteed by whoever has the ?mobile= number
+func getGuaranteesByMobile(w http.ResponseWriter, r *http.Request) {
+	mobile, err := strconv.Atoi(r.URL.Query().Get("mobile"))
+	if err != nil || mobile < 1000000000 || mobile > 9999999999 {
+		sendValidationErrors(w, []FieldError{{Field: "mobile", Code: INVALID_VALUE, Message: "Enter a valid mobile number"}})
+		return
+	}
+
+	sendGuaranteeExposure(w, r, 0, mobile)
+}
+
+func sendGuaranteeExposure(w http.ResponseWriter, r *http.Request, customerID, mobile int) {
+	rows, err := db.QueryContext(r.Context(), GET_GUARANTEES, customerID, mobile, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	exposure := GuaranteeExposure{Loans: []GuaranteedLoan{}}
+	for rows.Next() {
+		var loan GuaranteedLoan
+		err := rows.Scan(&loan.PartyID, &loan.Relationship, &loan.LiabilityShare,
+			&loan.HandoutID, &loan.Date, &loan.Amount, &loan.Status, &loan.BranchID,
+			&loan.Borrower.ID, &loan.Borrower.Name, &loan.Borrower.Mobile,
+			&loan.Collected)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+
+		// Only loans still being repaid carry any liability
+		loan.Outstanding = outstanding(loan.Status, loan.Amount, loan.Collected)
+		if loan.Outstanding > 0 {
+			basisPoints, _ := shareBasisPoints(loan.LiabilityShare)
+			loan.Liability = loan.Outstanding.MulRatio(basisPoints, 10000)
+			exposure.ActiveLoans++
+			exposure.TotalOutstanding += loan.Outstanding
+			exposure.TotalLiability += loan.Liability
+		}
+		exposure.Loans = append(exposure.Loans, loan)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[GuaranteeExposure]{
+		D:   exposure,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
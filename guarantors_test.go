@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestShareBasisPoints(t *testing.T) {
+	tests := []struct {
+		share float64
+		want  int64
+		ok    bool
+	}{
+		{12.5, 1250, true},
+		{33.33, 3333, true},
+		{100, 10000, true},
+		{0.01, 1, true},
+		{0, 0, false},
+		{-5, 0, false},
+		{100.01, 0, false},
+		{33.333, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := shareBasisPoints(tt.share)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("shareBasisPoints(%v) = %d, %v, want %d, %v", tt.share, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValidateHandoutParty(t *testing.T) {
+	tests := []struct {
+		name   string
+		party  HandoutPartyInput
+		fields int
+	}{
+		{"linked customer", HandoutPartyInput{CustomerID: 4, Relationship: "Brother", LiabilityShare: 50}, 0},
+		{"external person", HandoutPartyInput{Name: "Ravi", Mobile: 9876543210, Relationship: "Friend", LiabilityShare: 100}, 0},
+		{"customer and external details", HandoutPartyInput{CustomerID: 4, Name: "Ravi", Relationship: "Friend", LiabilityShare: 50}, 1},
+		{"nothing given", HandoutPartyInput{}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fields := validateHandoutParty(tt.party); len(fields) != tt.fields {
+				t.Errorf("got %d field errors, want %d: %+v", len(fields), tt.fields, fields)
+			}
+		})
+	}
+}
@@ -1,35 +1,130 @@
 package main
 
-import "errors"
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
 
-func validateHandout(handout HandoutUpdate) error {
+// validateHandout returns every invalid field of a handout request, nil when it is valid
+func validateHandout(handout HandoutUpdate) []FieldError {
+	var fields []FieldError
 
-	if handout.CustomerId == 0 {
-		return errors.New("customer cannot be empty")
+	if handout.CustomerId <= 0 {
+		fields = append(fields, FieldError{Field: "customerId", Code: REQUIRED, Message: "customer cannot be empty"})
 	}
 
 	if handout.Date.IsZero() {
-		return errors.New("date cannot be empty")
+		fields = append(fields, FieldError{Field: "date", Code: REQUIRED, Message: "date cannot be empty"})
 	}
 
 	if handout.Amount <= 0 {
-		return errors.New("enter a valid amount")
+		fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"})
+	}
+
+	if handout.Status != nil && *handout.Status != "" && !validHandoutStatuses[*handout.Status] {
+		fields = append(fields, FieldError{Field: "status", Code: INVALID_VALUE, Message: "status must be ACTIVE, PENDING, CANCELLED or COMPLETED"})
+	}
+
+	if handout.OverrideEligibility && handout.OverrideReason == "" {
+		fields = append(fields, FieldError{Field: "overrideReason", Code: REQUIRED, Message: "a reason is required to override eligibility rules"})
+	}
+	return fields
+}
+
+// customerBranch returns the branch of a customer the caller can see. It
+// writes a 404 and returns false when there is none.
+func customerBranch(w http.ResponseWriter, r *http.Request, customerID int) (int, bool) {
+	var branchID int
+	err := db.QueryRowContext(r.Context(), GET_CUSTOMER_BRANCH, customerID, branchScope(r)).Scan(&branchID)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return 0, false
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return 0, false
+	}
+	return branchID, true
+}
+
+// handoutBranch returns the branch of a handout the caller can see. It
+// writes a 404 and returns false when there is none.
+func handoutBranch(w http.ResponseWriter, r *http.Request, handoutID int) (int, bool) {
+	var branchID int
+	err := db.QueryRowContext(r.Context(), GET_HANDOUT_BRANCH, handoutID, branchScope(r)).Scan(&branchID)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return 0, false
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return 0, false
+	}
+	return branchID, true
+}
+
+// validHandoutStatuses are the order_status values a handout can be given,
+// WRITTEN_OFF (sql/migration-21.sql) only by writing it off
+var validHandoutStatuses = map[string]bool{
+	"ACTIVE":    true,
+	"PENDING":   true,
+	"CANCELLED": true,
+	"COMPLETED": true,
+}
+
+// outstanding is what is still owed on a handout. Only ACTIVE and PENDING
+// handouts are owed anything, overpayments count as nothing owed.
+func outstanding(status string, amount, collected Money) Money {
+	if (status != "ACTIVE" && status != "PENDING") || collected >= amount {
+		return 0
+	}
+	return amount - collected
+}
+
+// handoutTerms are the fields of a handout a settlement was quoted on
+type handoutTerms struct {
+	Status string
+	Amount Money
+	Date   time.Time
+}
+
+// frozenHandout refuses an update of a handout from previous to next. A
+// written-off handout cannot be changed at all, and a settled one keeps its
+// status, amount and date: reopening it would owe the rebated interest again
+// and the settlement was quoted on the rest. It returns nil when the update
+// can go ahead.
+func frozenHandout(previous, next handoutTerms, settled bool) *APIError {
+	if previous.Status == "WRITTEN_OFF" {
+		return newAPIError(http.StatusConflict, HANDOUT_WRITTEN_OFF, "The handout has been written off and can no longer be changed")
+	}
+	if !settled {
+		return nil
+	}
+	if next.Status != previous.Status {
+		return newAPIError(http.StatusConflict, HANDOUT_SETTLED, "The handout was settled early and its status can no longer be changed")
+	}
+	// Postgres keeps timestamps to the microsecond
+	if next.Amount != previous.Amount || !next.Date.Truncate(time.Microsecond).Equal(previous.Date) {
+		return newAPIError(http.StatusConflict, HANDOUT_SETTLED, "The handout was settled early and its amount and date can no longer be changed")
 	}
 	return nil
 }
 
-func validateCollection(collection Collection) error {
+// validateCollection returns every invalid field of a collection request, nil when it is valid
+func validateCollection(collection Collection) []FieldError {
+	var fields []FieldError
 
-	if collection.HandoutId == 0 {
-		return errors.New("handout id cannot be empty")
+	if collection.HandoutId <= 0 {
+		fields = append(fields, FieldError{Field: "handoutId", Code: REQUIRED, Message: "handout id cannot be empty"})
 	}
 
 	if collection.Date.IsZero() {
-		return errors.New("date cannot be empty")
+		fields = append(fields, FieldError{Field: "date", Code: REQUIRED, Message: "date cannot be empty"})
 	}
 
 	if collection.Amount <= 0 {
-		return errors.New("enter a valid amount")
+		fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"})
 	}
-	return nil
+	return fields
 }
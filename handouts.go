@@ -5,21 +5,27 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func getHandouts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	rows, err := db.Query(GET_HANDOUTS_WITH_CUSTOMERS)
+	rows, err := db.QueryContext(r.Context(), GET_HANDOUTS_WITH_CUSTOMERS, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
 
+	guarantors, nominees, err := branchHandoutParties(r)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
 	handouts := []HandoutResp{}
 
 	for rows.Next() {
@@ -29,22 +35,24 @@ func getHandouts(w http.ResponseWriter, r *http.Request) {
 
 		err = rows.Scan(
 			&handout.ID, &handout.Amount, &handout.Date,
-			&handout.Status, &handout.Bond,
+			&handout.Status, &handout.Bond, &handout.BranchID,
 			&handout.CreatedAt, &handout.UpdatedAt,
 			&customer.ID, &customer.Name, &customer.Mobile,
 		)
 		if err != nil {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendInternalError(w, r, err)
 			return
 		}
 
 		handoutResp.Handout = handout
 		handoutResp.Customer = customer
+		handoutResp.Guarantors = partiesOrEmpty(guarantors[handout.ID])
+		handoutResp.Nominees = partiesOrEmpty(nominees[handout.ID])
 		handouts = append(handouts, handoutResp)
 	}
 
 	if err = rows.Err(); err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -57,17 +65,16 @@ func getHandouts(w http.ResponseWriter, r *http.Request) {
 
 func getCustomerHandouts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
-	rows, err := db.Query(GET_CUSTOMER_HANDOUTS, id)
+	rows, err := db.QueryContext(r.Context(), GET_CUSTOMER_HANDOUTS, id, branchScope(r))
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
@@ -79,11 +86,11 @@ func getCustomerHandouts(w http.ResponseWriter, r *http.Request) {
 
 		err = rows.Scan(
 			&handout.ID, &handout.Date, &handout.Amount,
-			&handout.Status, &handout.Bond,
+			&handout.Status, &handout.Bond, &handout.BranchID,
 			&handout.CreatedAt, &handout.UpdatedAt,
 		)
 		if err != nil {
-			sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			sendInternalError(w, r, err)
 			return
 		}
 
@@ -91,7 +98,7 @@ func getCustomerHandouts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = rows.Err(); err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -104,24 +111,23 @@ func getCustomerHandouts(w http.ResponseWriter, r *http.Request) {
 
 func getHandout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	var handout Handout
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
-	err = db.QueryRow(GET_HANDOUT_BY_ID, id).Scan(&handout.ID, &handout.Date, &handout.Amount,
-		&handout.Status, &handout.Bond, &handout.CreatedAt, &handout.UpdatedAt)
+	err = db.QueryRowContext(r.Context(), GET_HANDOUT_BY_ID, id, branchScope(r)).Scan(&handout.ID, &handout.Date, &handout.Amount,
+		&handout.Status, &handout.Bond, &handout.BranchID, &handout.CreatedAt, &handout.UpdatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			sendErrorResponse(w, HANDOUTS_NOT_FOUND_MSG, http.StatusNotFound)
+			sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
 			return
 		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -134,7 +140,6 @@ func getHandout(w http.ResponseWriter, r *http.Request) {
 
 func createHandout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if r.Method != http.MethodPost {
 		sendErrorResponse(w, "Method not allow", http.StatusMethodNotAllowed)
@@ -142,14 +147,11 @@ func createHandout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var handout HandoutUpdate
-	err := json.NewDecoder(r.Body).Decode(&handout)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &handout) {
 		return
 	}
-	err = validateHandout(handout)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	if fields := validateHandout(handout); len(fields) > 0 {
+		sendValidationErrors(w, fields)
 		return
 	}
 
@@ -158,22 +160,66 @@ func createHandout(w http.ResponseWriter, r *http.Request) {
 	if handout.Status != nil && *handout.Status != "" {
 		status = *handout.Status
 	}
-	bond := true
-	if handout.Bond != nil {
-		bond = *handout.Bond
+
+	// The handout belongs to the customer's branch
+	branchID, ok := customerBranch(w, r, handout.CustomerId)
+	if !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Disbursements to one customer are checked one at a time
+	var locked int
+	if err := tx.QueryRow(LOCK_CUSTOMER, handout.CustomerId, branchScope(r)).Scan(&locked); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	owed := outstanding(status, handout.Amount, 0)
+	var failedRules []string
+	if owed > 0 {
+		var ok bool
+		if failedRules, ok = checkEligibility(w, r, tx, handout, 0, owed); !ok {
+			return
+		}
 	}
 
-	_, dbErr := db.Exec(
+	var handoutID int
+	dbErr := tx.QueryRow(
 		CREATE_HANDOUTS,
 		handout.Date,
 		handout.Amount,
 		status,
-		bond,
 		handout.CustomerId,
-	)
+		branchID,
+	).Scan(&handoutID)
 
 	if dbErr != nil {
-		sendErrorResponse(w, dbErr.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, dbErr)
+		return
+	}
+
+	if len(failedRules) > 0 {
+		if err := recordEligibilityOverride(tx, r, handoutID, handout, owed, failedRules); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	// The borrower's referrers earn their disbursement rewards right away
+	adminID, _ := r.Context().Value("adminID").(int)
+	if err := accrueReferralRewards(tx, handoutID, adminID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
 		return
 	}
 	resp := MsgResp{
@@ -185,12 +231,11 @@ func createHandout(w http.ResponseWriter, r *http.Request) {
 
 func deleteHandout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
@@ -200,25 +245,22 @@ func deleteHandout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute delete query
-	result, err := db.Exec(DELETE_HANDOUTS, id)
+	result, err := db.ExecContext(r.Context(), DELETE_HANDOUTS, id, branchScope(r))
 	if err != nil {
-		if isForeignKeyViolation(err) {
-			sendErrorResponse(w, HANDOUT_COLLECTION_LINK_ERROR_MSG, http.StatusInternalServerError)
-			return
-		}
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		// Handouts with collections are rejected with HANDOUT_HAS_COLLECTIONS
+		sendDBError(w, r, err)
 		return
 	}
 
 	// Check if any row was affected
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendInternalError(w, r, err)
 		return
 	}
 
 	if rowsAffected == 0 {
-		sendErrorResponse(w, "Handout not found", http.StatusNotFound)
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, "Handout not found")
 		return
 	}
 
@@ -230,48 +272,150 @@ func deleteHandout(w http.ResponseWriter, r *http.Request) {
 
 func putHandout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		sendErrorResponse(w, INVALID_ID_MSG, http.StatusBadRequest)
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
 		return
 	}
 
 	var handout HandoutUpdate
-	err = json.NewDecoder(r.Body).Decode(&handout)
+	if !decodeJSON(w, r, &handout) {
+		return
+	}
+	if fields := validateHandout(handout); len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	// An omitted status keeps its current value (NULL in UPDATE_HANDOUT)
+	if handout.Status != nil && *handout.Status == "" {
+		handout.Status = nil
+	}
+
+	// Moving a handout to a customer of another branch would strand its
+	// collections, that is what a customer transfer is for
+	currentBranch, ok := handoutBranch(w, r, id)
+	if !ok {
+		return
+	}
+	branchID, ok := customerBranch(w, r, handout.CustomerId)
+	if !ok {
+		return
+	}
+	if branchID != currentBranch {
+		sendError(w, http.StatusConflict, BRANCH_MISMATCH, BRANCH_MISMATCH_MSG)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var previousCustomer int
+	var previousAmount, collected Money
+	var previousStatus string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, id, branchScope(r)).Scan(&previousCustomer, &previousAmount, &previousStatus, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
 		return
 	}
-	err = validateHandout(handout)
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		sendInternalError(w, r, err)
 		return
 	}
 
-	// Apply default values if not provided
-	status := "ACTIVE"
-	if handout.Status != nil && *handout.Status != "" {
+	status := previousStatus
+	if handout.Status != nil {
 		status = *handout.Status
 	}
-	bond := true
-	if handout.Bond != nil {
-		bond = *handout.Bond
+
+	// Written off for good, what is still collected are recoveries. A settled
+	// handout stays closed on the terms it was settled on.
+	var previousDate time.Time
+	var settled bool
+	if err := tx.QueryRow(GET_HANDOUT_SETTLED, id).Scan(&previousDate, &settled); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	previous := handoutTerms{Status: previousStatus, Amount: previousAmount, Date: previousDate}
+	next := handoutTerms{Status: status, Amount: handout.Amount, Date: handout.Date}
+	if apiErr := frozenHandout(previous, next, settled); apiErr != nil {
+		sendAPIError(w, apiErr)
+		return
+	}
+
+	// A schedule was drawn up over the amount, a restructure changes it and
+	// keeps the terms it replaces
+	if handout.Amount != previousAmount {
+		var scheduled bool
+		if err := tx.QueryRow(CHECK_HANDOUT_SCHEDULE, id).Scan(&scheduled); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		if scheduled {
+			sendError(w, http.StatusConflict, HANDOUT_SCHEDULED, "The handout has a repayment schedule, restructure it to change the amount")
+			return
+		}
+	}
+
+	// Only an update that makes the customer owe more is a disbursement to
+	// check, e.g. completing or cancelling a handout always goes through
+	owed := outstanding(status, handout.Amount, collected)
+	var failedRules []string
+	if owed > 0 && (handout.CustomerId != previousCustomer || owed > outstanding(previousStatus, previousAmount, collected)) {
+		var locked int
+		if err := tx.QueryRow(LOCK_CUSTOMER, handout.CustomerId, branchScope(r)).Scan(&locked); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		var ok bool
+		if failedRules, ok = checkEligibility(w, r, tx, handout, id, owed); !ok {
+			return
+		}
 	}
 
-	_, err = db.Exec(
+	_, err = tx.Exec(
 		UPDATE_HANDOUT,
 		handout.Date,
 		handout.Amount,
-		status,
-		bond,
+		handout.Status,
 		handout.CustomerId,
 		id,
+		branchScope(r),
 	)
 
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		sendDBError(w, r, err)
+		return
+	}
+
+	if len(failedRules) > 0 {
+		if err := recordEligibilityOverride(tx, r, id, handout, owed, failedRules); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	// A cancelled handout was never really lent, so its referral rewards are
+	// taken back. Otherwise rewards it has now earned, e.g. the completion
+	// bonus, are added.
+	adminID, _ := r.Context().Value("adminID").(int)
+	if handout.Status != nil && *handout.Status == "CANCELLED" {
+		_, err = clawBackReferralRewards(tx, id, "Handout cancelled", adminID)
+	} else {
+		err = accrueReferralRewards(tx, id, adminID)
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// EXPECTED_SCHEMA_VERSION is the latest sql/migration-N.sql this build needs.
+// Bump it together with every new migration.
+const EXPECTED_SCHEMA_VERSION = 23
+
+const readinessPingTimeout = 2 * time.Second
+
+// buildCommit is set at build time with -ldflags "-X main.buildCommit=<sha>"
+var buildCommit = ""
+
+// startedAt is the time the process started serving
+var startedAt = time.Now()
+
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type MigrationCheckResult struct {
+	Status   string `json:"status"`
+	Current  int    `json:"current"`
+	Expected int    `json:"expected"`
+	Error    string `json:"error,omitempty"`
+}
+
+type PoolStats struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"`
+	OpenConnections    int   `json:"openConnections"`
+	InUse              int   `json:"inUse"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"waitCount"`
+	WaitDurationMs     int64 `json:"waitDurationMs"`
+}
+
+type ReadinessStatus struct {
+	Status        string               `json:"status"`
+	Database      CheckResult          `json:"database"`
+	Migrations    MigrationCheckResult `json:"migrations"`
+	Pool          PoolStats            `json:"pool"`
+	UptimeSeconds int64                `json:"uptimeSeconds"`
+}
+
+type LivenessStatus struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+}
+
+type VersionInfo struct {
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"goVersion"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// getBuildCommit falls back to the VCS revision embedded by the Go toolchain
+func getBuildCommit() string {
+	if buildCommit != "" {
+		return buildCommit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+func uptimeSeconds() int64 {
+	return int64(time.Since(startedAt).Seconds())
+}
+
+// Liveness probe - the process is up and able to serve requests
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	resp := DataResp[LivenessStatus]{
+		D: LivenessStatus{
+			Status:        "ok",
+			UptimeSeconds: uptimeSeconds(),
+		},
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Readiness probe - the database is reachable and migrated to the expected version
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	status := ReadinessStatus{
+		Status:        "ok",
+		UptimeSeconds: uptimeSeconds(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		// Details stay server side, this endpoint is public
+		status.Database = CheckResult{Status: "unavailable", Error: "database ping failed"}
+	} else {
+		status.Database = CheckResult{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+	}
+
+	status.Migrations.Expected = EXPECTED_SCHEMA_VERSION
+	if err := db.QueryRowContext(ctx, GET_SCHEMA_VERSION).Scan(&status.Migrations.Current); err != nil {
+		status.Migrations.Status = "unavailable"
+		status.Migrations.Error = "could not read schema version"
+	} else if status.Migrations.Current < EXPECTED_SCHEMA_VERSION {
+		status.Migrations.Status = "outdated"
+	} else {
+		status.Migrations.Status = "ok"
+	}
+
+	stats := db.Stats()
+	status.Pool = PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+	}
+
+	statusCode := http.StatusOK
+	if status.Database.Status != "ok" || status.Migrations.Status != "ok" {
+		status.Status = "unavailable"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	resp := DataResp[ReadinessStatus]{
+		D:   status,
+		Msg: status.Status,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Build information for the running process
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	info := VersionInfo{
+		Commit:    getBuildCommit(),
+		StartedAt: startedAt,
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = buildInfo.GoVersion
+	}
+
+	resp := DataResp[VersionInfo]{
+		D:   info,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo is shared by the logging middleware and the handlers below it.
+// authMiddleware runs on a subrouter with its own request context, so it
+// records the admin here instead of relying on context values flowing back up.
+type requestInfo struct {
+	ID       string
+	Route    string
+	AdminID  int
+	APIKeyID int
+}
+
+// initLogger installs a slog default logger; the standard log package writes through it too
+func initLogger(cfg config.LoggingConfig) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, options)
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// getRequestInfo returns the request's logging info, or an empty one outside requestLogger
+func getRequestInfo(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value("requestInfo").(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// validRequestID accepts caller supplied IDs that are safe to echo and log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// requestLogger assigns every request an ID (propagating X-Request-ID when
+// present) and writes one access log line once the response is complete
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{ID: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.ID) {
+			info.ID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, info.ID)
+
+		ctx := context.WithValue(r.Context(), "requestInfo", info)
+		ctx, span := startHTTPSpan(ctx, r)
+		start := time.Now()
+		metrics := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		elapsed := time.Since(start)
+		route := info.Route
+		if route == "" {
+			route = "unmatched"
+		}
+		observeHTTPRequest(r.Method, route, metrics.Code, elapsed)
+		endHTTPSpan(span, r, info, route, metrics.Code)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", info.ID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", metrics.Code),
+			slog.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+			slog.Int64("bytes", metrics.Written),
+			slog.String("remote_ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if info.AdminID != 0 {
+			attrs = append(attrs, slog.Int("admin_id", info.AdminID))
+		}
+		if info.APIKeyID != 0 {
+			attrs = append(attrs, slog.Int("api_key_id", info.APIKeyID))
+		}
+		if id := traceID(ctx); id != "" {
+			attrs = append(attrs, slog.String("trace_id", id))
+		}
+
+		level := slog.LevelInfo
+		if metrics.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if metrics.Code >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(ctx, level, "http request", attrs...)
+	})
+}
+
+// recordRoute stores the matched mux route template (e.g. /customers/{id}) for requestLogger
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				getRequestInfo(r.Context()).Route = template
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequestError logs a server side failure with the request's correlation ID
+func logRequestError(r *http.Request, msg string, err error) {
+	info := getRequestInfo(r.Context())
+	slog.ErrorContext(r.Context(), msg,
+		slog.String("request_id", info.ID),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("admin_id", info.AdminID),
+		slog.String("trace_id", traceID(r.Context())),
+		slog.Any("error", err),
+	)
+}
+
+// sendInternalError logs the underlying error and returns a sanitized 500 to the client
+func sendInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logRequestError(r, "request failed", err)
+	sendErrorResponse(w, ERROR_MSG, http.StatusInternalServerError)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -13,12 +15,17 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/yogesh-k64/middleware-finance-app/config"
 )
 
-var db *sql.DB
+// db is the traced connection pool, see tracing.go
+var db *tracedDB
 
-func initDb() {
-	databaseUrl := os.Getenv("DATABASE_URL")
+// appConfig holds the settings loaded at startup, see the config package
+var appConfig *config.Config
+
+func initDb(cfg config.DatabaseConfig) {
+	databaseUrl := cfg.URL
 	if databaseUrl == "" {
 		log.Fatal("DATABASE_URL environment variable is required")
 	}
@@ -32,24 +39,27 @@ func initDb() {
 		}
 	}
 
-	// ✅ Force DNS resolution via Google DNS (fix for Railway)
-	net.DefaultResolver = &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{}
-			return d.DialContext(ctx, "udp", "8.8.8.8:53")
-		},
+	// Resolve the database host through a fixed DNS server when configured (needed on Railway)
+	if cfg.DNSResolver != "" && cfg.DNSResolver != config.SystemResolver {
+		resolver := cfg.DNSResolver
+		net.DefaultResolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, "udp", resolver)
+			},
+		}
 	}
 
 	log.Println("Connecting to database...")
 
-	var err error
-	db, err = sql.Open("postgres", databaseUrl)
+	sqlDB, err := sql.Open("postgres", databaseUrl)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
+	db = &tracedDB{DB: sqlDB}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
@@ -59,27 +69,60 @@ func initDb() {
 	log.Println("✅ Successfully connected to database")
 }
 
-func main() {
+// loadConfig loads and validates appConfig, exiting on invalid settings
+func loadConfig(path string) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	initLogger(cfg.Logging)
+	for _, warning := range cfg.Warnings() {
+		log.Printf("⚠️  %s", warning)
+	}
+	appConfig = cfg
+}
 
-	initDb()
-	defer db.Close()
+// runConfigCommand handles "finance-app config print"
+func runConfigCommand(path string, args []string) {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: finance-app [-config file.yaml] config print")
+		os.Exit(2)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9000"
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
 	}
-	r := mux.NewRouter()
+	cfg.Print(os.Stdout)
 
-	// commenting this out to use custom CORS settings below
-	// r.Use(mux.CORSMethodMiddleware(r))
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+}
 
+// registerRoutes adds the API routes, every one of them is described in api/openapi.json
+func registerRoutes(r *mux.Router) {
 	// Public routes (no authentication required)
-	r.HandleFunc("/user/login", adminLogin).Methods("POST")
-	r.HandleFunc("/health-check", getHealthCheck).Methods("GET")
+	r.Handle("/user/login", validateRequest(http.HandlerFunc(adminLogin))).Methods("POST")
+	r.HandleFunc("/healthz", getHealthz).Methods("GET")
+	r.HandleFunc("/readyz", getReadyz).Methods("GET")
+	r.HandleFunc("/version", getVersion).Methods("GET")
+	// Kept for existing probes, now only reports liveness
+	r.HandleFunc("/health-check", getHealthz).Methods("GET")
+
+	// API documentation
+	r.HandleFunc("/openapi.json", getOpenAPISpec).Methods("GET")
+	r.HandleFunc("/docs", getAPIDocs).Methods("GET")
+	r.PathPrefix("/docs/").Handler(apiDocsAssets())
 
 	// Protected routes (authentication required)
 	protected := r.PathPrefix("/").Subrouter()
-	protected.Use(authMiddleware)
+	protected.Use(authMiddleware, validateRequest)
 
 	// Admin routes
 	protected.HandleFunc("/user/register", registerAdmin).Methods("POST")
@@ -88,40 +131,168 @@ func main() {
 	protected.HandleFunc("/users/{id}", updateAdmin).Methods("PUT")
 	protected.HandleFunc("/users/{id}", deleteAdmin).Methods("DELETE")
 
+	// Session routes
+	protected.HandleFunc("/user/me/sessions", getMySessions).Methods("GET")
+	protected.HandleFunc("/user/me/sessions/{id}", deleteMySession).Methods("DELETE")
+	protected.HandleFunc("/users/{id}/sessions", getAdminSessions).Methods("GET")
+	protected.HandleFunc("/users/{id}/sessions", deleteAllAdminSessions).Methods("DELETE")
+	protected.HandleFunc("/users/{id}/sessions/{sessionId}", deleteAdminSession).Methods("DELETE")
+
+	// API key routes
+	protected.HandleFunc("/api-keys", getAPIKeys).Methods("GET")
+	protected.HandleFunc("/api-keys", createAPIKey).Methods("POST")
+	protected.HandleFunc("/api-keys/{id}", revokeAPIKey).Methods("DELETE")
+
+	// Branch routes
+	protected.HandleFunc("/branches", getBranches).Methods("GET")
+	protected.HandleFunc("/branches", createBranch).Methods("POST")
+	protected.HandleFunc("/branches/{id}", updateBranch).Methods("PUT")
+
+	// Eligibility rule routes
+	protected.HandleFunc("/eligibility-rules", getEligibilityRules).Methods("GET")
+	protected.HandleFunc("/eligibility-rules", updateEligibilityRules).Methods("PUT")
+
+	// Penalty rule routes
+	protected.HandleFunc("/penalty-rules", getPenaltyRules).Methods("GET")
+	protected.HandleFunc("/penalty-rules", updatePenaltyRules).Methods("PUT")
+	protected.HandleFunc("/settlement-policy", getSettlementPolicy).Methods("GET")
+	protected.HandleFunc("/settlement-policy", updateSettlementPolicy).Methods("PUT")
+
+	// Referral reward routes
+	protected.HandleFunc("/referral-rewards", getRewardBalances).Methods("GET")
+	protected.HandleFunc("/referral-rewards/rules", getRewardRules).Methods("GET")
+	protected.HandleFunc("/referral-rewards/rules", createRewardRule).Methods("POST")
+	protected.HandleFunc("/referral-rewards/rules/{id}", updateRewardRule).Methods("PUT")
+
 	// Customer routes (renamed from users for clarity)
 	protected.HandleFunc("/customers", getAllCustomers).Methods("GET")
 	protected.HandleFunc("/customers", createCustomer).Methods("POST")
+	protected.HandleFunc("/customers/kyc-missing", getMissingKYC).Methods("GET")
+	protected.HandleFunc("/customers/duplicates", getDuplicateCustomers).Methods("GET")
 	protected.HandleFunc("/customers/{id}", getCustomer).Methods("GET")
 	protected.HandleFunc("/customers/{id}/handouts", getCustomerHandouts).Methods("GET")
 	protected.HandleFunc("/customers/{id}/referred-by", getReferredByCustomer).Methods("GET")
 	protected.HandleFunc("/customers/{id}", updateCustomer).Methods("PUT")
 	protected.HandleFunc("/customers/{id}", deleteCustomer).Methods("DELETE")
 	protected.HandleFunc("/customers/{id}/referral", linkCustomerReferral).Methods("POST")
+	protected.HandleFunc("/customers/{id}/referral", unlinkCustomerReferral).Methods("DELETE")
+	protected.HandleFunc("/customers/{id}/referral-history", getCustomerReferralHistory).Methods("GET")
+	protected.HandleFunc("/customers/{id}/referrals", getCustomerReferrals).Methods("GET")
+	protected.HandleFunc("/customers/{id}/referral-rewards", getCustomerRewards).Methods("GET")
+	protected.HandleFunc("/customers/{id}/referral-rewards/payouts", createRewardPayout).Methods("POST")
+	protected.HandleFunc("/customers/{id}/transfer", transferCustomer).Methods("POST")
+	protected.HandleFunc("/customers/{id}/transfers", getCustomerTransfers).Methods("GET")
+	protected.HandleFunc("/customers/{id}/merge", mergeCustomer).Methods("POST")
+	protected.HandleFunc("/customers/{id}/merges", getCustomerMerges).Methods("GET")
+	protected.HandleFunc("/customers/{id}/eligibility", getCustomerEligibility).Methods("GET")
+	protected.HandleFunc("/customers/{id}/credit-limit", setCustomerCreditLimit).Methods("PUT")
+	protected.HandleFunc("/customers/{id}/risk-score", getCustomerRisk).Methods("GET")
+	protected.HandleFunc("/customers/{id}/statement", getCustomerStatement).Methods("GET")
+	protected.HandleFunc("/customers/{id}/documents", getCustomerDocuments).Methods("GET")
+	protected.HandleFunc("/customers/{id}/documents", uploadCustomerDocument).Methods("POST")
+	protected.HandleFunc("/customers/{id}/documents/{documentId}", downloadCustomerDocument).Methods("GET")
+	protected.HandleFunc("/customers/{id}/documents/{documentId}", deleteCustomerDocument).Methods("DELETE")
+	protected.HandleFunc("/customers/{id}/guarantees", getCustomerGuarantees).Methods("GET")
 
 	// Handout routes
 	protected.HandleFunc("/handouts", getHandouts).Methods("GET")
 	protected.HandleFunc("/handouts", createHandout).Methods("POST")
+	protected.HandleFunc("/handouts/loan-to-value", getLoanToValueReport).Methods("GET")
+	protected.HandleFunc("/handouts/write-offs", getWriteOffReport).Methods("GET")
 	protected.HandleFunc("/handouts/{id}", getHandout).Methods("GET")
 	protected.HandleFunc("/handouts/{id}/collections", getHandoutCollections).Methods("GET")
 	protected.HandleFunc("/handouts/{id}", putHandout).Methods("PUT")
 	protected.HandleFunc("/handouts/{id}", deleteHandout).Methods("DELETE")
+	protected.HandleFunc("/handouts/{id}/guarantors", getHandoutGuarantors).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/guarantors", addHandoutGuarantor).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/guarantors/{partyId}", deleteHandoutGuarantor).Methods("DELETE")
+	protected.HandleFunc("/handouts/{id}/nominees", getHandoutNominees).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/nominees", addHandoutNominee).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/nominees/{partyId}", deleteHandoutNominee).Methods("DELETE")
+	protected.HandleFunc("/handouts/{id}/collaterals", getHandoutCollaterals).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/collaterals", addHandoutCollateral).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/collaterals/{collateralId}", putHandoutCollateral).Methods("PUT")
+	protected.HandleFunc("/handouts/{id}/collaterals/{collateralId}", deleteHandoutCollateral).Methods("DELETE")
+	protected.HandleFunc("/handouts/{id}/collaterals/{collateralId}/release", releaseHandoutCollateral).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/referral-rewards/clawback", clawBackHandoutRewards).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/schedule", getHandoutSchedule).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/schedule", setHandoutSchedule).Methods("PUT")
+	protected.HandleFunc("/handouts/{id}/balance", getHandoutBalance).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/penalties", getHandoutPenalties).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/penalties/waivers", waiveHandoutPenalties).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/restructure", restructureHandout).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/restructures", getHandoutRestructures).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/write-off", getHandoutWriteOff).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/write-off", writeOffHandout).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/settlement-quote", getSettlementQuote).Methods("GET")
+	protected.HandleFunc("/handouts/{id}/settle", settleHandout).Methods("POST")
+	protected.HandleFunc("/handouts/{id}/settlement", getHandoutSettlement).Methods("GET")
+	protected.HandleFunc("/guarantees", getGuaranteesByMobile).Methods("GET")
 
 	// Collection routes
 	protected.HandleFunc("/collections", getCollections).Methods("GET")
 	protected.HandleFunc("/collections", createCollection).Methods("POST")
 	protected.HandleFunc("/collections/{id}", putCollection).Methods("PUT")
 	protected.HandleFunc("/collections/{id}", deleteCollection).Methods("DELETE")
+}
 
-	allowedOrigins := handlers.AllowedOrigins([]string{"http://localhost:5173", "https://yogesh-k64.github.io"})
+func main() {
+	configPath := flag.String("config", "", "path to a YAML config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
+
+	if flag.Arg(0) == "config" {
+		runConfigCommand(*configPath, flag.Args()[1:])
+		return
+	}
+
+	loadConfig(*configPath)
+
+	if err := loadOpenAPI(); err != nil {
+		log.Fatalf("Invalid OpenAPI spec: %v", err)
+	}
+
+	shutdownTracing, err := initTracing(appConfig.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to initialise tracing: %v", err)
+	}
+
+	initDb(appConfig.Database)
+
+	// Nightly penalty accrual, then rescoring of customers. Collections
+	// rescore their customer as they happen.
+	startPenaltyAccrual()
+	startRiskScoreRefresh()
+
+	if err := initStorage(appConfig.Storage); err != nil {
+		log.Fatalf("Failed to initialise document storage: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+
+	// commenting this out to use custom CORS settings below
+	// r.Use(mux.CORSMethodMiddleware(r))
+
+	// Prometheus metrics, protected by METRICS_TOKEN or served on METRICS_ADDR
+	setupMetrics(appConfig.Metrics, r)
+
+	registerRoutes(r)
+
+	allowedOrigins := handlers.AllowedOrigins(appConfig.CORS.AllowedOrigins)
 	allowedMethods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	allowedHeaders := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	allowedHeaders := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", requestIDHeader})
+	exposedHeaders := handlers.ExposedHeaders([]string{requestIDHeader})
 	allowCredentials := handlers.AllowCredentials()
 
-	corsHandler := handlers.CORS(allowedOrigins, allowedMethods, allowedHeaders, allowCredentials)(r)
-	log.Printf("service started on:%s\n", port)
-	if err := http.ListenAndServe(":"+port, corsHandler); err != nil {
+	corsHandler := handlers.CORS(allowedOrigins, allowedMethods, allowedHeaders, exposedHeaders, allowCredentials)(r)
 
-		log.Fatalf("failed to start server on :%s", port)
-	}
+	// Blocks until SIGTERM, then drains requests and closes the database
+	runServer(appConfig.Server, requestLogger(securityHeaders(appConfig.Server, corsHandler)))
 
+	// Flush buffered spans after the last request has finished
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 }
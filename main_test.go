@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
@@ -95,8 +96,8 @@ func randomInfo() string {
 	return infos[rand.Intn(len(infos))]
 }
 
-func randomAmount(min, max float64) float64 {
-	return min + rand.Float64()*(max-min)
+func randomAmount(min, max float64) Money {
+	return moneyFromFloat(min + rand.Float64()*(max-min))
 }
 
 func randomDate(daysBack int) time.Time {
@@ -111,10 +112,19 @@ func init() {
 	}
 }
 
+// requireDb skips seeding tests when no database is configured
+func requireDb(t *testing.T) {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set, skipping database test")
+	}
+}
+
 // TestCreateUsers - Test case 1: Create 50 users
 func TestCreateUsers(t *testing.T) {
 	// Initialize database connection
-	initDb()
+	requireDb(t)
+	loadConfig("")
+	initDb(appConfig.Database)
 	defer db.Close()
 
 	// Seed random number generator
@@ -143,7 +153,7 @@ func TestCreateUsers(t *testing.T) {
 		}
 
 		// Create request
-		req, err := http.NewRequest("POST", "/users", bytes.NewBuffer(jsonData))
+		req, err := http.NewRequest("POST", "/customers", bytes.NewBuffer(jsonData))
 		if err != nil {
 			t.Logf("Failed to create request for user %d: %v", i, err)
 			failCount++
@@ -155,7 +165,7 @@ func TestCreateUsers(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// Call handler
-		handler := http.HandlerFunc(createUser)
+		handler := http.HandlerFunc(createCustomer)
 		handler.ServeHTTP(rr, req)
 
 		// Check status code
@@ -179,14 +189,16 @@ func TestCreateUsers(t *testing.T) {
 // TestCreateHandouts - Test case 2: Get users from DB and create handouts
 func TestCreateHandouts(t *testing.T) {
 	// Initialize database connection
-	initDb()
+	requireDb(t)
+	loadConfig("")
+	initDb(appConfig.Database)
 	defer db.Close()
 
 	// Seed random number generator
 	rand.Seed(time.Now().UnixNano())
 
 	// Get actual user IDs from database
-	rows, err := db.Query("SELECT id FROM users ORDER BY id")
+	rows, err := db.Query("SELECT id FROM customers ORDER BY id")
 	if err != nil {
 		t.Fatalf("Failed to query users: %v", err)
 	}
@@ -217,9 +229,9 @@ func TestCreateHandouts(t *testing.T) {
 		userId := userIds[rand.Intn(len(userIds))]
 
 		handout := HandoutUpdate{
-			Date:   randomDate(365), // Random date within last year
-			Amount: randomAmount(1000.00, 50000.00),
-			UserId: userId,
+			Date:       randomDate(365), // Random date within last year
+			Amount:     randomAmount(1000.00, 50000.00),
+			CustomerId: userId,
 		}
 
 		// Marshal handout to JSON
@@ -254,7 +266,7 @@ func TestCreateHandouts(t *testing.T) {
 			}
 		} else {
 			failCount++
-			t.Logf("Failed to create handout %d for UserId %d: Response: %s", i, handout.UserId, rr.Body.String())
+			t.Logf("Failed to create handout %d for UserId %d: Response: %s", i, handout.CustomerId, rr.Body.String())
 		}
 	}
 
@@ -267,7 +279,9 @@ func TestCreateHandouts(t *testing.T) {
 // TestCreateCollections - Test case 3: Get handouts from DB and create collections
 func TestCreateCollections(t *testing.T) {
 	// Initialize database connection
-	initDb()
+	requireDb(t)
+	loadConfig("")
+	initDb(appConfig.Database)
 	defer db.Close()
 
 	// Seed random number generator
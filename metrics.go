@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+const metricsNamespace = "finance"
+
+// Business gauges hit the database on every scrape, keep them bounded
+const businessMetricsTimeout = 3 * time.Second
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, mux route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and mux route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	adminLoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admin_logins_total",
+		Help:      "Admin login attempts by result (success or failure).",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		adminLoginsTotal,
+		businessCollector{},
+	)
+}
+
+// observeHTTPRequest is called by requestLogger once a response is complete
+func observeHTTPRequest(method, route string, status int, elapsed time.Duration) {
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+func recordLogin(success bool) {
+	if success {
+		adminLoginsTotal.WithLabelValues("success").Inc()
+		return
+	}
+	adminLoginsTotal.WithLabelValues("failure").Inc()
+}
+
+var (
+	activeHandoutsDesc = prometheus.NewDesc(metricsNamespace+"_active_handouts",
+		"Number of handouts with status ACTIVE.", nil, nil)
+	outstandingPortfolioDesc = prometheus.NewDesc(metricsNamespace+"_outstanding_portfolio_amount",
+		"Disbursed amount of ACTIVE handouts not yet collected.", nil, nil)
+	collectionsTodayDesc = prometheus.NewDesc(metricsNamespace+"_collections_today",
+		"Number of collections, recoveries excluded, recorded since midnight (database time).", nil, nil)
+	collectionsTodayAmountDesc = prometheus.NewDesc(metricsNamespace+"_collections_today_amount",
+		"Total amount of collections, recoveries excluded, recorded since midnight (database time).", nil, nil)
+	writtenOffHandoutsDesc = prometheus.NewDesc(metricsNamespace+"_written_off_handouts",
+		"Number of handouts written off.", nil, nil)
+	writtenOffAmountDesc = prometheus.NewDesc(metricsNamespace+"_written_off_amount",
+		"Amount due on handouts when they were written off.", nil, nil)
+	recoveredAmountDesc = prometheus.NewDesc(metricsNamespace+"_recovered_amount",
+		"Amount collected on handouts after they were written off.", nil, nil)
+)
+
+// businessCollector reads portfolio KPIs from the database at scrape time
+type businessCollector struct{}
+
+func (businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeHandoutsDesc
+	ch <- outstandingPortfolioDesc
+	ch <- collectionsTodayDesc
+	ch <- collectionsTodayAmountDesc
+	ch <- writtenOffHandoutsDesc
+	ch <- writtenOffAmountDesc
+	ch <- recoveredAmountDesc
+}
+
+func (businessCollector) Collect(ch chan<- prometheus.Metric) {
+	if db == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), businessMetricsTimeout)
+	defer cancel()
+
+	var activeHandouts, collectionsToday, writtenOffHandouts int64
+	var outstanding, collectedToday, writtenOff, recovered Money
+
+	err := db.QueryRowContext(ctx, GET_PORTFOLIO_METRICS).Scan(&activeHandouts, &outstanding)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(activeHandoutsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(activeHandoutsDesc, prometheus.GaugeValue, float64(activeHandouts))
+	ch <- prometheus.MustNewConstMetric(outstandingPortfolioDesc, prometheus.GaugeValue, outstanding.Float64())
+
+	err = db.QueryRowContext(ctx, GET_COLLECTIONS_TODAY_METRICS).Scan(&collectionsToday, &collectedToday)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(collectionsTodayDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(collectionsTodayDesc, prometheus.GaugeValue, float64(collectionsToday))
+	ch <- prometheus.MustNewConstMetric(collectionsTodayAmountDesc, prometheus.GaugeValue, collectedToday.Float64())
+
+	err = db.QueryRowContext(ctx, GET_WRITE_OFF_METRICS).Scan(&writtenOffHandouts, &writtenOff, &recovered)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(writtenOffHandoutsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(writtenOffHandoutsDesc, prometheus.GaugeValue, float64(writtenOffHandouts))
+	ch <- prometheus.MustNewConstMetric(writtenOffAmountDesc, prometheus.GaugeValue, writtenOff.Float64())
+	ch <- prometheus.MustNewConstMetric(recoveredAmountDesc, prometheus.GaugeValue, recovered.Float64())
+}
+
+// registerDBMetrics exports database/sql pool statistics from db.Stats()
+func registerDBMetrics() {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db.DB, "finance"))
+}
+
+// metricsHandler serves the registry, requiring "Authorization: Bearer <token>" when token is set
+func metricsHandler(token string) http.Handler {
+	handler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			sendErrorResponse(w, "Invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// setupMetrics exposes /metrics either on its own listener (METRICS_ADDR) or on
+// the main router behind METRICS_TOKEN. Without either it stays disabled.
+func setupMetrics(cfg config.MetricsConfig, r *mux.Router) {
+	registerDBMetrics()
+
+	if cfg.Addr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metricsHandler(cfg.Token))
+		srv := &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+
+		startBackgroundJob("metrics server", func(ctx context.Context) {
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			log.Printf("metrics served on %s/metrics", cfg.Addr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("metrics server stopped: %v", err)
+			}
+		})
+		return
+	}
+
+	if cfg.Token == "" {
+		log.Println("metrics disabled, set METRICS_TOKEN or METRICS_ADDR to enable /metrics")
+		return
+	}
+
+	r.Handle("/metrics", metricsHandler(cfg.Token)).Methods("GET")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in paise (1/100 rupee), matching the DECIMAL(15,2)
+// columns. All arithmetic stays in integer paise so sums never drift.
+//
+// Rounding rules:
+//   - input with more than two decimal places is rejected, never rounded
+//   - computations that produce fractions of a paisa (interest, shares,
+//     penalties) go through MulRatio, which rounds half away from zero
+//   - float64 is only used at the edges (Prometheus gauges)
+//
+// In JSON it is a number with exactly two decimals, e.g. 1500.50. Input must
+// be a number too, as api/openapi.json declares every amount.
+type Money int64
+
+const paisePerRupee = 100
+
+var moneyType = reflect.TypeOf(Money(0))
+
+var errMoneyFormat = errors.New("amount must be a decimal number with at most two decimal places")
+
+// parseMoney converts a decimal string like "-12.5" or "1500.50" into paise
+func parseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") || len(fraction) > 2 {
+		return 0, errMoneyFormat
+	}
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return 0, errMoneyFormat
+		}
+	}
+
+	rupees, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || rupees > math.MaxInt64/paisePerRupee-1 {
+		return 0, errMoneyFormat
+	}
+	paise := int64(0)
+	if fraction != "" {
+		paise, _ = strconv.ParseInt(fraction+strings.Repeat("0", 2-len(fraction)), 10, 64)
+	}
+
+	amount := Money(rupees*paisePerRupee + paise)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// moneyFromFloat rounds a float to the nearest paisa, half away from zero.
+// Only for values that are already floats, never for request input.
+func moneyFromFloat(f float64) Money {
+	return Money(math.Round(f * paisePerRupee))
+}
+
+// String formats the amount with exactly two decimals, e.g. "-0.05"
+func (m Money) String() string {
+	sign := ""
+	paise := int64(m)
+	if paise < 0 {
+		sign = "-"
+		paise = -paise
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, paise/paisePerRupee, paise%paisePerRupee)
+}
+
+// Float64 is for reporting only, do not compute with the result
+func (m Money) Float64() float64 {
+	return float64(m) / paisePerRupee
+}
+
+// MulRatio returns m * numerator / denominator rounded half away from zero,
+// e.g. m.MulRatio(12, 100) is 12% of m
+func (m Money) MulRatio(numerator, denominator int64) Money {
+	product := int64(m) * numerator
+	quotient, remainder := product/denominator, product%denominator
+	if remainder < 0 {
+		remainder = -remainder
+	}
+	if remainder*2 >= abs64(denominator) {
+		if (product < 0) != (denominator < 0) {
+			quotient--
+		} else {
+			quotient++
+		}
+	}
+	return Money(quotient)
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	raw := string(data)
+	if raw == "null" {
+		return nil
+	}
+
+	amount, err := parseMoney(raw)
+	if err != nil {
+		// Reported by the decoder together with the field name
+		return &json.UnmarshalTypeError{Value: "number " + raw, Type: moneyType}
+	}
+	*m = amount
+	return nil
+}
+
+// Scan reads DECIMAL columns, which lib/pq returns as text
+func (m *Money) Scan(src any) error {
+	switch value := src.(type) {
+	case []byte:
+		return m.scanText(string(value))
+	case string:
+		return m.scanText(value)
+	case int64:
+		*m = Money(value * paisePerRupee)
+	case float64:
+		*m = moneyFromFloat(value)
+	case nil:
+		*m = 0
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m *Money) scanText(text string) error {
+	// SUM() and arithmetic on DECIMAL(15,2) can come back with a longer scale
+	whole, fraction, _ := strings.Cut(text, ".")
+	if len(fraction) > 2 && strings.Trim(fraction[2:], "0") == "" {
+		text = whole + "." + fraction[:2]
+	}
+	amount, err := parseMoney(text)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %w", text, err)
+	}
+	*m = amount
+	return nil
+}
+
+// Value stores the amount as an exact decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
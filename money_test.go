@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{
+		"0":        0,
+		"1500":     150000,
+		"1500.5":   150050,
+		"1500.50":  150050,
+		"0.01":     1,
+		"-12.34":   -1234,
+		"00012.30": 1230,
+	}
+	for input, want := range valid {
+		got, err := parseMoney(input)
+		if err != nil || got != want {
+			t.Errorf("parseMoney(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"0.001", "1.", ".5", "1e3", "12,50", "", "-", "abc", "1.2.3", "99999999999999999999"} {
+		if _, err := parseMoney(input); err == nil {
+			t.Errorf("parseMoney(%q) should fail", input)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var body struct {
+		Amount Money `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 0.1}`), &body); err != nil || body.Amount != 10 {
+		t.Fatalf("got %d, %v", body.Amount, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 250.75}`), &body); err != nil || body.Amount != 25075 {
+		t.Fatalf("got %d, %v", body.Amount, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount": "1.00"}`), &body); err == nil {
+		t.Fatal("amounts given as strings should be rejected")
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 0.001}`), &body); err == nil {
+		t.Fatal("more than two decimals should be rejected")
+	}
+
+	out, _ := json.Marshal(body)
+	if string(out) != `{"amount":250.75}` {
+		t.Errorf("got %s", out)
+	}
+	if Money(-5).String() != "-0.05" || Money(100).String() != "1.00" {
+		t.Errorf("unexpected formatting %s %s", Money(-5), Money(100))
+	}
+}
+
+func TestMoneyMulRatio(t *testing.T) {
+	tests := []struct {
+		amount      Money
+		numerator   int64
+		denominator int64
+		want        Money
+	}{
+		{10000, 12, 100, 1200},
+		{1005, 1, 2, 503}, // 5.025 rounds half up
+		{-1005, 1, 2, -503},
+		{1, 1, 3, 0},
+		{2, 1, 3, 1},
+		{100, 1, 3, 33},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.MulRatio(tt.numerator, tt.denominator); got != tt.want {
+			t.Errorf("%s * %d/%d = %s, want %s", tt.amount, tt.numerator, tt.denominator, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	if err := m.Scan([]byte("1234.50")); err != nil || m != 123450 {
+		t.Errorf("got %d, %v", m, err)
+	}
+	// SUM(amount) can be returned with a longer scale
+	if err := m.Scan([]byte("99.1000")); err != nil || m != 9910 {
+		t.Errorf("got %d, %v", m, err)
+	}
+}
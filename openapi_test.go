@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	if err := loadOpenAPI(); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+
+	r := mux.NewRouter()
+	registerRoutes(r)
+
+	registered := map[string]bool{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+template] = true
+			pathItem := openAPIDoc.Paths.Value(template)
+			if pathItem == nil || pathItem.GetOperation(method) == nil {
+				t.Errorf("%s %s is registered but missing from api/openapi.json", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, pathItem := range openAPIDoc.Paths.Map() {
+		// Only registered on the main router when METRICS_TOKEN is set
+		if path == "/metrics" {
+			continue
+		}
+		for method := range pathItem.Operations() {
+			if !registered[method+" "+path] {
+				t.Errorf("%s %s is documented in api/openapi.json but not registered", method, path)
+			}
+		}
+	}
+}
+
+func TestAPIDocsAssets(t *testing.T) {
+	r := mux.NewRouter()
+	registerRoutes(r)
+
+	// Everything the docs page loads is bundled, nothing comes from a CDN
+	assets := regexp.MustCompile(`(?:href|src)="([^"]+)"`).FindAllStringSubmatch(string(openAPIDocsPage), -1)
+	if len(assets) == 0 {
+		t.Fatal("docs page loads no assets")
+	}
+	for _, asset := range assets {
+		path := asset[1]
+		if !strings.HasPrefix(path, "/docs/") {
+			t.Errorf("%s is not served by the app", path)
+			continue
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+			t.Errorf("GET %s: got status %d with %d bytes", path, rec.Code, rec.Body.Len())
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	if err := loadOpenAPI(); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(validateRequest)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/handouts", ok).Methods("POST")
+	r.HandleFunc("/customers/{id}", ok).Methods("GET")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"valid handout", "POST", "/handouts", `{"customerId": 1, "amount": 500, "date": "2025-01-02T00:00:00Z"}`, http.StatusOK},
+		{"missing customer", "POST", "/handouts", `{"amount": 500, "date": "2025-01-02T00:00:00Z"}`, http.StatusBadRequest},
+		{"negative amount", "POST", "/handouts", `{"customerId": 1, "amount": -5, "date": "2025-01-02T00:00:00Z"}`, http.StatusBadRequest},
+		{"bad date", "POST", "/handouts", `{"customerId": 1, "amount": 500, "date": "yesterday"}`, http.StatusBadRequest},
+		{"unknown status", "POST", "/handouts", `{"customerId": 1, "amount": 500, "date": "2025-01-02T00:00:00Z", "status": "LOST"}`, http.StatusBadRequest},
+		{"non numeric id", "GET", "/customers/abc", "", http.StatusBadRequest},
+		{"numeric id", "GET", "/customers/12", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateMultipartUpload(t *testing.T) {
+	if err := loadOpenAPI(); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(validateRequest)
+	r.HandleFunc("/customers/{id}/documents", func(w http.ResponseWriter, r *http.Request) {
+		// The handler must still be able to read the form after validation
+		if _, _, err := r.FormFile("file"); err != nil {
+			t.Errorf("file not readable after validation: %v", err)
+		}
+	}).Methods("POST")
+
+	upload := func(fields map[string]string, withFile bool) *http.Request {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+		if withFile {
+			part, _ := form.CreateFormFile("file", "id.pdf")
+			part.Write([]byte("%PDF-1.4 test"))
+		}
+		form.Close()
+		req := httptest.NewRequest("POST", "/customers/1/documents", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return req
+	}
+
+	jsonBody := httptest.NewRequest("POST", "/customers/1/documents", strings.NewReader(`{"type": "PHOTO"}`))
+	jsonBody.Header.Set("Content-Type", "application/json")
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", upload(map[string]string{"type": "ID_PROOF", "expiresOn": "2030-12-31"}, true), http.StatusOK},
+		{"unknown type", upload(map[string]string{"type": "PASSPORT"}, true), http.StatusBadRequest},
+		{"missing file", upload(map[string]string{"type": "PHOTO"}, false), http.StatusBadRequest},
+		{"bad expiry", upload(map[string]string{"type": "PHOTO", "expiresOn": "31/12/2030"}, true), http.StatusBadRequest},
+		{"json body", jsonBody, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, tt.req)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Penalty kinds, mirroring the penalty_kind enum in sql/migration-19.sql
+const (
+	PENALTY_FLAT  = "FLAT"  // once per missed installment
+	PENALTY_DAILY = "DAILY" // every further day it stays unpaid
+)
+
+// PenaltyRules say what a late installment costs. It is missed when still
+// unpaid GraceDays after its due date, which charges FlatFee once, and then
+// DailyPercent of its unpaid part for every further day. Nil caps are no cap.
+type PenaltyRules struct {
+	GraceDays         int       `json:"graceDays"`
+	FlatFee           Money     `json:"flatFee"`
+	DailyPercent      float64   `json:"dailyPercent"`
+	MaxPerInstallment *Money    `json:"maxPerInstallment"`
+	MaxPerHandout     *Money    `json:"maxPerHandout"`
+	UpdatedBy         int       `json:"updatedBy"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+type UpdatePenaltyRulesRequest struct {
+	GraceDays         int     `json:"graceDays"`
+	FlatFee           Money   `json:"flatFee"`
+	DailyPercent      float64 `json:"dailyPercent"`
+	MaxPerInstallment *Money  `json:"maxPerInstallment"`
+	MaxPerHandout     *Money  `json:"maxPerHandout"`
+}
+
+// Penalty is a charge accrued on an installment of a handout's schedule
+type Penalty struct {
+	ID          int       `json:"id"`
+	HandoutID   int       `json:"handoutId"`
+	ScheduleID  int       `json:"scheduleId"`
+	Installment int       `json:"installment"`
+	Kind        string    `json:"kind"`
+	AccruedOn   time.Time `json:"accruedOn"`
+	Amount      Money     `json:"amount"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type PenaltyWaiver struct {
+	ID         int       `json:"id"`
+	HandoutID  int       `json:"handoutId"`
+	Amount     Money     `json:"amount"`
+	Reason     string    `json:"reason"`
+	ApprovedBy int       `json:"approvedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type WaivePenaltyRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// HandoutPenalties lists what was charged and waived on a handout
+type HandoutPenalties struct {
+	HandoutBalance
+	Penalties []Penalty       `json:"penalties"`
+	Waivers   []PenaltyWaiver `json:"waivers"`
+}
+
+// penaltyKey identifies a charge, accrual never charges the same one twice
+type penaltyKey struct {
+	installment int
+	kind        string
+	day         time.Time
+}
+
+// percentBasisPoints converts a percent with at most two decimals between 0
+// and 100 to basis points. ok is false for anything else.
+func percentBasisPoints(percent float64) (int64, bool) {
+	basisPoints := math.Round(percent * 100)
+	if math.Abs(percent*100-basisPoints) > 1e-6 || basisPoints < 0 || basisPoints > 10000 {
+		return 0, false
+	}
+	return int64(basisPoints), true
+}
+
+// computePenalties returns the penalties the schedule has run up to the end
+// of through that are not among accrued yet. A day counts as unpaid when the
+// collections paying the schedule up to and including it do not cover the
+// installment. Caps take the accrued penalties into account, those of earlier
+// versions of the schedule only towards the handout's.
+func computePenalties(schedule RepaymentSchedule, collections []Collection, rules PenaltyRules, accrued []Penalty, through time.Time) []Penalty {
+	dailyBasisPoints, _ := percentBasisPoints(rules.DailyPercent)
+	if rules.FlatFee <= 0 && dailyBasisPoints == 0 {
+		return nil
+	}
+
+	charged := make(map[penaltyKey]bool, len(accrued))
+	perInstallment := make(map[int]Money)
+	var total Money
+	for _, penalty := range accrued {
+		total += penalty.Amount
+		if penalty.ScheduleID != schedule.ID {
+			continue
+		}
+		charged[penaltyKey{penalty.Installment, penalty.Kind, dayOf(penalty.AccruedOn)}] = true
+		perInstallment[penalty.Installment] += penalty.Amount
+	}
+
+	var penalties []Penalty
+	charge := func(installment int, kind string, day time.Time, amount Money) {
+		if charged[penaltyKey{installment, kind, day}] {
+			return
+		}
+		if rules.MaxPerInstallment != nil {
+			amount = min(amount, *rules.MaxPerInstallment-perInstallment[installment])
+		}
+		if rules.MaxPerHandout != nil {
+			amount = min(amount, *rules.MaxPerHandout-total)
+		}
+		if amount <= 0 {
+			return
+		}
+		penalties = append(penalties, Penalty{HandoutID: schedule.HandoutID, ScheduleID: schedule.ID,
+			Installment: installment, Kind: kind, AccruedOn: day, Amount: amount})
+		perInstallment[installment] += amount
+		total += amount
+	}
+
+	through = dayOf(through)
+	var due Money
+	for _, item := range schedule.plan() {
+		due += item.Amount
+
+		// Collections are oldest first, paid is what came in up to day
+		var paid Money
+		next := 0
+		lateFrom := dayOf(item.DueDate).AddDate(0, 0, rules.GraceDays+1)
+		for day := lateFrom; !day.After(through); day = day.AddDate(0, 0, 1) {
+			for next < len(collections) && !dayOf(collections[next].Date).After(day) {
+				paid += collections[next].Amount
+				next++
+			}
+			unpaid := min(due-paid, item.Amount)
+			if unpaid <= 0 {
+				break
+			}
+			if rules.MaxPerHandout != nil && total >= *rules.MaxPerHandout {
+				return penalties
+			}
+
+			if day.Equal(lateFrom) && rules.FlatFee > 0 {
+				charge(item.Number, PENALTY_FLAT, day, rules.FlatFee)
+			}
+			if dailyBasisPoints > 0 {
+				charge(item.Number, PENALTY_DAILY, day, unpaid.MulRatio(dailyBasisPoints, 10000))
+			}
+		}
+	}
+	return penalties
+}
+
+func scanPenaltyRules(row interface{ Scan(...any) error }) (rules PenaltyRules, err error) {
+	err = row.Scan(&rules.GraceDays, &rules.FlatFee, &rules.DailyPercent, &rules.MaxPerInstallment,
+		&rules.MaxPerHandout, &rules.UpdatedBy, &rules.UpdatedAt)
+	return rules, err
+}
+
+func scanPenaltyWaiver(row interface{ Scan(...any) error }) (waiver PenaltyWaiver, err error) {
+	err = row.Scan(&waiver.ID, &waiver.HandoutID, &waiver.Amount, &waiver.Reason, &waiver.ApprovedBy, &waiver.CreatedAt)
+	return waiver, err
+}
+
+func loadPenalties(tx *tracedTx, handoutID int) ([]Penalty, error) {
+	rows, err := tx.Query(GET_HANDOUT_PENALTIES, handoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	penalties := []Penalty{}
+	for rows.Next() {
+		var penalty Penalty
+		err := rows.Scan(&penalty.ID, &penalty.HandoutID, &penalty.ScheduleID, &penalty.Installment, &penalty.Kind,
+			&penalty.AccruedOn, &penalty.Amount, &penalty.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		penalties = append(penalties, penalty)
+	}
+	return penalties, rows.Err()
+}
+
+// accrueHandoutPenalties charges what a handout's late installments have run
+// up to the end of yesterday, today's collections may still come in. It
+// returns the number of penalties charged.
+func accrueHandoutPenalties(ctx context.Context, handoutID int, rules PenaltyRules) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Locked so a waiver or a second run sees the penalties of this one
+	var customerID int
+	var amount, collected Money
+	var status string
+	if err := tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, 0).Scan(&customerID, &amount, &status, &collected); err != nil {
+		return 0, err
+	}
+	if status != "ACTIVE" && status != "PENDING" {
+		return 0, nil
+	}
+
+	schedule, err := scanSchedule(tx.QueryRow(GET_HANDOUT_SCHEDULE, handoutID, 0, 0))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	collections, err := loadPayments(tx, handoutID)
+	if err != nil {
+		return 0, err
+	}
+	accrued, err := loadPenalties(tx, handoutID)
+	if err != nil {
+		return 0, err
+	}
+
+	collections = versionPayments(collections, schedule.CollectedBefore)
+	penalties := computePenalties(schedule, collections, rules, accrued, today().AddDate(0, 0, -1))
+	for _, penalty := range penalties {
+		_, err := tx.Exec(CREATE_PENALTY, penalty.HandoutID, penalty.ScheduleID, penalty.Installment, penalty.Kind,
+			penalty.AccruedOn, penalty.Amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(penalties), tx.Commit()
+}
+
+// accruePenalties charges the penalties of every owed handout with a schedule
+func accruePenalties(ctx context.Context) (handouts, penalties int, err error) {
+	rules, err := scanPenaltyRules(db.QueryRowContext(ctx, GET_PENALTY_RULES))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, GET_PENALTY_HANDOUTS, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	var handoutIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		handoutIDs = append(handoutIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, id := range handoutIDs {
+		charged, err := accrueHandoutPenalties(ctx, id, rules)
+		if err != nil {
+			return handouts, penalties, err
+		}
+		if charged > 0 {
+			handouts++
+			penalties += charged
+		}
+	}
+	return handouts, penalties, nil
+}
+
+// startPenaltyAccrual charges late installments each night at PENALTY_ACCRUAL_TIME
+func startPenaltyAccrual() {
+	startDailyJob("penalty accrual", appConfig.Penalty.AccrualTime, func(ctx context.Context) {
+		started := time.Now()
+		handouts, penalties, err := accruePenalties(ctx)
+		if err != nil {
+			log.Printf("penalty accrual failed after %d penalties on %d handouts: %v", penalties, handouts, err)
+			return
+		}
+		log.Printf("accrued %d penalties on %d handouts in %s", penalties, handouts, time.Since(started).Round(time.Millisecond))
+	})
+}
+
+func getPenaltyRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := scanPenaltyRules(db.QueryRowContext(r.Context(), GET_PENALTY_RULES))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[PenaltyRules]{
+		D:   rules,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// updatePenaltyRules replaces the rules, which apply to every branch from the
+// next accrual on. Penalties already accrued are kept.
+func updatePenaltyRules(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can manage penalty rules", http.StatusForbidden)
+		return
+	}
+
+	var req UpdatePenaltyRulesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.GraceDays < 0 {
+		fields = append(fields, FieldError{Field: "graceDays", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if req.FlatFee < 0 {
+		fields = append(fields, FieldError{Field: "flatFee", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if _, ok := percentBasisPoints(req.DailyPercent); !ok {
+		fields = append(fields, FieldError{Field: "dailyPercent", Code: INVALID_VALUE, Message: "must be between 0 and 100 with at most two decimals"})
+	}
+	if req.MaxPerInstallment != nil && *req.MaxPerInstallment < 0 {
+		fields = append(fields, FieldError{Field: "maxPerInstallment", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if req.MaxPerHandout != nil && *req.MaxPerHandout < 0 {
+		fields = append(fields, FieldError{Field: "maxPerHandout", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	rules, err := scanPenaltyRules(db.QueryRowContext(r.Context(), UPDATE_PENALTY_RULES,
+		req.GraceDays, req.FlatFee, req.DailyPercent, req.MaxPerInstallment, req.MaxPerHandout, adminID))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[PenaltyRules]{
+		D:   rules,
+		Msg: "Penalty rules updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getHandoutPenalties lists the penalties and waivers of a handout with its balance
+func getHandoutPenalties(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var result HandoutPenalties
+	result.HandoutBalance, err = loadHandoutBalance(tx, handoutID, branchScope(r))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err == nil {
+		result.Penalties, err = loadPenalties(tx, handoutID)
+	}
+	if err == nil {
+		result.Waivers, err = loadPenaltyWaivers(tx, handoutID)
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[HandoutPenalties]{
+		D:   result,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func loadPenaltyWaivers(tx *tracedTx, handoutID int) ([]PenaltyWaiver, error) {
+	rows, err := tx.Query(GET_PENALTY_WAIVERS, handoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	waivers := []PenaltyWaiver{}
+	for rows.Next() {
+		waiver, err := scanPenaltyWaiver(rows)
+		if err != nil {
+			return nil, err
+		}
+		waivers = append(waivers, waiver)
+	}
+	return waivers, rows.Err()
+}
+
+// waiveHandoutPenalties forgives up to the penalties still due on a handout.
+// Only admins can approve a waiver, they are recorded with it.
+func waiveHandoutPenalties(w http.ResponseWriter, r *http.Request) {
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		sendErrorResponse(w, "Only admins can waive penalties", http.StatusForbidden)
+		return
+	}
+
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req WaivePenaltyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.Amount <= 0 {
+		fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"})
+	}
+	if req.Reason == "" {
+		fields = append(fields, FieldError{Field: "reason", Code: REQUIRED, Message: "a reason is required to waive penalties"})
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Waivers of one handout are checked one at a time, and not during accrual
+	var customerID int
+	var amount, collected Money
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, branchScope(r)).Scan(&customerID, &amount, &status, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	balance, err := loadHandoutBalance(tx, handoutID, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if req.Amount > balance.PenaltiesDue {
+		apiErr := newAPIError(http.StatusUnprocessableEntity, WAIVER_EXCEEDS_PENALTIES,
+			"Only "+balance.PenaltiesDue.String()+" of penalties is due on the handout")
+		apiErr.Details = balance
+		sendAPIError(w, apiErr)
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	waiver, err := scanPenaltyWaiver(tx.QueryRow(CREATE_PENALTY_WAIVER, handoutID, req.Amount, req.Reason, adminID))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[PenaltyWaiver]{
+		D:   waiver,
+		Msg: "Penalties waived successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
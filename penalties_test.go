@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestComputePenalties(t *testing.T) {
+	// One installment of 100.00 due on 2026-03-01, collections as given
+	schedule := RepaymentSchedule{ID: 7, HandoutID: 3, Principal: 10000, Installments: 1, Frequency: FREQUENCY_MONTHLY, FirstDueDate: date("2026-03-01")}
+	limit := func(m Money) *Money { return &m }
+
+	tests := []struct {
+		name        string
+		rules       PenaltyRules
+		collections []Collection
+		accrued     []Penalty
+		through     string
+		want        Money
+		wantCount   int
+	}{
+		{"no rules", PenaltyRules{GraceDays: 2}, nil, nil, "2026-03-10", 0, 0},
+		{"within grace", PenaltyRules{GraceDays: 2, FlatFee: 5000}, nil, nil, "2026-03-03", 0, 0},
+		{"flat once", PenaltyRules{GraceDays: 2, FlatFee: 5000}, nil, nil, "2026-03-10", 5000, 1},
+		// 1% of 100.00 for 4 days, 2026-03-04 to 2026-03-07
+		{"daily", PenaltyRules{GraceDays: 2, DailyPercent: 1}, nil, nil, "2026-03-07", 400, 4},
+		// 1% of the unpaid 40.00 after a partial collection on 2026-03-05
+		{"daily on the unpaid part", PenaltyRules{GraceDays: 2, DailyPercent: 1},
+			[]Collection{{Date: date("2026-03-05"), Amount: 6000}}, nil, "2026-03-07", 100 + 40 + 40 + 40, 4},
+		{"paid on the last grace day", PenaltyRules{GraceDays: 2, FlatFee: 5000, DailyPercent: 1},
+			[]Collection{{Date: date("2026-03-03"), Amount: 10000}}, nil, "2026-03-10", 0, 0},
+		{"paid late", PenaltyRules{FlatFee: 5000, DailyPercent: 1},
+			[]Collection{{Date: date("2026-03-04"), Amount: 10000}}, nil, "2026-03-10", 5000 + 100 + 100, 3},
+		{"installment cap", PenaltyRules{FlatFee: 5000, DailyPercent: 10, MaxPerInstallment: limit(6500)}, nil, nil, "2026-03-31", 6500, 3},
+		{"handout cap counts accrued", PenaltyRules{DailyPercent: 1, MaxPerHandout: limit(250)}, nil,
+			[]Penalty{{ScheduleID: 7, Installment: 1, Kind: PENALTY_DAILY, AccruedOn: date("2026-03-02"), Amount: 100}}, "2026-03-31", 150, 2},
+		{"accrued days are skipped", PenaltyRules{DailyPercent: 1}, nil,
+			[]Penalty{{ScheduleID: 7, Installment: 1, Kind: PENALTY_DAILY, AccruedOn: date("2026-03-02"), Amount: 100}}, "2026-03-03", 100, 1},
+		// Penalties of the version a restructure replaced
+		{"earlier versions only count towards the handout cap", PenaltyRules{FlatFee: 5000, MaxPerInstallment: limit(5000), MaxPerHandout: limit(8000)}, nil,
+			[]Penalty{{ScheduleID: 6, Installment: 1, Kind: PENALTY_FLAT, AccruedOn: date("2026-03-02"), Amount: 5000}}, "2026-03-03", 3000, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			penalties := computePenalties(schedule, tt.collections, tt.rules, tt.accrued, date(tt.through))
+			var total Money
+			for _, penalty := range penalties {
+				if penalty.HandoutID != 3 || penalty.ScheduleID != 7 || penalty.Installment != 1 {
+					t.Errorf("penalty charged to the wrong installment: %+v", penalty)
+				}
+				total += penalty.Amount
+			}
+			if total != tt.want || len(penalties) != tt.wantCount {
+				t.Errorf("expected %d penalties of %s, got %d of %s: %+v", tt.wantCount, tt.want, len(penalties), total, penalties)
+			}
+		})
+	}
+}
+
+func TestComputePenaltiesInstallmentsInOrder(t *testing.T) {
+	// Two weekly installments of 50.00, one collection covers only the first
+	schedule := RepaymentSchedule{Principal: 10000, Installments: 2, Frequency: FREQUENCY_WEEKLY, FirstDueDate: date("2026-03-01")}
+	collections := []Collection{{Date: date("2026-03-01"), Amount: 5000}}
+	penalties := computePenalties(schedule, collections, PenaltyRules{FlatFee: 1000}, nil, date("2026-03-31"))
+
+	if len(penalties) != 1 || penalties[0].Installment != 2 || !penalties[0].AccruedOn.Equal(date("2026-03-09")) {
+		t.Errorf("expected the flat fee on installment 2 on 2026-03-09, got %+v", penalties)
+	}
+}
@@ -1,44 +1,1238 @@
 package main
 
+// Customer, handout and collection queries are scoped to a branch: the
+// branch parameter is the caller's branch, or 0 for the super admin who sees
+// every branch (see branchScope).
+
 // Customer queries (renamed from user queries for clarity)
-const GET_ALL_CUSTOMERS = "SELECT id, address, created_at, info, mobile, name, COALESCE(referred_by, -1) as referred_by, updated_at FROM customers ORDER BY id DESC"
+// GET_ALL_CUSTOMERS filters on risk score between $2 and $3 when they are not
+// NULL and sorts by risk score when $4 is riskScore or -riskScore
+const GET_ALL_CUSTOMERS = `
+		SELECT c.id, c.address, c.created_at, c.info, c.mobile, c.name, COALESCE(c.referred_by, -1) as referred_by,
+		       c.branch_id, c.updated_at, s.score, s.computed_at
+		FROM customers c
+		LEFT JOIN customer_risk_scores s ON s.customer_id = c.id
+		WHERE ($1 = 0 OR c.branch_id = $1)
+		  AND ($2::int IS NULL OR s.score >= $2) AND ($3::int IS NULL OR s.score <= $3)
+		ORDER BY CASE WHEN $4::text = 'riskScore' THEN s.score END ASC NULLS LAST,
+		         CASE WHEN $4::text = '-riskScore' THEN s.score END DESC NULLS LAST,
+		         c.id DESC
+	`
+
+const CREATE_CUSTOMER = "INSERT INTO customers (address, info, mobile, name, branch_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, address, created_at, info, mobile, name, referred_by, updated_at;"
+
+const GET_CUSTOMER_BY_ID = `
+		SELECT c.id, c.address, c.created_at, c.info, c.mobile, c.name, COALESCE(c.referred_by, -1) as referred_by,
+		       c.branch_id, c.updated_at, s.score, s.computed_at
+		FROM customers c
+		LEFT JOIN customer_risk_scores s ON s.customer_id = c.id
+		WHERE c.id = $1 AND ($2 = 0 OR c.branch_id = $2)
+	`
+
+const UPDATE_CUSTOMER = "UPDATE customers SET address = $1, info = $2, mobile = $3, name = $4 WHERE id = $5 AND ($6 = 0 OR branch_id = $6)"
+
+const DELETE_CUSTOMER = "DELETE FROM customers WHERE id = $1 AND ($2 = 0 OR branch_id = $2)"
 
-const CREATE_CUSTOMER = "INSERT INTO customers (address, info, mobile, name) VALUES ($1, $2, $3, $4) RETURNING id, address, created_at, info, mobile, name, referred_by, updated_at;"
+const CHECK_CUSTOMER_EXISTS = "SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1 AND ($2 = 0 OR branch_id = $2))"
 
-const GET_CUSTOMER_BY_ID = "SELECT id, address, created_at, info, mobile, name, COALESCE(referred_by, -1) as referred_by, updated_at FROM customers WHERE id = $1"
+const GET_CUSTOMER_BRANCH = "SELECT branch_id FROM customers WHERE id = $1 AND ($2 = 0 OR branch_id = $2)"
 
-const UPDATE_CUSTOMER = "UPDATE customers SET address = $1, info = $2, mobile = $3, name = $4 WHERE id = $5"
+const UPDATE_CUSTOMER_REFERRAL = "UPDATE customers SET referred_by = NULLIF($1, 0) WHERE id = $2 AND ($3 = 0 OR branch_id = $3)"
 
-const DELETE_CUSTOMER = "DELETE FROM customers WHERE id = $1"
+// Referral queries. Links are changed one at a time under LOCK_REFERRALS so
+// two concurrent links cannot close a cycle between them.
+const LOCK_REFERRALS = "SELECT pg_advisory_xact_lock(hashtext('customer_referrals'))"
+
+const LOCK_CUSTOMER_REFERRAL = "SELECT COALESCE(referred_by, 0) FROM customers WHERE id = $1 AND ($2 = 0 OR branch_id = $2) FOR UPDATE"
+
+// CHECK_REFERRAL_CYCLE reports whether customer $2 is $1 or one of $1's
+// referrers, in which case $2 cannot be referred by $1. It looks at every
+// branch, a cycle through another branch is still a cycle.
+const CHECK_REFERRAL_CYCLE = `
+		WITH RECURSIVE upline AS (
+			SELECT id, referred_by, 1 AS depth FROM customers WHERE id = $1
+			UNION ALL
+			SELECT c.id, c.referred_by, u.depth + 1
+			FROM customers c
+			JOIN upline u ON c.id = u.referred_by
+			WHERE u.depth < 1000
+		)
+		SELECT EXISTS(SELECT 1 FROM upline WHERE id = $2)
+	`
+
+const CREATE_REFERRAL_CHANGE = `
+		INSERT INTO customer_referral_changes (customer_id, previous_referred_by, referred_by, reason, changed_by)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5)
+	`
+
+const GET_REFERRAL_CHANGES = `
+		SELECT id, customer_id, COALESCE(previous_referred_by, 0), COALESCE(referred_by, 0), reason,
+		       COALESCE(changed_by, 0), created_at
+		FROM customer_referral_changes
+		WHERE customer_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
 
-const CHECK_CUSTOMER_EXISTS = "SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1)"
+// GET_REFERRAL_DOWNLINE walks the customers referred by $1 down to one level
+// past $2, so the caller can tell whether the tree was cut off. Loan volume
+// leaves out CANCELLED handouts.
+const GET_REFERRAL_DOWNLINE = `
+		WITH RECURSIVE downline AS (
+			SELECT id, referred_by, 1 AS depth
+			FROM customers
+			WHERE referred_by = $1 AND ($3 = 0 OR branch_id = $3)
+			UNION ALL
+			SELECT c.id, c.referred_by, d.depth + 1
+			FROM customers c
+			JOIN downline d ON c.referred_by = d.id
+			WHERE d.depth <= $2 AND ($3 = 0 OR c.branch_id = $3)
+		)
+		SELECT c.id, c.name, c.mobile, c.branch_id, d.referred_by, d.depth,
+		       COALESCE(v.handouts, 0), COALESCE(v.volume, 0)
+		FROM downline d
+		JOIN customers c ON c.id = d.id
+		LEFT JOIN (SELECT customer_id, COUNT(*) AS handouts, SUM(amount) AS volume
+		           FROM handouts WHERE status <> 'CANCELLED' GROUP BY customer_id) v
+		       ON v.customer_id = c.id
+		ORDER BY d.depth, c.id
+	`
 
-const UPDATE_CUSTOMER_REFERRAL = "UPDATE customers SET referred_by = $1 WHERE id = $2"
+// GET_REFERRAL_UPLINE lists who referred $1, who referred them and so on,
+// nearest first, for at most $2 levels
+const GET_REFERRAL_UPLINE = `
+		WITH RECURSIVE upline AS (
+			SELECT referred_by AS id, 1 AS depth
+			FROM customers
+			WHERE id = $1 AND referred_by IS NOT NULL
+			UNION ALL
+			SELECT c.referred_by, u.depth + 1
+			FROM customers c
+			JOIN upline u ON c.id = u.id
+			WHERE c.referred_by IS NOT NULL AND u.depth < $2
+		)
+		SELECT c.id, c.name, c.mobile, c.branch_id, COALESCE(c.referred_by, -1), u.depth,
+		       COALESCE(v.handouts, 0), COALESCE(v.volume, 0)
+		FROM upline u
+		JOIN customers c ON c.id = u.id
+		LEFT JOIN (SELECT customer_id, COUNT(*) AS handouts, SUM(amount) AS volume
+		           FROM handouts WHERE status <> 'CANCELLED' GROUP BY customer_id) v
+		       ON v.customer_id = c.id
+		WHERE ($3 = 0 OR c.branch_id = $3)
+		ORDER BY u.depth
+	`
 
 const GET_HANDOUTS_WITH_CUSTOMERS = `
-		SELECT h.id, h.amount, h.date, h.status, h.bond, h.created_at, h.updated_at,
+		SELECT h.id, h.amount, h.date, h.status,
+		       EXISTS (SELECT 1 FROM collaterals k WHERE k.handout_id = h.id AND k.returned_on IS NULL),
+		       h.branch_id, h.created_at, h.updated_at,
 		       c.id, c.name, c.mobile
 		FROM handouts h
 		JOIN customers c ON h.customer_id = c.id
+		WHERE ($1 = 0 OR h.branch_id = $1)
 		ORDER BY h.created_at DESC
 	`
 
-const GET_HANDOUT_BY_ID = `SELECT id, date, amount, status, bond, created_at, updated_at FROM handouts WHERE id = $1`
+// Handout queries report bond as whether any collateral is still held, see sql/migration-13.sql
+const GET_HANDOUT_BY_ID = `
+		SELECT h.id, h.date, h.amount, h.status, EXISTS (SELECT 1 FROM collaterals k WHERE k.handout_id = h.id AND k.returned_on IS NULL), h.branch_id, h.created_at, h.updated_at
+		FROM handouts h WHERE h.id = $1 AND ($2 = 0 OR h.branch_id = $2)
+	`
+
+const GET_HANDOUT_BRANCH = "SELECT branch_id FROM handouts WHERE id = $1 AND ($2 = 0 OR branch_id = $2)"
+
+const GET_CUSTOMER_HANDOUTS = `
+		SELECT h.id, h.date, h.amount, h.status, EXISTS (SELECT 1 FROM collaterals k WHERE k.handout_id = h.id AND k.returned_on IS NULL), h.branch_id, h.created_at, h.updated_at
+		FROM handouts h WHERE h.customer_id = $1 AND ($2 = 0 OR h.branch_id = $2) ORDER BY h.date DESC
+	`
+
+// CREATE_HANDOUTS takes the branch of the customer, looked up with GET_CUSTOMER_BRANCH
+const CREATE_HANDOUTS = "INSERT INTO handouts (date, amount, status, customer_id, branch_id) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+
+const DELETE_HANDOUTS = "DELETE FROM handouts WHERE id = $1 AND ($2 = 0 OR branch_id = $2)"
+
+const UPDATE_HANDOUT = `UPDATE handouts SET date = $1, amount = $2, status = COALESCE($3::order_status, status), customer_id = $4 WHERE id = $5 AND ($6 = 0 OR branch_id = $6)`
+
+const GET_ALL_COLLECTIONS = "SELECT id, date, amount, handout_id, recovery, branch_id, created_at, updated_at FROM collections WHERE ($1 = 0 OR branch_id = $1) ORDER BY id DESC"
+
+const GET_HANDOUT_COLLECTIONS = "SELECT id, date, amount, recovery, branch_id, created_at, updated_at FROM collections WHERE handout_id = $1 AND ($2 = 0 OR branch_id = $2) ORDER BY date DESC"
+
+// CREATE_COLLECTION takes the branch of the handout, looked up with
+// GET_HANDOUT_BRANCH. A collection on a written-off handout is a recovery.
+const CREATE_COLLECTION = `
+		INSERT INTO collections (date, amount, handout_id, branch_id, recovery)
+		VALUES ($1, $2, $3, $4, EXISTS (SELECT 1 FROM handouts WHERE id = $3 AND status = 'WRITTEN_OFF'))
+		RETURNING id
+	`
+
+const DELETE_COLLECTION = "DELETE FROM collections WHERE id = $1 AND ($2 = 0 OR branch_id = $2)"
+
+// UPDATE_COLLECTION moves the collection to the branch of its (possibly new)
+// handout, it is a recovery when that one is written off
+const UPDATE_COLLECTION = `
+		UPDATE collections
+		SET date = $1, amount = $2, handout_id = $3, branch_id = $4,
+		    recovery = EXISTS (SELECT 1 FROM handouts WHERE id = $3 AND status = 'WRITTEN_OFF')
+		WHERE id = $5 AND ($6 = 0 OR branch_id = $6)
+	`
+
+// Branch queries
+const GET_ALL_BRANCHES = "SELECT id, name, code, address, active, created_at, updated_at FROM branches ORDER BY id"
+
+const GET_BRANCH_BY_ID = "SELECT id, name, code, address, active, created_at, updated_at FROM branches WHERE id = $1"
+
+const CREATE_BRANCH = "INSERT INTO branches (name, code, address) VALUES ($1, $2, $3) RETURNING id, name, code, address, active, created_at, updated_at"
+
+const UPDATE_BRANCH = `
+		UPDATE branches SET name = COALESCE($1, name), code = COALESCE($2, code),
+		       address = COALESCE($3, address), active = COALESCE($4, active)
+		WHERE id = $5
+		RETURNING id, name, code, address, active, created_at, updated_at
+	`
+
+// Customer transfer queries, run in one transaction by transferCustomer
+const LOCK_CUSTOMER_BRANCH = "SELECT branch_id FROM customers WHERE id = $1 FOR UPDATE"
+
+const TRANSFER_CUSTOMER = "UPDATE customers SET branch_id = $1 WHERE id = $2"
+
+const TRANSFER_CUSTOMER_HANDOUTS = "UPDATE handouts SET branch_id = $1 WHERE customer_id = $2"
+
+const TRANSFER_CUSTOMER_COLLECTIONS = "UPDATE collections SET branch_id = $1 WHERE handout_id IN (SELECT id FROM handouts WHERE customer_id = $2)"
+
+const CREATE_CUSTOMER_TRANSFER = `
+		INSERT INTO customer_transfers (customer_id, from_branch_id, to_branch_id, handouts_moved, reason, transferred_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+const GET_CUSTOMER_TRANSFERS = `
+		SELECT id, customer_id, from_branch_id, to_branch_id, handouts_moved, reason, COALESCE(transferred_by, 0), created_at
+		FROM customer_transfers
+		WHERE customer_id = $1
+		ORDER BY created_at DESC
+	`
+
+// Customer document queries, scoped through the customer's branch
+const GET_CUSTOMER_DOCUMENTS = `
+		SELECT d.id, d.customer_id, d.document_type, d.file_name, d.content_type, d.size_bytes, d.sha256,
+		       d.expires_on, COALESCE(d.uploaded_by, 0), d.created_at, d.storage_backend, d.storage_key
+		FROM customer_documents d
+		JOIN customers c ON c.id = d.customer_id
+		WHERE d.customer_id = $1 AND ($2 = 0 OR c.branch_id = $2)
+		ORDER BY d.created_at DESC
+	`
+
+const GET_CUSTOMER_DOCUMENT = `
+		SELECT d.id, d.customer_id, d.document_type, d.file_name, d.content_type, d.size_bytes, d.sha256,
+		       d.expires_on, COALESCE(d.uploaded_by, 0), d.created_at, d.storage_backend, d.storage_key
+		FROM customer_documents d
+		JOIN customers c ON c.id = d.customer_id
+		WHERE d.id = $1 AND d.customer_id = $2 AND ($3 = 0 OR c.branch_id = $3)
+	`
+
+const CREATE_CUSTOMER_DOCUMENT = `
+		INSERT INTO customer_documents (customer_id, document_type, file_name, content_type, size_bytes,
+		                                sha256, storage_backend, storage_key, expires_on, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at
+	`
+
+const DELETE_CUSTOMER_DOCUMENT = `
+		DELETE FROM customer_documents d
+		USING customers c
+		WHERE c.id = d.customer_id AND d.id = $1 AND d.customer_id = $2 AND ($3 = 0 OR c.branch_id = $3)
+		RETURNING d.storage_key
+	`
+
+// GET_MISSING_KYC lists customers lacking an unexpired document of any of the
+// required types ($1), with the types that are missing and those only expired
+const GET_MISSING_KYC = `
+		SELECT id, name, mobile, branch_id, missing, expired FROM (
+			SELECT c.id, c.name, c.mobile, c.branch_id,
+			       ARRAY(SELECT t FROM unnest($1::text[]) t
+			             WHERE NOT EXISTS (SELECT 1 FROM customer_documents d
+			                               WHERE d.customer_id = c.id AND d.document_type::text = t
+			                                 AND (d.expires_on IS NULL OR d.expires_on >= CURRENT_DATE))) AS missing,
+			       ARRAY(SELECT t FROM unnest($1::text[]) t
+			             WHERE EXISTS (SELECT 1 FROM customer_documents d
+			                           WHERE d.customer_id = c.id AND d.document_type::text = t)
+			               AND NOT EXISTS (SELECT 1 FROM customer_documents d
+			                               WHERE d.customer_id = c.id AND d.document_type::text = t
+			                                 AND (d.expires_on IS NULL OR d.expires_on >= CURRENT_DATE))) AS expired
+			FROM customers c
+			WHERE ($2 = 0 OR c.branch_id = $2)
+		) kyc
+		WHERE cardinality(missing) > 0
+		ORDER BY id
+	`
+
+// Guarantor and nominee queries, scoped through the handout's branch. Linked
+// customers' current name, mobile and address are used instead of stored ones.
+const LOCK_HANDOUT = "SELECT customer_id FROM handouts WHERE id = $1 AND ($2 = 0 OR branch_id = $2) FOR UPDATE"
+
+const GET_PARTY_SHARE_TOTAL = "SELECT COALESCE(SUM(liability_share), 0) FROM handout_parties WHERE handout_id = $1 AND role = $2"
+
+const CREATE_HANDOUT_PARTY = `
+		INSERT INTO handout_parties (handout_id, role, customer_id, name, mobile, address, relationship, liability_share, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+const GET_HANDOUT_PARTIES = `
+		SELECT p.id, p.handout_id, p.role, p.customer_id, COALESCE(c.name, p.name), COALESCE(c.mobile, p.mobile, 0),
+		       COALESCE(c.address, p.address), p.relationship, p.liability_share, p.created_at, p.updated_at
+		FROM handout_parties p
+		JOIN handouts h ON h.id = p.handout_id
+		LEFT JOIN customers c ON c.id = p.customer_id
+		WHERE p.handout_id = $1 AND p.role = $2 AND ($3 = 0 OR h.branch_id = $3)
+		ORDER BY p.id
+	`
+
+const GET_HANDOUT_PARTY = `
+		SELECT p.id, p.handout_id, p.role, p.customer_id, COALESCE(c.name, p.name), COALESCE(c.mobile, p.mobile, 0),
+		       COALESCE(c.address, p.address), p.relationship, p.liability_share, p.created_at, p.updated_at
+		FROM handout_parties p
+		LEFT JOIN customers c ON c.id = p.customer_id
+		WHERE p.id = $1
+	`
+
+const GET_BRANCH_HANDOUT_PARTIES = `
+		SELECT p.id, p.handout_id, p.role, p.customer_id, COALESCE(c.name, p.name), COALESCE(c.mobile, p.mobile, 0),
+		       COALESCE(c.address, p.address), p.relationship, p.liability_share, p.created_at, p.updated_at
+		FROM handout_parties p
+		JOIN handouts h ON h.id = p.handout_id
+		LEFT JOIN customers c ON c.id = p.customer_id
+		WHERE ($1 = 0 OR h.branch_id = $1)
+		ORDER BY p.handout_id, p.id
+	`
+
+const DELETE_HANDOUT_PARTY = `
+		DELETE FROM handout_parties p
+		USING handouts h
+		WHERE h.id = p.handout_id AND p.id = $1 AND p.handout_id = $2 AND p.role = $3 AND ($4 = 0 OR h.branch_id = $4)
+	`
+
+// GET_GUARANTEES lists the loans guaranteed by a customer ($1) or by anyone
+// with the mobile number $2, with what has been collected on each so far
+const GET_GUARANTEES = `
+		SELECT p.id, p.relationship, p.liability_share,
+		       h.id, h.date, h.amount, h.status, h.branch_id,
+		       b.id, b.name, b.mobile,
+		       COALESCE(paid.total, 0)
+		FROM handout_parties p
+		JOIN handouts h ON h.id = p.handout_id
+		JOIN customers b ON b.id = h.customer_id
+		LEFT JOIN customers g ON g.id = p.customer_id
+		LEFT JOIN (SELECT handout_id, SUM(amount) AS total FROM collections GROUP BY handout_id) paid
+		       ON paid.handout_id = h.id
+		WHERE p.role = 'GUARANTOR' AND (p.customer_id = $1 OR COALESCE(g.mobile, p.mobile) = $2)
+		  AND ($3 = 0 OR h.branch_id = $3)
+		ORDER BY h.date DESC
+	`
+
+// Collateral queries, scoped through the handout's branch
+const GET_HANDOUT_COLLATERALS = `
+		SELECT k.id, k.handout_id, k.collateral_type, k.description, k.declared_value, k.assessed_value,
+		       k.custody_location, k.received_on, COALESCE(k.received_by, 0), k.returned_on, COALESCE(k.returned_by, 0),
+		       k.created_at, k.updated_at
+		FROM collaterals k
+		JOIN handouts h ON h.id = k.handout_id
+		WHERE k.handout_id = $1 AND ($2 = 0 OR h.branch_id = $2)
+		ORDER BY k.received_on, k.id
+	`
+
+const GET_COLLATERAL = `
+		SELECT k.id, k.handout_id, k.collateral_type, k.description, k.declared_value, k.assessed_value,
+		       k.custody_location, k.received_on, COALESCE(k.received_by, 0), k.returned_on, COALESCE(k.returned_by, 0),
+		       k.created_at, k.updated_at
+		FROM collaterals k
+		JOIN handouts h ON h.id = k.handout_id
+		WHERE k.id = $1 AND k.handout_id = $2 AND ($3 = 0 OR h.branch_id = $3)
+	`
+
+const CREATE_COLLATERAL = `
+		INSERT INTO collaterals (handout_id, collateral_type, description, declared_value, assessed_value,
+		                         custody_location, received_on, received_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+
+// UPDATE_COLLATERAL only changes items still held, released ones are kept as returned
+const UPDATE_COLLATERAL = `
+		UPDATE collaterals k SET collateral_type = $1, description = $2, declared_value = $3, assessed_value = $4,
+		       custody_location = $5
+		FROM handouts h
+		WHERE h.id = k.handout_id AND k.id = $6 AND k.handout_id = $7 AND k.returned_on IS NULL
+		  AND ($8 = 0 OR h.branch_id = $8)
+	`
+
+const DELETE_COLLATERAL = `
+		DELETE FROM collaterals k
+		USING handouts h
+		WHERE h.id = k.handout_id AND k.id = $1 AND k.handout_id = $2 AND k.returned_on IS NULL
+		  AND ($3 = 0 OR h.branch_id = $3)
+	`
+
+const LOCK_HANDOUT_STATUS = "SELECT status FROM handouts WHERE id = $1 AND ($2 = 0 OR branch_id = $2) FOR UPDATE"
+
+const RELEASE_COLLATERAL = `
+		UPDATE collaterals SET returned_on = $1, returned_by = $2
+		WHERE id = $3 AND handout_id = $4 AND returned_on IS NULL
+	`
+
+// GET_COLLATERAL_EXPOSURE lists ACTIVE and PENDING handouts ($1 = 0) or one
+// handout ($1), with what is still owed and the value of the collateral held.
+// Assessed values are used where known, declared values otherwise.
+const GET_COLLATERAL_EXPOSURE = `
+		SELECT h.id, h.amount, h.status, h.branch_id, c.id, c.name, c.mobile,
+		       COALESCE(paid.total, 0), COALESCE(held.value, 0), COALESCE(held.items, 0)
+		FROM handouts h
+		JOIN customers c ON c.id = h.customer_id
+		LEFT JOIN (SELECT handout_id, SUM(amount) AS total FROM collections GROUP BY handout_id) paid
+		       ON paid.handout_id = h.id
+		LEFT JOIN (SELECT handout_id, SUM(COALESCE(assessed_value, declared_value)) AS value, COUNT(*) AS items
+		           FROM collaterals WHERE returned_on IS NULL GROUP BY handout_id) held
+		       ON held.handout_id = h.id
+		WHERE (($1 = 0 AND h.status IN ('ACTIVE', 'PENDING')) OR h.id = $1)
+		  AND ($2 = 0 OR h.branch_id = $2)
+		ORDER BY h.id
+	`
+
+// Referral reward queries
+const GET_REWARD_RULES = `
+		SELECT id, name, kind, level, percent, amount, active, effective_from, COALESCE(created_by, 0), created_at, updated_at
+		FROM referral_reward_rules
+		ORDER BY level, id
+	`
+
+const GET_REWARD_RULE = `
+		SELECT id, name, kind, level, percent, amount, active, effective_from, COALESCE(created_by, 0), created_at, updated_at
+		FROM referral_reward_rules
+		WHERE id = $1
+	`
+
+const CREATE_REWARD_RULE = `
+		INSERT INTO referral_reward_rules (name, kind, level, percent, amount, active, effective_from, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, name, kind, level, percent, amount, active, effective_from, COALESCE(created_by, 0), created_at, updated_at
+	`
+
+const UPDATE_REWARD_RULE = `
+		UPDATE referral_reward_rules SET name = $1, percent = $2, amount = $3, active = $4, effective_from = $5
+		WHERE id = $6
+		RETURNING id, name, kind, level, percent, amount, active, effective_from, COALESCE(created_by, 0), created_at, updated_at
+	`
+
+// ACCRUE_REFERRAL_REWARDS records what the referrers of handout $1's borrower
+// earn from the active rules: the disbursement percent unless the handout was
+// CANCELLED, the completion bonus once it is COMPLETED. Running it again only
+// adds rewards not recorded yet for the rule and level, whoever the referrer
+// at that level is now, and never after the handout's rewards were clawed
+// back.
+const ACCRUE_REFERRAL_REWARDS = `
+		WITH RECURSIVE upline AS (
+			SELECT c.referred_by AS referrer_id, 1 AS level
+			FROM handouts h
+			JOIN customers c ON c.id = h.customer_id
+			WHERE h.id = $1 AND c.referred_by IS NOT NULL
+			UNION ALL
+			SELECT c.referred_by, u.level + 1
+			FROM upline u
+			JOIN customers c ON c.id = u.referrer_id
+			WHERE c.referred_by IS NOT NULL AND u.level < 5
+		), earned AS (
+			SELECT u.referrer_id, h.id AS handout_id, rr.id AS rule_id, rr.level, rr.name,
+			       CASE rr.kind WHEN 'DISBURSEMENT_PERCENT' THEN ROUND(h.amount * rr.percent / 100, 2) ELSE rr.amount END AS amount
+			FROM handouts h
+			JOIN upline u ON true
+			JOIN referral_reward_rules rr ON rr.level = u.level
+			WHERE h.id = $1 AND rr.active AND rr.effective_from <= h.date
+			  AND ((rr.kind = 'DISBURSEMENT_PERCENT' AND h.status NOT IN ('CANCELLED', 'WRITTEN_OFF'))
+			       OR (rr.kind = 'COMPLETION_BONUS' AND h.status = 'COMPLETED'))
+			  AND NOT EXISTS (SELECT 1 FROM referral_reward_ledger l WHERE l.handout_id = h.id AND l.entry_type = 'CLAWBACK')
+		)
+		INSERT INTO referral_reward_ledger (referrer_id, entry_type, amount, handout_id, rule_id, level, description, created_by)
+		SELECT referrer_id, 'ACCRUAL', amount, handout_id, rule_id, level, name, NULLIF($2, 0)
+		FROM earned
+		WHERE amount > 0
+		ON CONFLICT (rule_id, handout_id, level) WHERE entry_type = 'ACCRUAL' DO NOTHING
+	`
+
+// CLAWBACK_REFERRAL_REWARDS reverses every reward accrued on handout $1 that
+// has not been reversed yet
+const CLAWBACK_REFERRAL_REWARDS = `
+		INSERT INTO referral_reward_ledger (referrer_id, entry_type, amount, handout_id, rule_id, level, reverses_id, description, created_by)
+		SELECT a.referrer_id, 'CLAWBACK', -a.amount, a.handout_id, a.rule_id, a.level, a.id, $2, NULLIF($3, 0)
+		FROM referral_reward_ledger a
+		WHERE a.handout_id = $1 AND a.entry_type = 'ACCRUAL'
+		  AND NOT EXISTS (SELECT 1 FROM referral_reward_ledger c WHERE c.reverses_id = a.id)
+	`
+
+const LOCK_CUSTOMER = "SELECT id FROM customers WHERE id = $1 AND ($2 = 0 OR branch_id = $2) FOR UPDATE"
+
+const GET_REFERRAL_REWARD_BALANCE = `
+		SELECT COALESCE(SUM(amount) FILTER (WHERE entry_type = 'ACCRUAL'), 0),
+		       COALESCE(-SUM(amount) FILTER (WHERE entry_type = 'CLAWBACK'), 0),
+		       COALESCE(-SUM(amount) FILTER (WHERE entry_type = 'PAYOUT'), 0),
+		       COALESCE(SUM(amount), 0)
+		FROM referral_reward_ledger
+		WHERE referrer_id = $1
+	`
+
+const GET_REFERRAL_REWARD_LEDGER = `
+		SELECT id, referrer_id, entry_type, amount, COALESCE(handout_id, 0), COALESCE(rule_id, 0), COALESCE(level, 0),
+		       COALESCE(reverses_id, 0), payment_method, payment_reference, description, entry_date,
+		       COALESCE(created_by, 0), created_at
+		FROM referral_reward_ledger
+		WHERE referrer_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+const CREATE_REWARD_PAYOUT = `
+		INSERT INTO referral_reward_ledger (referrer_id, entry_type, amount, payment_method, payment_reference, description, entry_date, created_by)
+		VALUES ($1, 'PAYOUT', $2, $3, $4, $5, $6, NULLIF($7, 0))
+		RETURNING id, referrer_id, entry_type, amount, COALESCE(handout_id, 0), COALESCE(rule_id, 0), COALESCE(level, 0),
+		          COALESCE(reverses_id, 0), payment_method, payment_reference, description, entry_date,
+		          COALESCE(created_by, 0), created_at
+	`
+
+// GET_REFERRAL_REWARD_BALANCES sums the ledger of every referrer in the caller's branch
+const GET_REFERRAL_REWARD_BALANCES = `
+		SELECT c.id, c.name, c.mobile, c.branch_id,
+		       COALESCE(SUM(l.amount) FILTER (WHERE l.entry_type = 'ACCRUAL'), 0),
+		       COALESCE(-SUM(l.amount) FILTER (WHERE l.entry_type = 'CLAWBACK'), 0),
+		       COALESCE(-SUM(l.amount) FILTER (WHERE l.entry_type = 'PAYOUT'), 0),
+		       SUM(l.amount)
+		FROM referral_reward_ledger l
+		JOIN customers c ON c.id = l.referrer_id
+		WHERE ($1 = 0 OR c.branch_id = $1)
+		GROUP BY c.id
+		ORDER BY SUM(l.amount) DESC, c.id
+	`
+
+// API key queries
+const CREATE_API_KEY = `
+		INSERT INTO api_keys (name, admin_id, key_prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`
 
-const GET_CUSTOMER_HANDOUTS = "SELECT id, date, amount, status, bond, created_at, updated_at FROM handouts WHERE customer_id = $1 ORDER BY date DESC"
+const GET_API_KEYS = `
+		SELECT k.id, k.name, k.admin_id, a.username, k.key_prefix, k.scopes,
+		       k.expires_at, k.last_used_at, k.revoked_at, k.created_at, k.updated_at
+		FROM api_keys k
+		JOIN admins a ON k.admin_id = a.id
+		WHERE ($1 = 0 OR k.admin_id = $1)
+		ORDER BY k.id DESC
+	`
 
-const CREATE_HANDOUTS = "INSERT INTO handouts (date, amount, status, bond, customer_id) VALUES ($1, $2, $3, $4, $5);"
+const GET_API_KEY_FOR_AUTH = `
+		SELECT k.id, k.admin_id, a.username, a.role, a.branch_id, a.active, a.is_super_admin, k.scopes, k.expires_at, k.revoked_at
+		FROM api_keys k
+		JOIN admins a ON k.admin_id = a.id
+		WHERE k.key_hash = $1
+	`
 
-const DELETE_HANDOUTS = "DELETE FROM handouts WHERE id = $1"
+const TOUCH_API_KEY = "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1"
 
-const UPDATE_HANDOUT = `UPDATE handouts SET date = $1, amount = $2, status = $3, bond = $4, customer_id = $5 WHERE id = $6`
+const REVOKE_API_KEY = "UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW() WHERE id = $1 AND ($2 = 0 OR admin_id = $2) AND revoked_at IS NULL"
 
-const GET_ALL_COLLECTIONS = "SELECT id, date, amount, handout_id, created_at, updated_at FROM collections ORDER BY id DESC"
+// Admin session queries
+const CREATE_ADMIN_SESSION = `
+		INSERT INTO admin_sessions (admin_id, token_id, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+const GET_SESSION_FOR_AUTH = `
+		SELECT s.id, a.active, a.branch_id, a.is_super_admin
+		FROM admin_sessions s
+		JOIN admins a ON s.admin_id = a.id
+		WHERE s.token_id = $1 AND s.admin_id = $2
+		  AND s.revoked_at IS NULL AND s.expires_at > NOW()
+	`
 
-const GET_HANDOUT_COLLECTIONS = "SELECT id, date, amount, created_at, updated_at FROM collections WHERE handout_id = $1 ORDER BY date DESC"
+const TOUCH_ADMIN_SESSION = "UPDATE admin_sessions SET last_seen_at = NOW() WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'"
 
-const CREATE_COLLECTION = "INSERT INTO collections (date, amount, handout_id) VALUES ($1, $2, $3);"
+const GET_ADMIN_SESSIONS = `
+		SELECT id, admin_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_at, last_seen_at, expires_at
+		FROM admin_sessions
+		WHERE admin_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`
+
+const REVOKE_ADMIN_SESSION = "UPDATE admin_sessions SET revoked_at = NOW() WHERE id = $1 AND admin_id = $2 AND revoked_at IS NULL"
+
+const REVOKE_ALL_ADMIN_SESSIONS = "UPDATE admin_sessions SET revoked_at = NOW() WHERE admin_id = $1 AND revoked_at IS NULL"
+
+// Health check queries
+const GET_SCHEMA_VERSION = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+
+// Metrics queries
+const GET_PORTFOLIO_METRICS = `
+		SELECT COUNT(*), COALESCE(SUM(h.amount - COALESCE(c.paid, 0)), 0)
+		FROM handouts h
+		LEFT JOIN (SELECT handout_id, SUM(amount) AS paid FROM collections GROUP BY handout_id) c
+		       ON c.handout_id = h.id
+		WHERE h.status = 'ACTIVE'
+	`
+
+// GET_COLLECTIONS_TODAY_METRICS leaves out recoveries, see GET_WRITE_OFF_METRICS
+const GET_COLLECTIONS_TODAY_METRICS = "SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM collections WHERE created_at >= date_trunc('day', NOW()) AND NOT recovery"
+
+const GET_WRITE_OFF_METRICS = `
+		SELECT COUNT(*), COALESCE(SUM(amount), 0),
+		       (SELECT COALESCE(SUM(amount), 0) FROM collections WHERE recovery)
+		FROM handout_write_offs
+	`
+
+// Duplicate customer queries. Names and addresses are compared lowercased by
+// pg_trgm similarity, which is between 0 and 1.
+
+// FIND_DUPLICATE_CUSTOMERS finds customers other than $4 with mobile $1, or a
+// name like $2 (at least $6 similar) at an address like $3 (at least $7 similar)
+const FIND_DUPLICATE_CUSTOMERS = `
+		SELECT id, name, mobile, address, branch_id, mobile = $1,
+		       ROUND(similarity(lower(name), lower($2))::numeric, 2),
+		       ROUND(similarity(lower(address), lower($3))::numeric, 2)
+		FROM customers
+		WHERE id <> $4 AND ($5 = 0 OR branch_id = $5)
+		  AND (mobile = $1 OR (lower(name) % lower($2)
+		       AND similarity(lower(name), lower($2)) >= $6
+		       AND similarity(lower(address), lower($3)) >= $7))
+		ORDER BY mobile = $1 DESC, similarity(lower(name), lower($2)) DESC, id
+		LIMIT 20
+	`
+
+const GET_DUPLICATE_CUSTOMERS = `
+		SELECT a.id, a.name, a.mobile, a.address, a.branch_id,
+		       b.id, b.name, b.mobile, b.address, b.branch_id, a.mobile = b.mobile,
+		       ROUND(similarity(lower(a.name), lower(b.name))::numeric, 2),
+		       ROUND(similarity(lower(a.address), lower(b.address))::numeric, 2)
+		FROM customers a
+		JOIN customers b ON a.id < b.id
+		  AND (a.mobile = b.mobile OR (lower(a.name) % lower(b.name)
+		       AND similarity(lower(a.name), lower(b.name)) >= $2
+		       AND similarity(lower(a.address), lower(b.address)) >= $3))
+		WHERE ($1 = 0 OR (a.branch_id = $1 AND b.branch_id = $1))
+		ORDER BY a.mobile = b.mobile DESC, similarity(lower(a.name), lower(b.name)) DESC, a.id, b.id
+	`
+
+// Customer merge queries, run in one transaction by mergeCustomer under LOCK_REFERRALS
+const LOCK_MERGE_CUSTOMERS = `
+		SELECT id, address, created_at, info, mobile, name, COALESCE(referred_by, -1), branch_id, updated_at
+		FROM customers
+		WHERE id IN ($1, $2) AND ($3 = 0 OR branch_id = $3)
+		ORDER BY id
+		FOR UPDATE
+	`
+
+// CHECK_MERGE_PARTY_CONFLICT reports whether merging $2 into $1 would make a
+// customer a guarantor or nominee of their own handout, or link them twice in
+// the same role
+const CHECK_MERGE_PARTY_CONFLICT = `
+		SELECT EXISTS(
+			SELECT 1
+			FROM handout_parties p
+			JOIN handouts h ON h.id = p.handout_id
+			WHERE (p.customer_id = $2 AND h.customer_id = $1)
+			   OR (p.customer_id = $1 AND h.customer_id = $2)
+			   OR (p.customer_id = $2 AND EXISTS (
+			       SELECT 1 FROM handout_parties q
+			       WHERE q.handout_id = p.handout_id AND q.role = p.role AND q.customer_id = $1))
+		)
+	`
+
+const MERGE_CUSTOMER_HANDOUTS = "UPDATE handouts SET customer_id = $1 WHERE customer_id = $2"
+
+const MERGE_CUSTOMER_DOCUMENTS = "UPDATE customer_documents SET customer_id = $1 WHERE customer_id = $2"
+
+const MERGE_CUSTOMER_PARTIES = "UPDATE handout_parties SET customer_id = $1 WHERE customer_id = $2"
+
+// MERGE_CUSTOMER_DUPLICATE_REWARDS reverses the accruals of $2 for a rule and
+// handout $1 was paid for as well, so the merged ledger pays each once
+const MERGE_CUSTOMER_DUPLICATE_REWARDS = `
+		INSERT INTO referral_reward_ledger (referrer_id, entry_type, amount, handout_id, rule_id, level, reverses_id, description)
+		SELECT a.referrer_id, 'CLAWBACK', -a.amount, a.handout_id, a.rule_id, a.level, a.id, 'Customer merged, already accrued to the survivor'
+		FROM referral_reward_ledger a
+		WHERE a.referrer_id = $2 AND a.entry_type = 'ACCRUAL'
+		  AND NOT EXISTS (SELECT 1 FROM referral_reward_ledger c WHERE c.reverses_id = a.id)
+		  AND EXISTS (
+			SELECT 1 FROM referral_reward_ledger s
+			WHERE s.referrer_id = $1 AND s.entry_type = 'ACCRUAL' AND s.rule_id = a.rule_id AND s.handout_id = a.handout_id
+			  AND NOT EXISTS (SELECT 1 FROM referral_reward_ledger c WHERE c.reverses_id = s.id)
+		  )
+	`
+
+const MERGE_CUSTOMER_REWARDS = "UPDATE referral_reward_ledger SET referrer_id = $1 WHERE referrer_id = $2"
+
+const MERGE_CUSTOMER_OVERRIDES = "UPDATE eligibility_overrides SET customer_id = $1 WHERE customer_id = $2"
+
+const MERGE_CUSTOMER_TRANSFERS = "UPDATE customer_transfers SET customer_id = $1 WHERE customer_id = $2"
+
+const MERGE_CUSTOMER_REFERRAL_CHANGES = "UPDATE customer_referral_changes SET customer_id = $1 WHERE customer_id = $2"
+
+// MERGE_CUSTOMER_MERGES keeps earlier merges into $2 when $2 is merged in turn
+const MERGE_CUSTOMER_MERGES = "UPDATE customer_merges SET survivor_id = $1 WHERE survivor_id = $2"
+
+// MERGE_CUSTOMER_REFERRALS moves the customers referred by $2, except $1
+// itself, to $1 and records each change with reason $3 by admin $4
+const MERGE_CUSTOMER_REFERRALS = `
+		WITH moved AS (
+			UPDATE customers SET referred_by = $1
+			WHERE referred_by = $2 AND id <> $1
+			RETURNING id
+		)
+		INSERT INTO customer_referral_changes (customer_id, previous_referred_by, referred_by, reason, changed_by)
+		SELECT id, $2, $1, $3, NULLIF($4, 0) FROM moved
+	`
+
+// MERGE_REFERRAL_CHANGE_REFERRERS keeps $2 in the referral history of others as $1
+const MERGE_REFERRAL_CHANGE_REFERRERS = `
+		UPDATE customer_referral_changes
+		SET previous_referred_by = CASE WHEN previous_referred_by = $2 THEN $1 ELSE previous_referred_by END,
+		    referred_by = CASE WHEN referred_by = $2 THEN $1 ELSE referred_by END
+		WHERE previous_referred_by = $2 OR referred_by = $2
+	`
+
+const CREATE_CUSTOMER_MERGE = `
+		INSERT INTO customer_merges (survivor_id, merged_id, merged_customer, handouts_moved, documents_moved, referrals_moved, reason, merged_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
+		RETURNING id, created_at
+	`
+
+const GET_CUSTOMER_MERGES = `
+		SELECT id, survivor_id, merged_id, merged_customer, handouts_moved, documents_moved, referrals_moved,
+		       reason, COALESCE(merged_by, 0), created_at
+		FROM customer_merges
+		WHERE survivor_id = $1
+		ORDER BY created_at DESC
+	`
+
+// Eligibility queries, run in the disbursing transaction after LOCK_CUSTOMER
+const GET_ELIGIBILITY_RULES = `
+		SELECT max_active_handouts, max_total_exposure, max_arrears_days, require_kyc,
+		       COALESCE(updated_by, 0), updated_at
+		FROM eligibility_rules
+	`
+
+const UPDATE_ELIGIBILITY_RULES = `
+		UPDATE eligibility_rules
+		SET max_active_handouts = $1, max_total_exposure = $2, max_arrears_days = $3, require_kyc = $4,
+		    updated_by = NULLIF($5, 0)
+		RETURNING max_active_handouts, max_total_exposure, max_arrears_days, require_kyc,
+		          COALESCE(updated_by, 0), updated_at
+	`
+
+// GET_CUSTOMER_CREDIT returns the credit limit of customer $1 and the count
+// and outstanding of their handouts that still owe money, leaving out handout
+// $2. Arrears are the days since the last collection (or disbursement) of
+// those without a schedule, the ones with a schedule are listed to work out
+// theirs from the installments. Last is what their written-off handouts still
+// owe after recoveries.
+const GET_CUSTOMER_CREDIT = `
+		SELECT c.credit_limit, COUNT(h.id), COALESCE(SUM(h.amount - h.collected), 0),
+		       COALESCE(MAX(CURRENT_DATE - h.last_paid::date) FILTER (WHERE NOT h.scheduled), 0),
+		       ARRAY_AGG(h.id) FILTER (WHERE h.scheduled),
+		       (SELECT COALESCE(SUM(GREATEST(w.amount - (SELECT COALESCE(SUM(k.amount), 0) FROM collections k
+		                                                 WHERE k.handout_id = w.handout_id AND k.recovery), 0)), 0)
+		        FROM handout_write_offs w
+		        JOIN handouts wh ON wh.id = w.handout_id
+		        WHERE wh.customer_id = $1 AND wh.id <> $2)
+		FROM customers c
+		LEFT JOIN (
+			SELECT h.id, h.amount, COALESCE(SUM(k.amount), 0) AS collected, GREATEST(h.date, MAX(k.date)) AS last_paid,
+			       EXISTS (SELECT 1 FROM handout_schedules s WHERE s.handout_id = h.id) AS scheduled
+			FROM handouts h
+			LEFT JOIN collections k ON k.handout_id = h.id
+			WHERE h.customer_id = $1 AND h.id <> $2 AND h.status IN ('ACTIVE', 'PENDING')
+			GROUP BY h.id
+			HAVING COALESCE(SUM(k.amount), 0) < h.amount
+		) h ON true
+		WHERE c.id = $1
+		GROUP BY c.id
+	`
+
+// GET_CUSTOMER_MISSING_KYC lists the document types of $1 customer $2 has no unexpired document of
+const GET_CUSTOMER_MISSING_KYC = `
+		SELECT ARRAY(SELECT t FROM unnest($1::text[]) t
+		             WHERE NOT EXISTS (SELECT 1 FROM customer_documents d
+		                               WHERE d.customer_id = $2 AND d.document_type::text = t
+		                                 AND (d.expires_on IS NULL OR d.expires_on >= CURRENT_DATE)))
+	`
+
+const UPDATE_CUSTOMER_CREDIT_LIMIT = "UPDATE customers SET credit_limit = $1 WHERE id = $2 AND ($3 = 0 OR branch_id = $3)"
+
+// LOCK_HANDOUT_BALANCE locks a handout and returns what putHandout compares
+// the update against: customer, amount, status and amount collected
+const LOCK_HANDOUT_BALANCE = `
+		SELECT h.customer_id, h.amount, h.status,
+		       (SELECT COALESCE(SUM(k.amount), 0) FROM collections k WHERE k.handout_id = h.id)
+		FROM handouts h
+		WHERE h.id = $1 AND ($2 = 0 OR h.branch_id = $2)
+		FOR UPDATE OF h
+	`
+
+const CREATE_ELIGIBILITY_OVERRIDE = `
+		INSERT INTO eligibility_overrides (handout_id, customer_id, amount, failed_rules, reason, overridden_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+	`
+
+// Risk score queries. A gap is the days between a collection and the one
+// before it (or the disbursement), or for a handout still owing money the days
+// since its last collection. Gaps longer than $2 days are late. A written-off
+// handout keeps owing here, it stays late however much is recovered.
+
+// GET_RISK_FACTORS returns the repayment history of customer $1, every
+// customer when $1 is 0. The network of a customer is their referrer and the
+// customers they referred, a member defaults with an owing handout whose
+// current gap is late.
+const GET_RISK_FACTORS = `
+		WITH gaps AS (
+			SELECT h.customer_id,
+			       k.date::date - COALESCE(LAG(k.date) OVER (PARTITION BY h.id ORDER BY k.date, k.id), h.date)::date AS gap
+			FROM handouts h
+			JOIN collections k ON k.handout_id = h.id
+			WHERE ($1 = 0 OR h.customer_id = $1) AND h.status <> 'CANCELLED'
+		), open_gaps AS (
+			SELECT h.customer_id, CURRENT_DATE - GREATEST(h.date, MAX(k.date))::date AS gap
+			FROM handouts h
+			LEFT JOIN collections k ON k.handout_id = h.id
+			WHERE h.status IN ('ACTIVE', 'PENDING', 'WRITTEN_OFF')
+			GROUP BY h.id
+			HAVING COALESCE(SUM(k.amount), 0) < h.amount OR h.status = 'WRITTEN_OFF'
+		)
+		SELECT c.id,
+		       COALESCE(hs.handouts, 0), COALESCE(hs.completed, 0), COALESCE(hs.cancelled, 0),
+		       COALESCE(g.collections, 0), COALESCE(g.on_time, 0),
+		       COALESCE(g.late, 0) + COALESCE(o.late, 0), COALESCE(g.late_days, 0) + COALESCE(o.late_days, 0),
+		       net.size, net.defaults
+		FROM customers c
+		LEFT JOIN (
+			SELECT customer_id, COUNT(*) AS handouts,
+			       COUNT(*) FILTER (WHERE status = 'COMPLETED') AS completed,
+			       COUNT(*) FILTER (WHERE status = 'CANCELLED') AS cancelled
+			FROM handouts
+			WHERE ($1 = 0 OR customer_id = $1)
+			GROUP BY customer_id
+		) hs ON hs.customer_id = c.id
+		LEFT JOIN (
+			SELECT customer_id, COUNT(*) AS collections,
+			       COUNT(*) FILTER (WHERE gap <= $2) AS on_time,
+			       COUNT(*) FILTER (WHERE gap > $2) AS late,
+			       SUM(gap - $2) FILTER (WHERE gap > $2) AS late_days
+			FROM gaps
+			GROUP BY customer_id
+		) g ON g.customer_id = c.id
+		LEFT JOIN (
+			SELECT customer_id, COUNT(*) AS late, SUM(gap - $2) AS late_days
+			FROM open_gaps
+			WHERE gap > $2
+			GROUP BY customer_id
+		) o ON o.customer_id = c.id
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS size,
+			       COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM open_gaps d WHERE d.customer_id = n.id AND d.gap > $2)) AS defaults
+			FROM customers n
+			WHERE n.id = c.referred_by OR n.referred_by = c.id
+		) net
+		WHERE ($1 = 0 OR c.id = $1)
+		ORDER BY c.id
+	`
+
+const UPSERT_RISK_SCORE = `
+		INSERT INTO customer_risk_scores (customer_id, score, factors, computed_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (customer_id) DO UPDATE
+		SET score = EXCLUDED.score, factors = EXCLUDED.factors, computed_at = EXCLUDED.computed_at
+	`
+
+const DELETE_RISK_SCORE = "DELETE FROM customer_risk_scores WHERE customer_id = $1"
+
+const GET_RISK_SCORE = `
+		SELECT s.score, s.factors, s.computed_at
+		FROM customer_risk_scores s
+		JOIN customers c ON c.id = s.customer_id
+		WHERE s.customer_id = $1 AND ($2 = 0 OR c.branch_id = $2)
+	`
+
+const GET_HANDOUT_CUSTOMER = "SELECT customer_id FROM handouts WHERE id = $1"
+
+const GET_COLLECTION_CUSTOMER = "SELECT h.customer_id FROM collections k JOIN handouts h ON h.id = k.handout_id WHERE k.id = $1"
+
+const GET_PENALTY_RULES = `
+		SELECT grace_days, flat_fee, daily_percent, max_per_installment, max_per_handout,
+		       COALESCE(updated_by, 0), updated_at
+		FROM penalty_rules
+	`
+
+const UPDATE_PENALTY_RULES = `
+		UPDATE penalty_rules
+		SET grace_days = $1, flat_fee = $2, daily_percent = $3, max_per_installment = $4, max_per_handout = $5,
+		    updated_by = NULLIF($6, 0)
+		RETURNING grace_days, flat_fee, daily_percent, max_per_installment, max_per_handout,
+		          COALESCE(updated_by, 0), updated_at
+	`
+
+// GET_HANDOUT_SCHEDULE returns version $3 of the schedule of handout $1, the
+// current one when $3 is 0
+const GET_HANDOUT_SCHEDULE = `
+		SELECT s.id, s.handout_id, s.version, s.principal, s.annual_rate, s.interest, s.installments, s.frequency,
+		       s.first_due_date, s.collected_before, s.penalties_paid_before, COALESCE(s.created_by, 0), s.created_at, s.updated_at
+		FROM handout_schedules s
+		JOIN handouts h ON h.id = s.handout_id
+		WHERE s.handout_id = $1 AND ($2 = 0 OR h.branch_id = $2) AND ($3 = 0 OR s.version = $3)
+		ORDER BY s.version DESC
+		LIMIT 1
+	`
+
+// UPDATE_FIRST_SCHEDULE corrects the first version of the schedule of handout
+// $1, checked with GET_SCHEDULE_LOCKS first
+const UPDATE_FIRST_SCHEDULE = `
+		UPDATE handout_schedules
+		SET principal = $2, annual_rate = $3, interest = $4, installments = $5, frequency = $6, first_due_date = $7,
+		    created_by = NULLIF($8, 0)
+		WHERE handout_id = $1 AND version = 1
+	`
+
+const CREATE_HANDOUT_SCHEDULE = `
+		INSERT INTO handout_schedules (handout_id, version, principal, annual_rate, interest, installments, frequency,
+		                               first_due_date, collected_before, penalties_paid_before, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, 0))
+		RETURNING id
+	`
+
+// GET_SCHEDULE_LOCKS says whether handout $1 has accrued penalties and whether
+// it was restructured, either fixes its schedule
+const GET_SCHEDULE_LOCKS = `
+		SELECT EXISTS (SELECT 1 FROM handout_penalties WHERE handout_id = $1),
+		       EXISTS (SELECT 1 FROM handout_restructures WHERE handout_id = $1),
+		       EXISTS (SELECT 1 FROM handout_settlements WHERE handout_id = $1)
+	`
+
+const CHECK_HANDOUT_SCHEDULE = "SELECT EXISTS (SELECT 1 FROM handout_schedules WHERE handout_id = $1)"
+
+const CREATE_HANDOUT_RESTRUCTURE = `
+		INSERT INTO handout_restructures (handout_id, from_schedule_id, to_schedule_id, operations, remaining_principal,
+		                                  capitalized_interest, capitalized_penalties, top_up, unearned_interest, reason,
+		                                  restructured_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, 0))
+		RETURNING id, created_at
+	`
+
+const GET_HANDOUT_RESTRUCTURES = `
+		SELECT r.id, r.handout_id, f.version, t.version, r.operations, r.remaining_principal, r.capitalized_interest,
+		       r.capitalized_penalties, r.top_up, r.unearned_interest, r.reason, COALESCE(r.restructured_by, 0), r.created_at
+		FROM handout_restructures r
+		JOIN handout_schedules f ON f.id = r.from_schedule_id
+		JOIN handout_schedules t ON t.id = r.to_schedule_id
+		JOIN handouts h ON h.id = r.handout_id
+		WHERE r.handout_id = $1 AND ($2 = 0 OR h.branch_id = $2)
+		ORDER BY t.version
+	`
+
+const ADD_HANDOUT_TOP_UP = "UPDATE handouts SET amount = amount + $2 WHERE id = $1"
+
+// GET_PENALTY_HANDOUTS lists the handouts that accrue penalties: owed ones
+// with a schedule, handout $1 only unless it is 0
+const GET_PENALTY_HANDOUTS = `
+		SELECT h.id
+		FROM handouts h
+		JOIN handout_schedules s ON s.handout_id = h.id
+		WHERE h.status IN ('ACTIVE', 'PENDING') AND ($1 = 0 OR h.id = $1)
+		ORDER BY h.id
+	`
+
+// GET_PAYMENTS returns the collections of handout $1 oldest first, the order
+// they pay installments in
+const GET_PAYMENTS = "SELECT id, date, amount FROM collections WHERE handout_id = $1 ORDER BY date, id"
+
+const GET_HANDOUT_PENALTIES = `
+		SELECT id, handout_id, schedule_id, installment, kind, accrued_on, amount, created_at
+		FROM handout_penalties
+		WHERE handout_id = $1
+		ORDER BY accrued_on, installment, kind
+	`
+
+const CREATE_PENALTY = `
+		INSERT INTO handout_penalties (handout_id, schedule_id, installment, kind, accrued_on, amount)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (schedule_id, installment, kind, accrued_on) DO NOTHING
+	`
+
+const GET_PENALTY_WAIVERS = `
+		SELECT id, handout_id, amount, reason, COALESCE(approved_by, 0), created_at
+		FROM penalty_waivers
+		WHERE handout_id = $1
+		ORDER BY created_at, id
+	`
+
+const CREATE_PENALTY_WAIVER = `
+		INSERT INTO penalty_waivers (handout_id, amount, reason, approved_by)
+		VALUES ($1, $2, $3, NULLIF($4, 0))
+		RETURNING id, handout_id, amount, reason, COALESCE(approved_by, 0), created_at
+	`
+
+// GET_HANDOUT_BALANCE returns what handout $1 was given, collected, charged
+// in penalties, waived, capitalized, written off and recovered
+const GET_HANDOUT_BALANCE = `
+		SELECT h.status, h.amount,
+		       (SELECT COALESCE(SUM(k.amount), 0) FROM collections k WHERE k.handout_id = h.id),
+		       (SELECT COALESCE(SUM(p.amount), 0) FROM handout_penalties p WHERE p.handout_id = h.id),
+		       (SELECT COALESCE(SUM(v.amount), 0) FROM penalty_waivers v WHERE v.handout_id = h.id),
+		       (SELECT COALESCE(SUM(r.capitalized_penalties), 0) FROM handout_restructures r WHERE r.handout_id = h.id),
+		       COALESCE((SELECT o.amount FROM handout_write_offs o WHERE o.handout_id = h.id), 0),
+		       (SELECT COALESCE(SUM(k.amount), 0) FROM collections k WHERE k.handout_id = h.id AND k.recovery)
+		FROM handouts h
+		WHERE h.id = $1 AND ($2 = 0 OR h.branch_id = $2)
+	`
+
+// GET_CUSTOMER_STATEMENT lists every disbursement, top-up, interest charge,
+// collection, recovery, penalty, waiver and settlement rebate of customer $1's
+// handouts, penalties summed
+// per handout and day. Interest is charged in full when a schedule version is
+// made, a restructure reverses the part not due yet. Cancelled handouts were
+// never disbursed and are left out.
+const GET_CUSTOMER_STATEMENT = `
+		WITH handout AS (
+			SELECT id, date, amount FROM handouts WHERE customer_id = $1 AND status <> 'CANCELLED'
+		)
+		SELECT entry_date, entry_type, handout_id, amount
+		FROM (
+			SELECT h.date::date AS entry_date, 'DISBURSEMENT' AS entry_type, h.id AS handout_id,
+			       h.amount - (SELECT COALESCE(SUM(r.top_up), 0) FROM handout_restructures r WHERE r.handout_id = h.id) AS amount,
+			       1 AS entry_order
+			FROM handout h
+			UNION ALL
+			SELECT r.created_at::date, 'DISBURSEMENT', r.handout_id, r.top_up, 1
+			FROM handout_restructures r
+			JOIN handout h ON h.id = r.handout_id
+			WHERE r.top_up > 0
+			UNION ALL
+			SELECT r.created_at::date, 'INTEREST_REVERSAL', r.handout_id, r.unearned_interest, 2
+			FROM handout_restructures r
+			JOIN handout h ON h.id = r.handout_id
+			WHERE r.unearned_interest > 0
+			UNION ALL
+			SELECT s.created_at::date, 'INTEREST', s.handout_id, s.interest, 3
+			FROM handout_schedules s
+			JOIN handout h ON h.id = s.handout_id
+			WHERE s.interest > 0
+			UNION ALL
+			SELECT p.accrued_on, 'PENALTY', p.handout_id, SUM(p.amount), 4
+			FROM handout_penalties p
+			JOIN handout h ON h.id = p.handout_id
+			GROUP BY p.handout_id, p.accrued_on
+			UNION ALL
+			SELECT k.date::date, CASE WHEN k.recovery THEN 'RECOVERY' ELSE 'COLLECTION' END, k.handout_id, k.amount, 5
+			FROM collections k
+			JOIN handout h ON h.id = k.handout_id
+			UNION ALL
+			SELECT v.created_at::date, 'WAIVER', v.handout_id, v.amount, 6
+			FROM penalty_waivers v
+			JOIN handout h ON h.id = v.handout_id
+			UNION ALL
+			SELECT t.settled_on, 'REBATE', t.handout_id, t.rebate, 7
+			FROM handout_settlements t
+			JOIN handout h ON h.id = t.handout_id
+			WHERE t.rebate > 0
+		) entries
+		ORDER BY entry_date, entry_order, handout_id
+	`
+
+// Write-off queries
+const CREATE_HANDOUT_WRITE_OFF = `
+		INSERT INTO handout_write_offs (handout_id, principal, interest, penalties, amount, reason, approved_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+	`
+
+const WRITE_OFF_HANDOUT = "UPDATE handouts SET status = 'WRITTEN_OFF' WHERE id = $1"
+
+// GET_WRITE_OFFS lists the write-offs of handout $1, every handout when it is
+// 0, written off between $3 and $4 when given, with what was recovered since
+const GET_WRITE_OFFS = `
+		SELECT o.id, o.handout_id, c.id, c.name, c.mobile, o.principal, o.interest, o.penalties, o.amount,
+		       (SELECT COALESCE(SUM(k.amount), 0) FROM collections k WHERE k.handout_id = o.handout_id AND k.recovery),
+		       o.reason, COALESCE(o.approved_by, 0), o.written_off_on, o.created_at
+		FROM handout_write_offs o
+		JOIN handouts h ON h.id = o.handout_id
+		JOIN customers c ON c.id = h.customer_id
+		WHERE ($1 = 0 OR o.handout_id = $1) AND ($2 = 0 OR h.branch_id = $2)
+		  AND ($3::date IS NULL OR o.written_off_on >= $3) AND ($4::date IS NULL OR o.written_off_on <= $4)
+		ORDER BY o.written_off_on DESC, o.id DESC
+	`
+
+// Settlement queries
+const GET_SETTLEMENT_POLICY = "SELECT rebate_percent, COALESCE(updated_by, 0), updated_at FROM settlement_policy"
+
+const UPDATE_SETTLEMENT_POLICY = `
+		UPDATE settlement_policy
+		SET rebate_percent = $1, updated_by = NULLIF($2, 0)
+		RETURNING rebate_percent, COALESCE(updated_by, 0), updated_at
+	`
+
+const CREATE_HANDOUT_SETTLEMENT = `
+		INSERT INTO handout_settlements (handout_id, collection_id, settled_on, principal, interest, penalties, rebate,
+		                                 amount, settled_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+	`
+
+const GET_HANDOUT_SETTLEMENT = `
+		SELECT t.id, t.handout_id, COALESCE(t.collection_id, 0), t.settled_on, t.principal, t.interest, t.penalties,
+		       t.rebate, t.amount, COALESCE(t.settled_by, 0), t.created_at
+		FROM handout_settlements t
+		JOIN handouts h ON h.id = t.handout_id
+		WHERE t.handout_id = $1 AND ($2 = 0 OR h.branch_id = $2)
+	`
+
+// GET_HANDOUT_SETTLED returns the date of handout $1 and whether it was settled
+const GET_HANDOUT_SETTLED = `
+		SELECT h.date, EXISTS (SELECT 1 FROM handout_settlements s WHERE s.handout_id = h.id)
+		FROM handouts h
+		WHERE h.id = $1
+	`
 
-const DELETE_COLLECTION = "DELETE FROM collections WHERE id = $1"
+const COMPLETE_HANDOUT = "UPDATE handouts SET status = 'COMPLETED' WHERE id = $1"
 
-const UPDATE_COLLECTION = `UPDATE collections SET date = $1, amount = $2, handout_id = $3 WHERE id = $4`
+// queryNames maps each query above to its name for tracing spans, keep it in sync
+var queryNames = map[string]string{
+	GET_ALL_CUSTOMERS:                "GET_ALL_CUSTOMERS",
+	CREATE_CUSTOMER:                  "CREATE_CUSTOMER",
+	GET_CUSTOMER_BY_ID:               "GET_CUSTOMER_BY_ID",
+	UPDATE_CUSTOMER:                  "UPDATE_CUSTOMER",
+	DELETE_CUSTOMER:                  "DELETE_CUSTOMER",
+	CHECK_CUSTOMER_EXISTS:            "CHECK_CUSTOMER_EXISTS",
+	GET_CUSTOMER_BRANCH:              "GET_CUSTOMER_BRANCH",
+	UPDATE_CUSTOMER_REFERRAL:         "UPDATE_CUSTOMER_REFERRAL",
+	LOCK_REFERRALS:                   "LOCK_REFERRALS",
+	LOCK_CUSTOMER_REFERRAL:           "LOCK_CUSTOMER_REFERRAL",
+	CHECK_REFERRAL_CYCLE:             "CHECK_REFERRAL_CYCLE",
+	CREATE_REFERRAL_CHANGE:           "CREATE_REFERRAL_CHANGE",
+	GET_REFERRAL_CHANGES:             "GET_REFERRAL_CHANGES",
+	GET_REFERRAL_DOWNLINE:            "GET_REFERRAL_DOWNLINE",
+	GET_REFERRAL_UPLINE:              "GET_REFERRAL_UPLINE",
+	GET_HANDOUTS_WITH_CUSTOMERS:      "GET_HANDOUTS_WITH_CUSTOMERS",
+	GET_HANDOUT_BY_ID:                "GET_HANDOUT_BY_ID",
+	GET_HANDOUT_BRANCH:               "GET_HANDOUT_BRANCH",
+	GET_CUSTOMER_HANDOUTS:            "GET_CUSTOMER_HANDOUTS",
+	CREATE_HANDOUTS:                  "CREATE_HANDOUTS",
+	DELETE_HANDOUTS:                  "DELETE_HANDOUTS",
+	UPDATE_HANDOUT:                   "UPDATE_HANDOUT",
+	GET_ALL_COLLECTIONS:              "GET_ALL_COLLECTIONS",
+	GET_HANDOUT_COLLECTIONS:          "GET_HANDOUT_COLLECTIONS",
+	CREATE_COLLECTION:                "CREATE_COLLECTION",
+	DELETE_COLLECTION:                "DELETE_COLLECTION",
+	UPDATE_COLLECTION:                "UPDATE_COLLECTION",
+	GET_ALL_BRANCHES:                 "GET_ALL_BRANCHES",
+	GET_BRANCH_BY_ID:                 "GET_BRANCH_BY_ID",
+	CREATE_BRANCH:                    "CREATE_BRANCH",
+	UPDATE_BRANCH:                    "UPDATE_BRANCH",
+	LOCK_CUSTOMER_BRANCH:             "LOCK_CUSTOMER_BRANCH",
+	TRANSFER_CUSTOMER:                "TRANSFER_CUSTOMER",
+	TRANSFER_CUSTOMER_HANDOUTS:       "TRANSFER_CUSTOMER_HANDOUTS",
+	TRANSFER_CUSTOMER_COLLECTIONS:    "TRANSFER_CUSTOMER_COLLECTIONS",
+	CREATE_CUSTOMER_TRANSFER:         "CREATE_CUSTOMER_TRANSFER",
+	GET_CUSTOMER_TRANSFERS:           "GET_CUSTOMER_TRANSFERS",
+	GET_CUSTOMER_DOCUMENTS:           "GET_CUSTOMER_DOCUMENTS",
+	GET_CUSTOMER_DOCUMENT:            "GET_CUSTOMER_DOCUMENT",
+	CREATE_CUSTOMER_DOCUMENT:         "CREATE_CUSTOMER_DOCUMENT",
+	DELETE_CUSTOMER_DOCUMENT:         "DELETE_CUSTOMER_DOCUMENT",
+	GET_MISSING_KYC:                  "GET_MISSING_KYC",
+	LOCK_HANDOUT:                     "LOCK_HANDOUT",
+	GET_PARTY_SHARE_TOTAL:            "GET_PARTY_SHARE_TOTAL",
+	CREATE_HANDOUT_PARTY:             "CREATE_HANDOUT_PARTY",
+	GET_HANDOUT_PARTIES:              "GET_HANDOUT_PARTIES",
+	GET_HANDOUT_PARTY:                "GET_HANDOUT_PARTY",
+	GET_BRANCH_HANDOUT_PARTIES:       "GET_BRANCH_HANDOUT_PARTIES",
+	DELETE_HANDOUT_PARTY:             "DELETE_HANDOUT_PARTY",
+	GET_GUARANTEES:                   "GET_GUARANTEES",
+	GET_HANDOUT_COLLATERALS:          "GET_HANDOUT_COLLATERALS",
+	GET_COLLATERAL:                   "GET_COLLATERAL",
+	CREATE_COLLATERAL:                "CREATE_COLLATERAL",
+	UPDATE_COLLATERAL:                "UPDATE_COLLATERAL",
+	DELETE_COLLATERAL:                "DELETE_COLLATERAL",
+	LOCK_HANDOUT_STATUS:              "LOCK_HANDOUT_STATUS",
+	RELEASE_COLLATERAL:               "RELEASE_COLLATERAL",
+	GET_COLLATERAL_EXPOSURE:          "GET_COLLATERAL_EXPOSURE",
+	GET_REWARD_RULES:                 "GET_REWARD_RULES",
+	GET_REWARD_RULE:                  "GET_REWARD_RULE",
+	CREATE_REWARD_RULE:               "CREATE_REWARD_RULE",
+	UPDATE_REWARD_RULE:               "UPDATE_REWARD_RULE",
+	ACCRUE_REFERRAL_REWARDS:          "ACCRUE_REFERRAL_REWARDS",
+	CLAWBACK_REFERRAL_REWARDS:        "CLAWBACK_REFERRAL_REWARDS",
+	LOCK_CUSTOMER:                    "LOCK_CUSTOMER",
+	GET_REFERRAL_REWARD_BALANCE:      "GET_REFERRAL_REWARD_BALANCE",
+	GET_REFERRAL_REWARD_LEDGER:       "GET_REFERRAL_REWARD_LEDGER",
+	CREATE_REWARD_PAYOUT:             "CREATE_REWARD_PAYOUT",
+	GET_REFERRAL_REWARD_BALANCES:     "GET_REFERRAL_REWARD_BALANCES",
+	CREATE_API_KEY:                   "CREATE_API_KEY",
+	GET_API_KEYS:                     "GET_API_KEYS",
+	GET_API_KEY_FOR_AUTH:             "GET_API_KEY_FOR_AUTH",
+	TOUCH_API_KEY:                    "TOUCH_API_KEY",
+	REVOKE_API_KEY:                   "REVOKE_API_KEY",
+	CREATE_ADMIN_SESSION:             "CREATE_ADMIN_SESSION",
+	GET_SESSION_FOR_AUTH:             "GET_SESSION_FOR_AUTH",
+	TOUCH_ADMIN_SESSION:              "TOUCH_ADMIN_SESSION",
+	GET_ADMIN_SESSIONS:               "GET_ADMIN_SESSIONS",
+	REVOKE_ADMIN_SESSION:             "REVOKE_ADMIN_SESSION",
+	REVOKE_ALL_ADMIN_SESSIONS:        "REVOKE_ALL_ADMIN_SESSIONS",
+	GET_SCHEMA_VERSION:               "GET_SCHEMA_VERSION",
+	GET_PORTFOLIO_METRICS:            "GET_PORTFOLIO_METRICS",
+	GET_COLLECTIONS_TODAY_METRICS:    "GET_COLLECTIONS_TODAY_METRICS",
+	GET_WRITE_OFF_METRICS:            "GET_WRITE_OFF_METRICS",
+	FIND_DUPLICATE_CUSTOMERS:         "FIND_DUPLICATE_CUSTOMERS",
+	GET_DUPLICATE_CUSTOMERS:          "GET_DUPLICATE_CUSTOMERS",
+	LOCK_MERGE_CUSTOMERS:             "LOCK_MERGE_CUSTOMERS",
+	CHECK_MERGE_PARTY_CONFLICT:       "CHECK_MERGE_PARTY_CONFLICT",
+	MERGE_CUSTOMER_HANDOUTS:          "MERGE_CUSTOMER_HANDOUTS",
+	MERGE_CUSTOMER_DOCUMENTS:         "MERGE_CUSTOMER_DOCUMENTS",
+	MERGE_CUSTOMER_PARTIES:           "MERGE_CUSTOMER_PARTIES",
+	MERGE_CUSTOMER_DUPLICATE_REWARDS: "MERGE_CUSTOMER_DUPLICATE_REWARDS",
+	MERGE_CUSTOMER_REWARDS:           "MERGE_CUSTOMER_REWARDS",
+	MERGE_CUSTOMER_OVERRIDES:         "MERGE_CUSTOMER_OVERRIDES",
+	MERGE_CUSTOMER_TRANSFERS:         "MERGE_CUSTOMER_TRANSFERS",
+	MERGE_CUSTOMER_REFERRAL_CHANGES:  "MERGE_CUSTOMER_REFERRAL_CHANGES",
+	MERGE_CUSTOMER_MERGES:            "MERGE_CUSTOMER_MERGES",
+	MERGE_CUSTOMER_REFERRALS:         "MERGE_CUSTOMER_REFERRALS",
+	MERGE_REFERRAL_CHANGE_REFERRERS:  "MERGE_REFERRAL_CHANGE_REFERRERS",
+	CREATE_CUSTOMER_MERGE:            "CREATE_CUSTOMER_MERGE",
+	GET_CUSTOMER_MERGES:              "GET_CUSTOMER_MERGES",
+	GET_ELIGIBILITY_RULES:            "GET_ELIGIBILITY_RULES",
+	UPDATE_ELIGIBILITY_RULES:         "UPDATE_ELIGIBILITY_RULES",
+	GET_CUSTOMER_CREDIT:              "GET_CUSTOMER_CREDIT",
+	GET_CUSTOMER_MISSING_KYC:         "GET_CUSTOMER_MISSING_KYC",
+	UPDATE_CUSTOMER_CREDIT_LIMIT:     "UPDATE_CUSTOMER_CREDIT_LIMIT",
+	LOCK_HANDOUT_BALANCE:             "LOCK_HANDOUT_BALANCE",
+	CREATE_ELIGIBILITY_OVERRIDE:      "CREATE_ELIGIBILITY_OVERRIDE",
+	GET_RISK_FACTORS:                 "GET_RISK_FACTORS",
+	UPSERT_RISK_SCORE:                "UPSERT_RISK_SCORE",
+	DELETE_RISK_SCORE:                "DELETE_RISK_SCORE",
+	GET_RISK_SCORE:                   "GET_RISK_SCORE",
+	GET_HANDOUT_CUSTOMER:             "GET_HANDOUT_CUSTOMER",
+	GET_COLLECTION_CUSTOMER:          "GET_COLLECTION_CUSTOMER",
+	GET_PENALTY_RULES:                "GET_PENALTY_RULES",
+	UPDATE_PENALTY_RULES:             "UPDATE_PENALTY_RULES",
+	GET_HANDOUT_SCHEDULE:             "GET_HANDOUT_SCHEDULE",
+	UPDATE_FIRST_SCHEDULE:            "UPDATE_FIRST_SCHEDULE",
+	CREATE_HANDOUT_SCHEDULE:          "CREATE_HANDOUT_SCHEDULE",
+	GET_SCHEDULE_LOCKS:               "GET_SCHEDULE_LOCKS",
+	CHECK_HANDOUT_SCHEDULE:           "CHECK_HANDOUT_SCHEDULE",
+	CREATE_HANDOUT_RESTRUCTURE:       "CREATE_HANDOUT_RESTRUCTURE",
+	GET_HANDOUT_RESTRUCTURES:         "GET_HANDOUT_RESTRUCTURES",
+	ADD_HANDOUT_TOP_UP:               "ADD_HANDOUT_TOP_UP",
+	GET_PENALTY_HANDOUTS:             "GET_PENALTY_HANDOUTS",
+	GET_PAYMENTS:                     "GET_PAYMENTS",
+	GET_HANDOUT_PENALTIES:            "GET_HANDOUT_PENALTIES",
+	CREATE_PENALTY:                   "CREATE_PENALTY",
+	GET_PENALTY_WAIVERS:              "GET_PENALTY_WAIVERS",
+	CREATE_PENALTY_WAIVER:            "CREATE_PENALTY_WAIVER",
+	GET_HANDOUT_BALANCE:              "GET_HANDOUT_BALANCE",
+	GET_CUSTOMER_STATEMENT:           "GET_CUSTOMER_STATEMENT",
+	CREATE_HANDOUT_WRITE_OFF:         "CREATE_HANDOUT_WRITE_OFF",
+	WRITE_OFF_HANDOUT:                "WRITE_OFF_HANDOUT",
+	GET_WRITE_OFFS:                   "GET_WRITE_OFFS",
+	GET_SETTLEMENT_POLICY:            "GET_SETTLEMENT_POLICY",
+	UPDATE_SETTLEMENT_POLICY:         "UPDATE_SETTLEMENT_POLICY",
+	CREATE_HANDOUT_SETTLEMENT:        "CREATE_HANDOUT_SETTLEMENT",
+	GET_HANDOUT_SETTLEMENT:           "GET_HANDOUT_SETTLEMENT",
+	GET_HANDOUT_SETTLED:              "GET_HANDOUT_SETTLED",
+	COMPLETE_HANDOUT:                 "COMPLETE_HANDOUT",
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultReferralDepth and maxReferralDepth bound the downline levels of GET /customers/{id}/referrals
+	defaultReferralDepth = 3
+	maxReferralDepth     = 10
+	// referralUplineLimit stops walking up a referral chain after this many referrers
+	referralUplineLimit = 50
+)
+
+// ReferralCustomer is a customer in a referral tree with the loans they took
+type ReferralCustomer struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Mobile     int    `json:"mobile"`
+	BranchID   int    `json:"branchId"`
+	ReferredBy int    `json:"referredBy"`
+	Depth      int    `json:"depth"` // levels away from the customer the tree is for
+	Handouts   int    `json:"handouts"`
+	LoanVolume Money  `json:"loanVolume"` // sum of handouts that were not CANCELLED
+}
+
+// ReferralNode is a customer in the downline with the customers they referred
+type ReferralNode struct {
+	ReferralCustomer
+	Referrals []*ReferralNode `json:"referrals"`
+}
+
+type ReferralTree struct {
+	CustomerID int                `json:"customerId"`
+	Upline     []ReferralCustomer `json:"upline"` // nearest referrer first
+	Downline   []*ReferralNode    `json:"downline"`
+	Depth      int                `json:"depth"`
+	// Truncated is true when the downline goes deeper than Depth
+	Truncated bool `json:"truncated"`
+	// The counts and sums cover the downline returned
+	DirectReferrals    int   `json:"directReferrals"`
+	TotalReferrals     int   `json:"totalReferrals"`
+	ReferredHandouts   int   `json:"referredHandouts"`
+	ReferredLoanVolume Money `json:"referredLoanVolume"`
+}
+
+// ReferralChange is one entry of a customer's referral history. Referrer IDs
+// are 0 for no referrer, so unlinking has ReferredBy 0.
+type ReferralChange struct {
+	ID                 int       `json:"id"`
+	CustomerID         int       `json:"customerId"`
+	PreviousReferredBy int       `json:"previousReferredBy"`
+	ReferredBy         int       `json:"referredBy"`
+	Reason             string    `json:"reason"`
+	ChangedBy          int       `json:"changedBy"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// buildReferralTree nests the downline rows of GET_REFERRAL_DOWNLINE, which
+// come ordered by depth, under their referrers. Rows deeper than depth are
+// left out and reported as truncated.
+func buildReferralTree(rootID, depth int, rows []ReferralCustomer) (tree ReferralTree) {
+	tree = ReferralTree{CustomerID: rootID, Depth: depth, Downline: []*ReferralNode{}}
+	nodes := map[int]*ReferralNode{}
+
+	for _, row := range rows {
+		if row.Depth > depth {
+			tree.Truncated = true
+			continue
+		}
+		node := &ReferralNode{ReferralCustomer: row, Referrals: []*ReferralNode{}}
+
+		if row.Depth == 1 {
+			tree.Downline = append(tree.Downline, node)
+			tree.DirectReferrals++
+		} else if parent, ok := nodes[row.ReferredBy]; ok {
+			parent.Referrals = append(parent.Referrals, node)
+		} else {
+			// Not reachable from the customer, e.g. a row of a legacy referral cycle
+			continue
+		}
+		nodes[row.ID] = node
+		tree.TotalReferrals++
+		tree.ReferredHandouts += row.Handouts
+		tree.ReferredLoanVolume += row.LoanVolume
+	}
+	return tree
+}
+
+func scanReferralCustomer(row interface{ Scan(...any) error }) (customer ReferralCustomer, err error) {
+	err = row.Scan(&customer.ID, &customer.Name, &customer.Mobile, &customer.BranchID, &customer.ReferredBy,
+		&customer.Depth, &customer.Handouts, &customer.LoanVolume)
+	return customer, err
+}
+
+// getCustomerReferrals writes the referral tree of a customer: who referred
+// them up the chain and everyone they referred, down to ?depth= levels
+func getCustomerReferrals(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	depth := defaultReferralDepth
+	if raw := r.URL.Query().Get("depth"); raw != "" {
+		depth, err = strconv.Atoi(raw)
+		if err != nil || depth < 1 || depth > maxReferralDepth {
+			sendValidationErrors(w, []FieldError{{Field: "depth", Code: INVALID_VALUE, Message: "depth must be between 1 and " + strconv.Itoa(maxReferralDepth)}})
+			return
+		}
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	downline, err := queryReferralCustomers(r, GET_REFERRAL_DOWNLINE, customerID, depth)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	tree := buildReferralTree(customerID, depth, downline)
+
+	tree.Upline, err = queryReferralCustomers(r, GET_REFERRAL_UPLINE, customerID, referralUplineLimit)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[ReferralTree]{
+		D:   tree,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// queryReferralCustomers runs GET_REFERRAL_DOWNLINE or GET_REFERRAL_UPLINE in the caller's branch
+func queryReferralCustomers(r *http.Request, query string, customerID, depth int) ([]ReferralCustomer, error) {
+	rows, err := db.QueryContext(r.Context(), query, customerID, depth, branchScope(r))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []ReferralCustomer{}
+	for rows.Next() {
+		customer, err := scanReferralCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+// changeCustomerReferral sets who referred a customer, referrerID 0 unlinks
+// them, and records the change in the referral history. A customer cannot be
+// referred by anyone in their own downline.
+func changeCustomerReferral(w http.ResponseWriter, r *http.Request, customerID, referrerID int, reason, successMsg string) {
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(LOCK_REFERRALS); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	var previous int
+	err = tx.QueryRow(LOCK_CUSTOMER_REFERRAL, customerID, branchScope(r)).Scan(&previous)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if referrerID == 0 && previous == 0 {
+		sendError(w, http.StatusNotFound, NO_REFERRER, "Customer has no referrer")
+		return
+	}
+
+	if referrerID != 0 {
+		var exists, cycle bool
+		if err := tx.QueryRow(CHECK_CUSTOMER_EXISTS, referrerID, branchScope(r)).Scan(&exists); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		if !exists {
+			sendError(w, http.StatusNotFound, REFERRER_NOT_FOUND, REFERRER_NOT_FOUND_MSG)
+			return
+		}
+
+		if err := tx.QueryRow(CHECK_REFERRAL_CYCLE, referrerID, customerID).Scan(&cycle); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		if cycle {
+			sendError(w, http.StatusConflict, REFERRAL_CYCLE, "Customer "+strconv.Itoa(referrerID)+" was referred by this customer, directly or through others")
+			return
+		}
+	}
+
+	// Linking the same referrer again changes nothing and is not recorded
+	if referrerID != previous {
+		if _, err := tx.Exec(UPDATE_CUSTOMER_REFERRAL, referrerID, customerID, branchScope(r)); err != nil {
+			sendDBError(w, r, err)
+			return
+		}
+
+		changedBy, _ := r.Context().Value("adminID").(int)
+		if _, err := tx.Exec(CREATE_REFERRAL_CHANGE, customerID, previous, referrerID, reason, changedBy); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	resp := MsgResp{
+		Msg: successMsg,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// unlinkCustomerReferral removes a customer's referrer, ?reason= is kept in the history
+func unlinkCustomerReferral(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	changeCustomerReferral(w, r, customerID, 0, r.URL.Query().Get("reason"), "Customer referral unlinked successfully")
+}
+
+func getCustomerReferralHistory(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_REFERRAL_CHANGES, customerID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	changes := []ReferralChange{}
+	for rows.Next() {
+		var change ReferralChange
+		err := rows.Scan(&change.ID, &change.CustomerID, &change.PreviousReferredBy, &change.ReferredBy,
+			&change.Reason, &change.ChangedBy, &change.CreatedAt)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		changes = append(changes, change)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]ReferralChange]{
+		D:   changes,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
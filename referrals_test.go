@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestBuildReferralTree(t *testing.T) {
+	// 1 referred 2 and 3, 2 referred 4, 4 referred 5 (one level too deep)
+	rows := []ReferralCustomer{
+		{ID: 2, ReferredBy: 1, Depth: 1, Handouts: 2, LoanVolume: 5000000},
+		{ID: 3, ReferredBy: 1, Depth: 1},
+		{ID: 4, ReferredBy: 2, Depth: 2, Handouts: 1, LoanVolume: 1000000},
+		{ID: 5, ReferredBy: 4, Depth: 3, Handouts: 1, LoanVolume: 700000},
+	}
+
+	tree := buildReferralTree(1, 2, rows)
+
+	if len(tree.Downline) != 2 || tree.Downline[0].ID != 2 || tree.Downline[1].ID != 3 {
+		t.Fatalf("unexpected direct referrals: %+v", tree.Downline)
+	}
+	if children := tree.Downline[0].Referrals; len(children) != 1 || children[0].ID != 4 || len(children[0].Referrals) != 0 {
+		t.Errorf("customer 4 should be the only referral of 2, got %+v", children)
+	}
+	if tree.Downline[1].Referrals == nil {
+		t.Error("leaves should have an empty list of referrals, not nil")
+	}
+	if !tree.Truncated {
+		t.Error("expected the tree to be truncated at depth 2")
+	}
+	if tree.DirectReferrals != 2 || tree.TotalReferrals != 3 || tree.ReferredHandouts != 3 || tree.ReferredLoanVolume != 6000000 {
+		t.Errorf("unexpected totals: direct %d, total %d, handouts %d, volume %d",
+			tree.DirectReferrals, tree.TotalReferrals, tree.ReferredHandouts, tree.ReferredLoanVolume)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+// securityHeaders caps request bodies at HTTP_MAX_BODY_BYTES (HTTP_MAX_UPLOAD_BYTES
+// for multipart uploads) and adds the browser hardening headers to every response
+func securityHeaders(cfg config.ServerConfig, next http.Handler) http.Handler {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+
+		if r.Body != nil {
+			limit := cfg.MaxBodyBytes
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+				limit = cfg.MaxUploadBytes
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkJSONContentType rejects bodies that are not declared as application/json
+func checkJSONContentType(r *http.Request) *APIError {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return newAPIError(http.StatusUnsupportedMediaType, UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json")
+	}
+	return nil
+}
+
+// decodeJSON strictly decodes the request body into dst: the body must be a
+// single JSON object of declared fields only. String fields are trimmed unless
+// tagged `trim:"false"`. It writes the error response and returns false on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if apiErr := checkJSONContentType(r); apiErr != nil {
+		sendAPIError(w, apiErr)
+		return false
+	}
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		sendAPIError(w, decodeError(err))
+		return false
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		sendError(w, http.StatusBadRequest, INVALID_JSON, "Request body must contain a single JSON object")
+		return false
+	}
+
+	trimStrings(reflect.ValueOf(dst))
+	return true
+}
+
+// decodeError describes why a body could not be decoded without echoing raw input
+func decodeError(err error) *APIError {
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		return newAPIError(http.StatusRequestEntityTooLarge, PAYLOAD_TOO_LARGE,
+			"Request body must not exceed "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+	case errors.Is(err, io.EOF):
+		return newAPIError(http.StatusBadRequest, INVALID_JSON, "Request body is empty")
+	case errors.As(err, &typeErr):
+		apiErr := newAPIError(http.StatusBadRequest, VALIDATION_FAILED, VALIDATION_FAILED_MSG)
+		apiErr.Fields = []FieldError{{Field: typeErr.Field, Code: INVALID_VALUE, Message: "must be a " + jsonTypeName(typeErr.Type)}}
+		return apiErr
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		apiErr := newAPIError(http.StatusBadRequest, VALIDATION_FAILED, VALIDATION_FAILED_MSG)
+		apiErr.Fields = []FieldError{{Field: field, Code: UNKNOWN_FIELD, Message: "unknown field"}}
+		return apiErr
+	}
+
+	apiErr := newAPIError(http.StatusBadRequest, INVALID_JSON, INVALID_JSON_MSG)
+	apiErr.Details = err.Error()
+	return apiErr
+}
+
+func jsonTypeName(t reflect.Type) string {
+	if t == moneyType {
+		return "number with at most two decimal places"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice:
+		return "list"
+	case reflect.String:
+		return "string"
+	}
+	if t.String() == "time.Time" {
+		return "RFC 3339 date-time string"
+	}
+	return "JSON " + t.Kind().String()
+}
+
+// trimStrings removes surrounding whitespace from every string reachable from v
+func trimStrings(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			trimStrings(v.Elem())
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(strings.TrimSpace(v.String()))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			trimStrings(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.IsExported() && field.Tag.Get("trim") != "false" {
+				trimStrings(v.Field(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(contentType, body string, dst any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		decodeJSON(rec, req, dst)
+		return rec
+	}
+
+	var login LoginRequest
+	if rec := decode("application/json", `{"username": " admin ", "password": " secret "}`, &login); rec.Code != http.StatusOK {
+		t.Fatalf("valid body rejected: %s", rec.Body.String())
+	}
+	if login.Username != "admin" || login.Password != " secret " {
+		t.Errorf("username should be trimmed and password kept, got %q %q", login.Username, login.Password)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"unknown field", "application/json", `{"handout": 3}`, http.StatusBadRequest, `"UNKNOWN_FIELD"`},
+		{"wrong type", "application/json", `{"amount": "ten"}`, http.StatusBadRequest, `"VALIDATION_FAILED"`},
+		{"two objects", "application/json", `{} {}`, http.StatusBadRequest, `"INVALID_JSON"`},
+		{"empty body", "application/json", ``, http.StatusBadRequest, `"INVALID_JSON"`},
+		{"form body", "application/x-www-form-urlencoded", `amount=10`, http.StatusUnsupportedMediaType, `"UNSUPPORTED_MEDIA_TYPE"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var collection Collection
+			rec := decode(tt.contentType, tt.body, &collection)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.code) {
+				t.Errorf("got %d %s, want %d with %s", rec.Code, rec.Body.String(), tt.status, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Restructure operations, mirroring the restructure_operation enum in sql/migration-20.sql
+const (
+	RESTRUCTURE_EXTEND_TENURE      = "EXTEND_TENURE"
+	RESTRUCTURE_CHANGE_RATE        = "CHANGE_RATE"
+	RESTRUCTURE_CAPITALIZE_ARREARS = "CAPITALIZE_ARREARS"
+	RESTRUCTURE_TOP_UP             = "TOP_UP"
+)
+
+// HandoutRestructure links a schedule version to the one it replaced. The
+// new principal is what was left of the old one, the capitalized arrears and
+// the top-up.
+type HandoutRestructure struct {
+	ID                   int       `json:"id"`
+	HandoutID            int       `json:"handoutId"`
+	FromVersion          int       `json:"fromVersion"`
+	ToVersion            int       `json:"toVersion"`
+	Operations           []string  `json:"operations"`
+	RemainingPrincipal   Money     `json:"remainingPrincipal"`
+	CapitalizedInterest  Money     `json:"capitalizedInterest"`
+	CapitalizedPenalties Money     `json:"capitalizedPenalties"`
+	TopUp                Money     `json:"topUp"`
+	UnearnedInterest     Money     `json:"unearnedInterest"` // interest of installments not due yet, no longer owed
+	Reason               string    `json:"reason"`
+	RestructuredBy       int       `json:"restructuredBy"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// RestructureRequest changes the terms of a handout's schedule. Omitted terms
+// are kept: the installments left, the rate and the frequency. The operations
+// follow from what is changed.
+type RestructureRequest struct {
+	Installments      *int     `json:"installments"`
+	AnnualRate        *float64 `json:"annualRate"`
+	CapitalizeArrears bool     `json:"capitalizeArrears"`
+	TopUp             Money    `json:"topUp"`
+	FirstDueDate      string   `json:"firstDueDate"` // YYYY-MM-DD, the next unpaid due date by default
+	Reason            string   `json:"reason"`
+	// A top-up is a disbursement, an admin can make it to a customer that
+	// fails eligibility rules like a new handout
+	OverrideEligibility bool   `json:"overrideEligibility,omitempty"`
+	OverrideReason      string `json:"overrideReason,omitempty"`
+}
+
+type RestructureResult struct {
+	Restructure HandoutRestructure `json:"restructure"`
+	Schedule    RepaymentSchedule  `json:"schedule"`
+}
+
+// validateRestructure returns every invalid field of a restructure request
+// and the first due date, zero when omitted
+func validateRestructure(req RestructureRequest) ([]FieldError, time.Time) {
+	var fields []FieldError
+
+	if req.Installments != nil && (*req.Installments < 1 || *req.Installments > maxInstallments) {
+		fields = append(fields, FieldError{Field: "installments", Code: INVALID_VALUE, Message: "installments must be between 1 and " + strconv.Itoa(maxInstallments)})
+	}
+	if req.AnnualRate != nil {
+		if _, ok := percentBasisPoints(*req.AnnualRate); !ok {
+			fields = append(fields, FieldError{Field: "annualRate", Code: INVALID_VALUE, Message: "must be between 0 and 100 with at most two decimals"})
+		}
+	}
+	if req.TopUp < 0 {
+		fields = append(fields, FieldError{Field: "topUp", Code: INVALID_VALUE, Message: "cannot be negative"})
+	}
+	if req.Reason == "" {
+		fields = append(fields, FieldError{Field: "reason", Code: REQUIRED, Message: "a reason is required to restructure a handout"})
+	}
+	if req.OverrideEligibility && req.OverrideReason == "" {
+		fields = append(fields, FieldError{Field: "overrideReason", Code: REQUIRED, Message: "a reason is required to override eligibility rules"})
+	}
+
+	var firstDueDate time.Time
+	if req.FirstDueDate != "" {
+		var fieldErr *FieldError
+		if firstDueDate, fieldErr = parseEffectiveDate("firstDueDate", req.FirstDueDate); fieldErr != nil {
+			fields = append(fields, *fieldErr)
+		}
+	}
+	return fields, firstDueDate
+}
+
+// restructureTerms works out the next version of current, whose installments
+// are paid as of asOf, and how it came about. Interest of the installments
+// not due yet is dropped, the new version charges its own on what is carried
+// over. An omitted first due date is the next unpaid one, or a period after
+// asOf when that has passed.
+func restructureTerms(current RepaymentSchedule, balance HandoutBalance, req RestructureRequest, firstDueDate, asOf time.Time) (HandoutRestructure, RepaymentSchedule, []FieldError) {
+	restructure := HandoutRestructure{HandoutID: current.HandoutID, FromVersion: current.Version,
+		ToVersion: current.Version + 1, Operations: []string{}, TopUp: req.TopUp, Reason: req.Reason}
+
+	var remaining int
+	var paid Money
+	var nextDue time.Time
+	for _, item := range current.Schedule {
+		paid += item.Paid
+		if item.Status == INSTALLMENT_PAID {
+			continue
+		}
+		interestPaid := min(item.Paid, item.Interest)
+		restructure.RemainingPrincipal += item.Principal - (item.Paid - interestPaid)
+		if item.Status == INSTALLMENT_OVERDUE {
+			restructure.CapitalizedInterest += item.Interest - interestPaid
+		} else {
+			restructure.UnearnedInterest += item.Interest - interestPaid
+		}
+		if remaining == 0 {
+			nextDue = item.DueDate
+		}
+		remaining++
+	}
+
+	var fields []FieldError
+	installments := remaining
+	if req.Installments != nil {
+		if *req.Installments <= remaining {
+			fields = append(fields, FieldError{Field: "installments", Code: INVALID_VALUE,
+				Message: "must be more than the " + strconv.Itoa(remaining) + " installments left"})
+		}
+		installments = *req.Installments
+		restructure.Operations = append(restructure.Operations, RESTRUCTURE_EXTEND_TENURE)
+	}
+
+	rate := current.AnnualRate
+	if req.AnnualRate != nil && *req.AnnualRate != current.AnnualRate {
+		rate = *req.AnnualRate
+		restructure.Operations = append(restructure.Operations, RESTRUCTURE_CHANGE_RATE)
+	}
+
+	if req.CapitalizeArrears {
+		restructure.CapitalizedPenalties = balance.PenaltiesDue
+		if restructure.CapitalizedInterest == 0 && restructure.CapitalizedPenalties == 0 && balance.Overdue == 0 {
+			fields = append(fields, FieldError{Field: "capitalizeArrears", Code: INVALID_VALUE, Message: "the handout has no arrears to capitalize"})
+		}
+		restructure.Operations = append(restructure.Operations, RESTRUCTURE_CAPITALIZE_ARREARS)
+	} else {
+		// Not carried over, the caller refuses a handout in arrears
+		restructure.CapitalizedInterest = 0
+	}
+
+	if req.TopUp > 0 {
+		restructure.Operations = append(restructure.Operations, RESTRUCTURE_TOP_UP)
+	}
+	if len(restructure.Operations) == 0 {
+		fields = append(fields, FieldError{Field: "operations", Code: REQUIRED,
+			Message: "extend the tenure, change the rate, capitalize arrears or add a top-up"})
+	}
+
+	principal := restructure.RemainingPrincipal + restructure.CapitalizedInterest + restructure.CapitalizedPenalties + req.TopUp
+	if installments < 1 || principal < Money(installments) {
+		fields = append(fields, FieldError{Field: "installments", Code: INVALID_VALUE, Message: "more installments than paise left to repay"})
+	}
+
+	if firstDueDate.IsZero() {
+		firstDueDate = nextDue
+		if !nextDue.After(asOf) {
+			firstDueDate = dueDate(asOf, current.Frequency, 2)
+		}
+	}
+
+	next := RepaymentSchedule{
+		HandoutID:           current.HandoutID,
+		Version:             restructure.ToVersion,
+		Principal:           principal,
+		AnnualRate:          rate,
+		Interest:            flatInterest(principal, rate, installments, current.Frequency),
+		Installments:        installments,
+		Frequency:           current.Frequency,
+		FirstDueDate:        firstDueDate,
+		CollectedBefore:     balance.Collected,
+		PenaltiesPaidBefore: current.PenaltiesPaidBefore + max(balance.Collected-current.CollectedBefore-paid, 0),
+	}
+	return restructure, next, fields
+}
+
+func scanRestructure(row interface{ Scan(...any) error }) (restructure HandoutRestructure, err error) {
+	err = row.Scan(&restructure.ID, &restructure.HandoutID, &restructure.FromVersion, &restructure.ToVersion,
+		pq.Array(&restructure.Operations), &restructure.RemainingPrincipal, &restructure.CapitalizedInterest,
+		&restructure.CapitalizedPenalties, &restructure.TopUp, &restructure.UnearnedInterest, &restructure.Reason,
+		&restructure.RestructuredBy, &restructure.CreatedAt)
+	return restructure, err
+}
+
+// restructureHandout replaces the terms of a handout's schedule with a new
+// version. The old one is kept and linked to it, a top-up is added to the
+// handout amount as a further disbursement. A handout in arrears can only be
+// restructured by capitalizing them.
+func restructureHandout(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req RestructureRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	fields, firstDueDate := validateRestructure(req)
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locked so no collection, waiver or accrual changes the balance meanwhile
+	var customerID int
+	var amount, collected Money
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, branchScope(r)).Scan(&customerID, &amount, &status, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if status != "ACTIVE" && status != "PENDING" {
+		sendError(w, http.StatusConflict, HANDOUT_NOT_ACTIVE, "Only an active or pending handout can be restructured")
+		return
+	}
+
+	current, err := loadSchedule(tx, handoutID, 0, 0)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, SCHEDULE_NOT_FOUND, "Handout has no repayment schedule")
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	balance, err := loadHandoutBalance(tx, handoutID, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !req.CapitalizeArrears && (balance.Overdue > 0 || balance.PenaltiesDue > 0) {
+		apiErr := newAPIError(http.StatusConflict, HANDOUT_IN_ARREARS,
+			"The handout is in arrears, capitalize them to restructure it")
+		apiErr.Details = balance
+		sendAPIError(w, apiErr)
+		return
+	}
+
+	restructure, next, fields := restructureTerms(current, balance, req, firstDueDate, today())
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	// A top-up is checked like any disbursement, on what the customer will owe
+	// on the handout
+	handout := HandoutUpdate{Amount: amount + req.TopUp, CustomerId: customerID,
+		OverrideEligibility: req.OverrideEligibility, OverrideReason: req.OverrideReason}
+	var failedRules []string
+	if req.TopUp > 0 {
+		var locked int
+		if err := tx.QueryRow(LOCK_CUSTOMER, customerID, 0).Scan(&locked); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		var ok bool
+		if failedRules, ok = checkEligibility(w, r, tx, handout, handoutID, next.Principal); !ok {
+			return
+		}
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	err = tx.QueryRow(CREATE_HANDOUT_SCHEDULE, handoutID, next.Version, next.Principal, next.AnnualRate, next.Interest,
+		next.Installments, next.Frequency, next.FirstDueDate, next.CollectedBefore, next.PenaltiesPaidBefore, adminID).Scan(&next.ID)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	restructure.RestructuredBy = adminID
+	err = tx.QueryRow(CREATE_HANDOUT_RESTRUCTURE, handoutID, current.ID, next.ID, pq.Array(restructure.Operations),
+		restructure.RemainingPrincipal, restructure.CapitalizedInterest, restructure.CapitalizedPenalties,
+		restructure.TopUp, restructure.UnearnedInterest, restructure.Reason, adminID).Scan(&restructure.ID, &restructure.CreatedAt)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	if req.TopUp > 0 {
+		if _, err := tx.Exec(ADD_HANDOUT_TOP_UP, handoutID, req.TopUp); err != nil {
+			sendDBError(w, r, err)
+			return
+		}
+	}
+	if len(failedRules) > 0 {
+		if err := recordEligibilityOverride(tx, r, handoutID, handout, next.Principal, failedRules); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	schedule, err := loadSchedule(tx, handoutID, 0, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[RestructureResult]{
+		D:   RestructureResult{Restructure: restructure, Schedule: schedule},
+		Msg: "Handout restructured successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getHandoutRestructures lists the restructures of a handout oldest first,
+// each schedule version they link is at /handouts/{id}/schedule?version=
+func getHandoutRestructures(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_HANDOUT_RESTRUCTURES, handoutID, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	restructures := []HandoutRestructure{}
+	for rows.Next() {
+		restructure, err := scanRestructure(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		restructures = append(restructures, restructure)
+	}
+	if err := rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]HandoutRestructure]{
+		D:   restructures,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
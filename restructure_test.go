@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestructureTerms(t *testing.T) {
+	// The first installment paid and 5.00 of the second
+	asOf := date("2026-02-20")
+	current := monthlySchedule(26500, asOf)
+	balance := HandoutBalance{Status: "ACTIVE", Collected: 26500, PenaltiesDue: 700, Overdue: 25500}
+	installments := func(n int) *int { return &n }
+	rate := func(r float64) *float64 { return &r }
+
+	tests := []struct {
+		name         string
+		req          RestructureRequest
+		operations   []string
+		principal    Money
+		installments int
+		firstDueDate string
+		wantFields   []string
+	}{
+		// 750.00 principal left, 5.00 overdue interest and 7.00 of penalties
+		{"capitalize arrears", RestructureRequest{CapitalizeArrears: true},
+			[]string{RESTRUCTURE_CAPITALIZE_ARREARS}, 75000 + 500 + 700, 3, "2026-03-20", nil},
+		{"extend and top up", RestructureRequest{Installments: installments(6), CapitalizeArrears: true, TopUp: 20000},
+			[]string{RESTRUCTURE_EXTEND_TENURE, RESTRUCTURE_CAPITALIZE_ARREARS, RESTRUCTURE_TOP_UP}, 75000 + 500 + 700 + 20000, 6, "2026-03-20", nil},
+		{"same rate changes nothing", RestructureRequest{AnnualRate: rate(12)}, nil, 75000, 3, "2026-03-20", []string{"operations"}},
+		{"fewer installments", RestructureRequest{Installments: installments(3), AnnualRate: rate(10)},
+			[]string{RESTRUCTURE_EXTEND_TENURE, RESTRUCTURE_CHANGE_RATE}, 75000, 3, "2026-03-20", []string{"installments"}},
+		{"first due date given", RestructureRequest{AnnualRate: rate(0), FirstDueDate: "2026-04-01"},
+			[]string{RESTRUCTURE_CHANGE_RATE}, 75000, 3, "2026-04-01", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var firstDueDate time.Time
+			if tt.req.FirstDueDate != "" {
+				firstDueDate = date(tt.req.FirstDueDate)
+			}
+			restructure, next, fields := restructureTerms(current, balance, tt.req, firstDueDate, asOf)
+
+			var got []string
+			for _, field := range fields {
+				got = append(got, field.Field)
+			}
+			if !slices.Equal(got, tt.wantFields) {
+				t.Fatalf("expected invalid fields %v, got %+v", tt.wantFields, fields)
+			}
+			if len(fields) > 0 {
+				return
+			}
+			if !slices.Equal(restructure.Operations, tt.operations) {
+				t.Errorf("expected operations %v, got %v", tt.operations, restructure.Operations)
+			}
+			if next.Principal != tt.principal || next.Installments != tt.installments || !next.FirstDueDate.Equal(date(tt.firstDueDate)) {
+				t.Errorf("expected %s over %d installments from %s, got %+v", tt.principal, tt.installments, tt.firstDueDate, next)
+			}
+			if next.Version != 2 || next.CollectedBefore != 26500 || restructure.RemainingPrincipal != 75000 || restructure.UnearnedInterest != 2000 {
+				t.Errorf("unexpected carry over %+v into %+v", restructure, next)
+			}
+			if want := flatInterest(next.Principal, next.AnnualRate, next.Installments, next.Frequency); next.Interest != want {
+				t.Errorf("expected %s interest, got %s", want, next.Interest)
+			}
+		})
+	}
+}
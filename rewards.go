@@ -0,0 +1,554 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Reward kinds, mirroring the reward_kind enum in sql/migration-15.sql
+const (
+	REWARD_DISBURSEMENT_PERCENT = "DISBURSEMENT_PERCENT"
+	REWARD_COMPLETION_BONUS     = "COMPLETION_BONUS"
+)
+
+// maxRewardLevel is the furthest referrer up the chain a rule can pay
+const maxRewardLevel = 5
+
+// ReferralRewardRule says what the referrer at Level earns on a handout of
+// someone they brought in: Percent of the disbursed amount, or a fixed Amount
+// once the handout is COMPLETED
+type ReferralRewardRule struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Kind          string    `json:"kind"`
+	Level         int       `json:"level"`
+	Percent       *float64  `json:"percent"` // DISBURSEMENT_PERCENT only
+	Amount        *Money    `json:"amount"`  // COMPLETION_BONUS only
+	Active        bool      `json:"active"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	CreatedBy     int       `json:"createdBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type CreateRewardRuleRequest struct {
+	Name          string   `json:"name"`
+	Kind          string   `json:"kind"`
+	Level         int      `json:"level"`
+	Percent       *float64 `json:"percent"`
+	Amount        *Money   `json:"amount"`
+	Active        *bool    `json:"active"`        // defaults to true
+	EffectiveFrom string   `json:"effectiveFrom"` // YYYY-MM-DD, defaults to today
+}
+
+// UpdateRewardRuleRequest changes the given fields. Rewards already accrued
+// are not recalculated. Kind and level are fixed, add a new rule instead.
+type UpdateRewardRuleRequest struct {
+	Name          *string  `json:"name"`
+	Percent       *float64 `json:"percent"`
+	Amount        *Money   `json:"amount"`
+	Active        *bool    `json:"active"`
+	EffectiveFrom *string  `json:"effectiveFrom"`
+}
+
+// RewardEntry is a line of the referral reward ledger. Accruals are positive,
+// clawbacks and payouts negative.
+type RewardEntry struct {
+	ID               int       `json:"id"`
+	ReferrerID       int       `json:"referrerId"`
+	Type             string    `json:"type"`
+	Amount           Money     `json:"amount"`
+	HandoutID        int       `json:"handoutId"`  // 0 for payouts
+	RuleID           int       `json:"ruleId"`     // 0 for payouts
+	Level            int       `json:"level"`      // 0 for payouts
+	ReversesID       int       `json:"reversesId"` // the accrual a clawback reverses
+	PaymentMethod    string    `json:"paymentMethod"`
+	PaymentReference string    `json:"paymentReference"`
+	Description      string    `json:"description"`
+	EntryDate        time.Time `json:"entryDate"`
+	CreatedBy        int       `json:"createdBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// RewardBalance sums up a referrer's ledger
+type RewardBalance struct {
+	Earned     Money `json:"earned"`
+	ClawedBack Money `json:"clawedBack"`
+	Paid       Money `json:"paid"`
+	// Balance is still to be paid, negative when more was paid than is kept after clawbacks
+	Balance Money `json:"balance"`
+}
+
+type ReferrerRewards struct {
+	RewardBalance
+	Entries []RewardEntry `json:"entries"`
+}
+
+type ReferrerBalance struct {
+	Referrer HandoutCustomerDetails `json:"referrer"`
+	BranchID int                    `json:"branchId"`
+	RewardBalance
+}
+
+type RewardPayoutRequest struct {
+	Amount      Money  `json:"amount"`
+	Method      string `json:"method"` // e.g. CASH, UPI, BANK_TRANSFER
+	Reference   string `json:"reference"`
+	Description string `json:"description"`
+	PaidOn      string `json:"paidOn"` // YYYY-MM-DD, defaults to today
+}
+
+type RewardClawbackRequest struct {
+	Reason string `json:"reason"`
+}
+
+// validateRewardRule returns every invalid field of a rule, nil when it is valid
+func validateRewardRule(rule ReferralRewardRule) []FieldError {
+	var fields []FieldError
+
+	if rule.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Code: REQUIRED, Message: "name cannot be empty"})
+	}
+
+	if rule.Level < 1 || rule.Level > maxRewardLevel {
+		fields = append(fields, FieldError{Field: "level", Code: INVALID_VALUE, Message: "level must be between 1 and " + strconv.Itoa(maxRewardLevel)})
+	}
+
+	switch rule.Kind {
+	case REWARD_DISBURSEMENT_PERCENT:
+		if rule.Percent == nil {
+			fields = append(fields, FieldError{Field: "percent", Code: REQUIRED, Message: "percent is required for DISBURSEMENT_PERCENT rules"})
+		} else if _, ok := shareBasisPoints(*rule.Percent); !ok {
+			fields = append(fields, FieldError{Field: "percent", Code: INVALID_VALUE, Message: "must be a percent above 0 and at most 100 with at most two decimals"})
+		}
+		if rule.Amount != nil {
+			fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "amount is only used by COMPLETION_BONUS rules"})
+		}
+	case REWARD_COMPLETION_BONUS:
+		if rule.Amount == nil {
+			fields = append(fields, FieldError{Field: "amount", Code: REQUIRED, Message: "amount is required for COMPLETION_BONUS rules"})
+		} else if *rule.Amount <= 0 {
+			fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"})
+		}
+		if rule.Percent != nil {
+			fields = append(fields, FieldError{Field: "percent", Code: INVALID_VALUE, Message: "percent is only used by DISBURSEMENT_PERCENT rules"})
+		}
+	default:
+		fields = append(fields, FieldError{Field: "kind", Code: INVALID_VALUE, Message: "kind must be DISBURSEMENT_PERCENT or COMPLETION_BONUS"})
+	}
+	return fields
+}
+
+// parseEffectiveDate parses an optional YYYY-MM-DD date, defaulting to today
+func parseEffectiveDate(field, raw string) (time.Time, *FieldError) {
+	if raw == "" {
+		return today(), nil
+	}
+	date, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, &FieldError{Field: field, Code: INVALID_VALUE, Message: "must be a date like 2025-12-31"}
+	}
+	return date, nil
+}
+
+func scanRewardRule(row interface{ Scan(...any) error }) (rule ReferralRewardRule, err error) {
+	err = row.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Level, &rule.Percent, &rule.Amount, &rule.Active,
+		&rule.EffectiveFrom, &rule.CreatedBy, &rule.CreatedAt, &rule.UpdatedAt)
+	return rule, err
+}
+
+func scanRewardEntry(row interface{ Scan(...any) error }) (entry RewardEntry, err error) {
+	err = row.Scan(&entry.ID, &entry.ReferrerID, &entry.Type, &entry.Amount, &entry.HandoutID, &entry.RuleID,
+		&entry.Level, &entry.ReversesID, &entry.PaymentMethod, &entry.PaymentReference, &entry.Description,
+		&entry.EntryDate, &entry.CreatedBy, &entry.CreatedAt)
+	return entry, err
+}
+
+// accrueReferralRewards records the rewards a handout has earned so far. It is
+// run whenever a handout is created or updated and only adds what is missing.
+func accrueReferralRewards(tx *tracedTx, handoutID, adminID int) error {
+	_, err := tx.Exec(ACCRUE_REFERRAL_REWARDS, handoutID, adminID)
+	return err
+}
+
+// clawBackReferralRewards reverses the rewards accrued on a handout, e.g. when
+// it is cancelled or written off. No further rewards accrue on it afterwards.
+func clawBackReferralRewards(tx *tracedTx, handoutID int, reason string, adminID int) (int64, error) {
+	result, err := tx.Exec(CLAWBACK_REFERRAL_REWARDS, handoutID, reason, adminID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func getRewardRules(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), GET_REWARD_RULES)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	rules := []ReferralRewardRule{}
+	for rows.Next() {
+		rule, err := scanRewardRule(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]ReferralRewardRule]{
+		D:   rules,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createRewardRule adds a rule, which applies to every branch
+func createRewardRule(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can manage referral reward rules", http.StatusForbidden)
+		return
+	}
+
+	var req CreateRewardRuleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	rule := ReferralRewardRule{Name: req.Name, Kind: req.Kind, Level: req.Level, Percent: req.Percent, Amount: req.Amount, Active: true}
+	if req.Active != nil {
+		rule.Active = *req.Active
+	}
+	fields := validateRewardRule(rule)
+	effectiveFrom, fieldErr := parseEffectiveDate("effectiveFrom", req.EffectiveFrom)
+	if fieldErr != nil {
+		fields = append(fields, *fieldErr)
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	createdBy, _ := r.Context().Value("adminID").(int)
+	rule, err := scanRewardRule(db.QueryRowContext(r.Context(), CREATE_REWARD_RULE, rule.Name, rule.Kind, rule.Level,
+		rule.Percent, rule.Amount, rule.Active, effectiveFrom, createdBy))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[ReferralRewardRule]{
+		D:   rule,
+		Msg: "Reward rule created successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func updateRewardRule(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can manage referral reward rules", http.StatusForbidden)
+		return
+	}
+
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req UpdateRewardRuleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	rule, err := scanRewardRule(db.QueryRowContext(r.Context(), GET_REWARD_RULE, ruleID))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, REWARD_RULE_NOT_FOUND, "Reward rule not found")
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Percent != nil {
+		rule.Percent = req.Percent
+	}
+	if req.Amount != nil {
+		rule.Amount = req.Amount
+	}
+	if req.Active != nil {
+		rule.Active = *req.Active
+	}
+	fields := validateRewardRule(rule)
+	if req.EffectiveFrom != nil {
+		effectiveFrom, fieldErr := parseEffectiveDate("effectiveFrom", *req.EffectiveFrom)
+		if fieldErr != nil {
+			fields = append(fields, *fieldErr)
+		}
+		rule.EffectiveFrom = effectiveFrom
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	rule, err = scanRewardRule(db.QueryRowContext(r.Context(), UPDATE_REWARD_RULE, rule.Name, rule.Percent, rule.Amount,
+		rule.Active, rule.EffectiveFrom, ruleID))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[ReferralRewardRule]{
+		D:   rule,
+		Msg: "Reward rule updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getCustomerRewards writes the reward ledger of a referrer with their balance
+func getCustomerRewards(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRowContext(r.Context(), CHECK_CUSTOMER_EXISTS, customerID, branchScope(r)).Scan(&exists)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+
+	rewards := ReferrerRewards{Entries: []RewardEntry{}}
+	err = db.QueryRowContext(r.Context(), GET_REFERRAL_REWARD_BALANCE, customerID).Scan(
+		&rewards.Earned, &rewards.ClawedBack, &rewards.Paid, &rewards.Balance)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_REFERRAL_REWARD_LEDGER, customerID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanRewardEntry(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		rewards.Entries = append(rewards.Entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[ReferrerRewards]{
+		D:   rewards,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createRewardPayout records a payment to a referrer, at most their current balance
+func createRewardPayout(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req RewardPayoutRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var fields []FieldError
+	if req.Amount <= 0 {
+		fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter a valid amount"})
+	}
+	if req.Method == "" {
+		fields = append(fields, FieldError{Field: "method", Code: REQUIRED, Message: "method cannot be empty"})
+	}
+	paidOn, fieldErr := parsePastDate("paidOn", req.PaidOn)
+	if fieldErr != nil {
+		fields = append(fields, *fieldErr)
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the referrer keeps two payouts from both spending the same balance
+	err = tx.QueryRow(LOCK_CUSTOMER, customerID, branchScope(r)).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, CUSTOMER_NOT_FOUND, CUSTOMER_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	var balance RewardBalance
+	err = tx.QueryRow(GET_REFERRAL_REWARD_BALANCE, customerID).Scan(
+		&balance.Earned, &balance.ClawedBack, &balance.Paid, &balance.Balance)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if req.Amount > balance.Balance {
+		sendError(w, http.StatusConflict, INSUFFICIENT_REWARD_BALANCE, "Payout exceeds the reward balance of "+balance.Balance.String())
+		return
+	}
+
+	createdBy, _ := r.Context().Value("adminID").(int)
+	entry, err := scanRewardEntry(tx.QueryRow(CREATE_REWARD_PAYOUT, customerID, -req.Amount, req.Method, req.Reference,
+		req.Description, paidOn, createdBy))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[RewardEntry]{
+		D:   entry,
+		Msg: "Payout recorded successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// clawBackHandoutRewards reverses the referral rewards of a handout that will
+// not be repaid
+func clawBackHandoutRewards(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req RewardClawbackRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Reason == "" {
+		sendValidationErrors(w, []FieldError{{Field: "reason", Code: REQUIRED, Message: "reason cannot be empty"}})
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_STATUS, handoutID, branchScope(r)).Scan(&status)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	reversed, err := clawBackReferralRewards(tx, handoutID, req.Reason, adminID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := MsgResp{
+		Msg: strconv.FormatInt(reversed, 10) + " rewards clawed back",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getRewardBalances lists every referrer in the caller's branch with their reward balance
+func getRewardBalances(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), GET_REFERRAL_REWARD_BALANCES, branchScope(r))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	balances := []ReferrerBalance{}
+	for rows.Next() {
+		var balance ReferrerBalance
+		err := rows.Scan(&balance.Referrer.ID, &balance.Referrer.Name, &balance.Referrer.Mobile, &balance.BranchID,
+			&balance.Earned, &balance.ClawedBack, &balance.Paid, &balance.Balance)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		balances = append(balances, balance)
+	}
+
+	if err = rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[[]ReferrerBalance]{
+		D:   balances,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
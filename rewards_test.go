@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestValidateRewardRule(t *testing.T) {
+	percent := 1.5
+	bonus := Money(50000)
+	zero := Money(0)
+
+	tests := []struct {
+		name   string
+		rule   ReferralRewardRule
+		fields int
+	}{
+		{"disbursement percent", ReferralRewardRule{Name: "Direct", Kind: REWARD_DISBURSEMENT_PERCENT, Level: 1, Percent: &percent}, 0},
+		{"completion bonus", ReferralRewardRule{Name: "Bonus", Kind: REWARD_COMPLETION_BONUS, Level: 2, Amount: &bonus}, 0},
+		{"percent without value", ReferralRewardRule{Name: "Direct", Kind: REWARD_DISBURSEMENT_PERCENT, Level: 1}, 1},
+		{"percent with an amount", ReferralRewardRule{Name: "Direct", Kind: REWARD_DISBURSEMENT_PERCENT, Level: 1, Percent: &percent, Amount: &bonus}, 1},
+		{"zero bonus", ReferralRewardRule{Name: "Bonus", Kind: REWARD_COMPLETION_BONUS, Level: 1, Amount: &zero}, 1},
+		{"nothing given", ReferralRewardRule{}, 3},
+		{"level too deep", ReferralRewardRule{Name: "Deep", Kind: REWARD_COMPLETION_BONUS, Level: maxRewardLevel + 1, Amount: &bonus}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fields := validateRewardRule(tt.rule); len(fields) != tt.fields {
+				t.Errorf("got %d field errors, want %d: %+v", len(fields), tt.fields, fields)
+			}
+		})
+	}
+}
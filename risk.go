@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Weights of the parts of a risk score, they add up to 1
+const (
+	riskWeightLateness = 0.4
+	riskWeightDelay    = 0.25
+	riskWeightCancel   = 0.15
+	riskWeightNetwork  = 0.2
+	// riskMaxDaysLate is the average days late that scores the full delay weight
+	riskMaxDaysLate = 60
+)
+
+// RiskFactors is the repayment history a risk score is computed from, see
+// GET_RISK_FACTORS for how gaps between collections count as late
+type RiskFactors struct {
+	Handouts        int `json:"handouts"`
+	Completed       int `json:"completed"`
+	Cancelled       int `json:"cancelled"`
+	Collections     int `json:"collections"`
+	OnTime          int `json:"onTime"`          // collections that came within the interval
+	LatePeriods     int `json:"latePeriods"`     // late collections and owing handouts overdue right now
+	LateDays        int `json:"lateDays"`        // days beyond the interval over the late periods
+	NetworkSize     int `json:"networkSize"`     // the customer's referrer and the customers they referred
+	NetworkDefaults int `json:"networkDefaults"` // network members overdue right now
+
+	OnTimeRatio         float64 `json:"onTimeRatio"`
+	AvgDaysLate         float64 `json:"avgDaysLate"`
+	CancelledRatio      float64 `json:"cancelledRatio"` // of the completed and cancelled handouts
+	NetworkDefaultRatio float64 `json:"networkDefaultRatio"`
+}
+
+type CustomerRisk struct {
+	CustomerID int         `json:"customerId"`
+	Score      int         `json:"score"`
+	Factors    RiskFactors `json:"factors"`
+	ComputedAt time.Time   `json:"computedAt"`
+}
+
+// ratio is part/whole, or fallback when whole is 0
+func ratio(part, whole int, fallback float64) float64 {
+	if whole == 0 {
+		return fallback
+	}
+	return float64(part) / float64(whole)
+}
+
+// computeRiskScore fills in the ratios of f and returns its score, from 0 for
+// a borrower who always paid on time to 100
+func computeRiskScore(f *RiskFactors) int {
+	// A borrower with nothing late yet counts as on time
+	f.OnTimeRatio = ratio(f.OnTime, f.OnTime+f.LatePeriods, 1)
+	f.AvgDaysLate = math.Round(ratio(f.LateDays, f.LatePeriods, 0)*10) / 10
+	f.CancelledRatio = ratio(f.Cancelled, f.Completed+f.Cancelled, 0)
+	f.NetworkDefaultRatio = ratio(f.NetworkDefaults, f.NetworkSize, 0)
+
+	score := riskWeightLateness*(1-f.OnTimeRatio) +
+		riskWeightDelay*math.Min(f.AvgDaysLate/riskMaxDaysLate, 1) +
+		riskWeightCancel*f.CancelledRatio +
+		riskWeightNetwork*f.NetworkDefaultRatio
+	return int(math.Round(score * 100))
+}
+
+// refreshRiskScores recomputes the risk score of a customer, or of every
+// customer when customerID is 0, and returns how many were scored. Customers
+// without handouts have no score.
+func refreshRiskScores(ctx context.Context, customerID int) (int, error) {
+	rows, err := db.QueryContext(ctx, GET_RISK_FACTORS, customerID, appConfig.Risk.CollectionIntervalDays)
+	if err != nil {
+		return 0, err
+	}
+
+	var customers []CustomerRisk
+	for rows.Next() {
+		var risk CustomerRisk
+		f := &risk.Factors
+		err := rows.Scan(&risk.CustomerID, &f.Handouts, &f.Completed, &f.Cancelled, &f.Collections, &f.OnTime,
+			&f.LatePeriods, &f.LateDays, &f.NetworkSize, &f.NetworkDefaults)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		customers = append(customers, risk)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	scored := 0
+	for _, risk := range customers {
+		if risk.Factors.Handouts == 0 {
+			if _, err := db.ExecContext(ctx, DELETE_RISK_SCORE, risk.CustomerID); err != nil {
+				return scored, err
+			}
+			continue
+		}
+
+		risk.Score = computeRiskScore(&risk.Factors)
+		factors, err := json.Marshal(risk.Factors)
+		if err != nil {
+			return scored, err
+		}
+		if _, err := db.ExecContext(ctx, UPSERT_RISK_SCORE, risk.CustomerID, risk.Score, string(factors)); err != nil {
+			return scored, err
+		}
+		scored++
+	}
+	return scored, nil
+}
+
+// riskCustomer returns the customer a handout or collection belongs to, by
+// GET_HANDOUT_CUSTOMER or GET_COLLECTION_CUSTOMER, or 0 when there is none
+func riskCustomer(r *http.Request, query string, id int) int {
+	var customerID int
+	err := db.QueryRowContext(r.Context(), query, id).Scan(&customerID)
+	if err != nil && err != sql.ErrNoRows {
+		logRequestError(r, "risk score refresh failed", err)
+	}
+	return customerID
+}
+
+// refreshCustomerRisk rescores customers after their collections changed. The
+// change is already saved, so a failure is only logged and the nightly
+// refresh catches up.
+func refreshCustomerRisk(r *http.Request, customerIDs ...int) {
+	for i, customerID := range customerIDs {
+		// 0 would rescore everyone
+		if customerID == 0 || slices.Contains(customerIDs[:i], customerID) {
+			continue
+		}
+		if _, err := refreshRiskScores(r.Context(), customerID); err != nil {
+			logRequestError(r, "risk score refresh failed", err)
+		}
+	}
+}
+
+// startRiskScoreRefresh rescores every customer each night at RISK_REFRESH_TIME
+func startRiskScoreRefresh() {
+	startDailyJob("risk score refresh", appConfig.Risk.RefreshTime, func(ctx context.Context) {
+		started := time.Now()
+		scored, err := refreshRiskScores(ctx, 0)
+		if err != nil {
+			log.Printf("risk score refresh failed after %d customers: %v", scored, err)
+			return
+		}
+		log.Printf("risk scores refreshed for %d customers in %s", scored, time.Since(started).Round(time.Millisecond))
+	})
+}
+
+// parseRiskScoreBound reads an optional score between 0 and 100, adding to
+// fields when it is invalid
+func parseRiskScoreBound(raw, field string, fields *[]FieldError) *int {
+	if raw == "" {
+		return nil
+	}
+	score, err := strconv.Atoi(raw)
+	if err != nil || score < 0 || score > 100 {
+		*fields = append(*fields, FieldError{Field: field, Code: INVALID_VALUE, Message: field + " must be between 0 and 100"})
+		return nil
+	}
+	return &score
+}
+
+// getCustomerRisk writes a customer's risk score with the history behind it
+func getCustomerRisk(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := customerBranch(w, r, customerID); !ok {
+		return
+	}
+
+	risk := CustomerRisk{CustomerID: customerID}
+	var factors []byte
+	err = db.QueryRowContext(r.Context(), GET_RISK_SCORE, customerID, branchScope(r)).Scan(&risk.Score, &factors, &risk.ComputedAt)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, RISK_SCORE_NOT_FOUND, "Customer has no risk score yet, they have no handouts")
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(factors, &risk.Factors)
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[CustomerRisk]{
+		D:   risk,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestComputeRiskScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors RiskFactors
+		want    int
+	}{
+		{"new borrower", RiskFactors{Handouts: 1}, 0},
+		{"always on time", RiskFactors{Handouts: 2, Completed: 2, Collections: 10, OnTime: 10}, 0},
+		{"always late by the cap", RiskFactors{Handouts: 1, Collections: 2, LatePeriods: 2, LateDays: 120}, 65},
+		{"half late by 15 days", RiskFactors{Handouts: 1, Collections: 4, OnTime: 2, LatePeriods: 2, LateDays: 30}, 26},
+		{"cancelled", RiskFactors{Handouts: 4, Completed: 1, Cancelled: 3}, 11},
+		{"network defaults", RiskFactors{Handouts: 1, NetworkSize: 4, NetworkDefaults: 1}, 5},
+		{"worst", RiskFactors{Handouts: 1, Cancelled: 1, LatePeriods: 1, LateDays: 90, NetworkSize: 1, NetworkDefaults: 1}, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeRiskScore(&tt.factors); got != tt.want {
+				t.Errorf("expected score %d, got %d (%+v)", tt.want, got, tt.factors)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Installment frequencies, mirroring the installment_frequency enum in sql/migration-19.sql
+const (
+	FREQUENCY_DAILY   = "DAILY"
+	FREQUENCY_WEEKLY  = "WEEKLY"
+	FREQUENCY_MONTHLY = "MONTHLY"
+)
+
+// Installment statuses, worked out from the collections
+const (
+	INSTALLMENT_PAID     = "PAID"
+	INSTALLMENT_OVERDUE  = "OVERDUE"  // due before today and not paid in full
+	INSTALLMENT_UPCOMING = "UPCOMING" // due today or later
+)
+
+// maxInstallments mirrors the check on handout_schedules.installments
+const maxInstallments = 1000
+
+// RepaymentSchedule splits the principal of a handout and the flat interest
+// on it into equal installments, the last one taking the odd paise. A
+// restructure adds the next version, the latest is the one being repaid.
+type RepaymentSchedule struct {
+	ID                  int           `json:"id"`
+	HandoutID           int           `json:"handoutId"`
+	Version             int           `json:"version"`
+	Principal           Money         `json:"principal"`
+	AnnualRate          float64       `json:"annualRate"` // flat, percent a year
+	Interest            Money         `json:"interest"`
+	Installments        int           `json:"installments"`
+	Frequency           string        `json:"frequency"`
+	FirstDueDate        time.Time     `json:"firstDueDate"`
+	CollectedBefore     Money         `json:"collectedBefore"`     // paid the earlier versions
+	PenaltiesPaidBefore Money         `json:"penaltiesPaidBefore"` // part of it that paid penalties
+	CreatedBy           int           `json:"createdBy"`
+	CreatedAt           time.Time     `json:"createdAt"`
+	UpdatedAt           time.Time     `json:"updatedAt"`
+	Schedule            []Installment `json:"schedule"`
+}
+
+// ScheduleRequest sets the repayment terms, the principal is the handout's amount
+type ScheduleRequest struct {
+	Installments int     `json:"installments"`
+	Frequency    string  `json:"frequency"`
+	FirstDueDate string  `json:"firstDueDate"` // YYYY-MM-DD
+	AnnualRate   float64 `json:"annualRate"`
+}
+
+// Installment is one due amount of a schedule. Collections pay installments
+// in order, oldest collection first, and the interest of each before its
+// principal.
+type Installment struct {
+	Number    int        `json:"number"`
+	DueDate   time.Time  `json:"dueDate"`
+	Amount    Money      `json:"amount"`
+	Principal Money      `json:"principal"`
+	Interest  Money      `json:"interest"`
+	Paid      Money      `json:"paid"`
+	PaidOn    *time.Time `json:"paidOn"` // the collection that completed it
+	Status    string     `json:"status"`
+	DaysLate  int        `json:"daysLate"` // past the due date when paid, or so far
+}
+
+// validateSchedule returns every invalid field of a schedule request and the first due date
+func validateSchedule(req ScheduleRequest) ([]FieldError, time.Time) {
+	var fields []FieldError
+
+	if req.Installments < 1 || req.Installments > maxInstallments {
+		fields = append(fields, FieldError{Field: "installments", Code: INVALID_VALUE, Message: "installments must be between 1 and " + strconv.Itoa(maxInstallments)})
+	}
+
+	if _, ok := percentBasisPoints(req.AnnualRate); !ok {
+		fields = append(fields, FieldError{Field: "annualRate", Code: INVALID_VALUE, Message: "must be between 0 and 100 with at most two decimals"})
+	}
+
+	switch req.Frequency {
+	case FREQUENCY_DAILY, FREQUENCY_WEEKLY, FREQUENCY_MONTHLY:
+	case "":
+		fields = append(fields, FieldError{Field: "frequency", Code: REQUIRED, Message: "frequency cannot be empty"})
+	default:
+		fields = append(fields, FieldError{Field: "frequency", Code: INVALID_VALUE, Message: "frequency must be DAILY, WEEKLY or MONTHLY"})
+	}
+
+	var firstDueDate time.Time
+	if req.FirstDueDate == "" {
+		fields = append(fields, FieldError{Field: "firstDueDate", Code: REQUIRED, Message: "first due date cannot be empty"})
+	} else {
+		var fieldErr *FieldError
+		if firstDueDate, fieldErr = parseEffectiveDate("firstDueDate", req.FirstDueDate); fieldErr != nil {
+			fields = append(fields, *fieldErr)
+		}
+	}
+	return fields, firstDueDate
+}
+
+// dueDate returns the due date of installment n (from 1). Monthly installments
+// due on the 31st fall on the last day of shorter months.
+func dueDate(first time.Time, frequency string, n int) time.Time {
+	switch frequency {
+	case FREQUENCY_DAILY:
+		return first.AddDate(0, 0, n-1)
+	case FREQUENCY_WEEKLY:
+		return first.AddDate(0, 0, 7*(n-1))
+	}
+	month := time.Date(first.Year(), first.Month()+time.Month(n-1), 1, 0, 0, 0, 0, first.Location())
+	lastDay := month.AddDate(0, 1, -1).Day()
+	return month.AddDate(0, 0, min(first.Day(), lastDay)-1)
+}
+
+// dayOf truncates t to its UTC day, the unit due dates and lateness are counted in
+func dayOf(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(dayOf(to).Sub(dayOf(from)).Hours() / 24)
+}
+
+// flatInterest is the interest at annualRate percent a year on principal over
+// the installments, a day, a week or a twelfth of a year each
+func flatInterest(principal Money, annualRate float64, installments int, frequency string) Money {
+	basisPoints, _ := percentBasisPoints(annualRate)
+	switch frequency {
+	case FREQUENCY_DAILY:
+		return principal.MulRatio(basisPoints*int64(installments), 10000*365)
+	case FREQUENCY_WEEKLY:
+		return principal.MulRatio(basisPoints*int64(installments)*7, 10000*365)
+	}
+	return principal.MulRatio(basisPoints*int64(installments), 10000*12)
+}
+
+// plan returns the installments of the schedule without any payments
+func (s RepaymentSchedule) plan() []Installment {
+	if s.Installments < 1 {
+		return nil
+	}
+	n := Money(s.Installments)
+	principal, interest := s.Principal/n, s.Interest/n
+	plan := make([]Installment, s.Installments)
+	for i := range plan {
+		plan[i] = Installment{Number: i + 1, DueDate: dueDate(s.FirstDueDate, s.Frequency, i+1), Principal: principal, Interest: interest}
+	}
+	last := &plan[len(plan)-1]
+	last.Principal = s.Principal - principal*(n-1)
+	last.Interest = s.Interest - interest*(n-1)
+	for i := range plan {
+		plan[i].Amount = plan[i].Principal + plan[i].Interest
+	}
+	return plan
+}
+
+// versionPayments returns the collections that pay a schedule version, those
+// after the first collectedBefore. A collection split by it counts with the
+// rest of its amount.
+func versionPayments(collections []Collection, collectedBefore Money) []Collection {
+	var payments []Collection
+	for _, collection := range collections {
+		if collectedBefore >= collection.Amount {
+			collectedBefore -= collection.Amount
+			continue
+		}
+		collection.Amount -= collectedBefore
+		collectedBefore = 0
+		payments = append(payments, collection)
+	}
+	return payments
+}
+
+// applyPayments pays the installments in order with collections sorted oldest
+// first, and works out their status on asOf
+func applyPayments(plan []Installment, collections []Collection, asOf time.Time) {
+	var paidSoFar, due Money
+	next := 0
+	for i := range plan {
+		item := &plan[i]
+		due += item.Amount
+		for next < len(collections) && paidSoFar < due {
+			paidSoFar += collections[next].Amount
+			if paidSoFar >= due {
+				paidOn := dayOf(collections[next].Date)
+				item.PaidOn = &paidOn
+			}
+			next++
+		}
+		if item.PaidOn == nil && paidSoFar >= due && i > 0 {
+			// Paid by the collection that completed the previous installment
+			item.PaidOn = plan[i-1].PaidOn
+		}
+
+		item.Paid = max(min(paidSoFar-(due-item.Amount), item.Amount), 0)
+		switch {
+		case item.Paid == item.Amount:
+			item.Status = INSTALLMENT_PAID
+			if item.PaidOn != nil {
+				item.DaysLate = max(daysBetween(item.DueDate, *item.PaidOn), 0)
+			}
+		case dayOf(item.DueDate).Before(dayOf(asOf)):
+			item.Status = INSTALLMENT_OVERDUE
+			item.DaysLate = daysBetween(item.DueDate, asOf)
+		default:
+			item.Status = INSTALLMENT_UPCOMING
+		}
+	}
+}
+
+// overdue sums the unpaid part of the overdue installments
+func overdue(plan []Installment) (amount Money, installments int) {
+	for _, item := range plan {
+		if item.Status == INSTALLMENT_OVERDUE {
+			amount += item.Amount - item.Paid
+			installments++
+		}
+	}
+	return amount, installments
+}
+
+func scanSchedule(row interface{ Scan(...any) error }) (schedule RepaymentSchedule, err error) {
+	err = row.Scan(&schedule.ID, &schedule.HandoutID, &schedule.Version, &schedule.Principal, &schedule.AnnualRate,
+		&schedule.Interest, &schedule.Installments, &schedule.Frequency, &schedule.FirstDueDate, &schedule.CollectedBefore,
+		&schedule.PenaltiesPaidBefore, &schedule.CreatedBy, &schedule.CreatedAt, &schedule.UpdatedAt)
+	return schedule, err
+}
+
+// loadPayments returns the collections of a handout oldest first
+func loadPayments(tx *tracedTx, handoutID int) ([]Collection, error) {
+	rows, err := tx.Query(GET_PAYMENTS, handoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		collection := Collection{HandoutId: handoutID}
+		if err := rows.Scan(&collection.ID, &collection.Date, &collection.Amount); err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
+
+// loadSchedule returns version of a handout's schedule, the current one when
+// it is 0, with its installments paid as of today. It returns sql.ErrNoRows
+// when there is no such version.
+func loadSchedule(tx *tracedTx, handoutID, scope, version int) (RepaymentSchedule, error) {
+	schedule, err := scanSchedule(tx.QueryRow(GET_HANDOUT_SCHEDULE, handoutID, scope, version))
+	if err != nil {
+		return schedule, err
+	}
+	collections, err := loadPayments(tx, handoutID)
+	if err != nil {
+		return schedule, err
+	}
+	schedule.Schedule = schedule.plan()
+	applyPayments(schedule.Schedule, versionPayments(collections, schedule.CollectedBefore), today())
+	return schedule, nil
+}
+
+func getHandoutSchedule(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	// Earlier versions are kept after a restructure, ?version= shows one
+	version := 0
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		if version, err = strconv.Atoi(raw); err != nil || version < 1 {
+			sendValidationErrors(w, []FieldError{{Field: "version", Code: INVALID_VALUE, Message: "version must be a positive integer"}})
+			return
+		}
+	}
+
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	schedule, err := loadSchedule(tx, handoutID, branchScope(r), version)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, SCHEDULE_NOT_FOUND, "Handout has no repayment schedule")
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[RepaymentSchedule]{
+		D:   schedule,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setHandoutSchedule sets the repayment terms of a handout over its current
+// amount. They can be corrected until the first penalty accrues on them or
+// the handout is restructured, after that only a restructure changes them.
+func setHandoutSchedule(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req ScheduleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	fields, firstDueDate := validateSchedule(req)
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var customerID int
+	var amount, collected Money
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, branchScope(r)).Scan(&customerID, &amount, &status, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	// Every installment must be at least a paisa
+	if amount < Money(req.Installments) {
+		sendValidationErrors(w, []FieldError{{Field: "installments", Code: INVALID_VALUE, Message: "more installments than paise in the handout amount"}})
+		return
+	}
+
+	var hasPenalties, restructured, settled bool
+	if err := tx.QueryRow(GET_SCHEDULE_LOCKS, handoutID).Scan(&hasPenalties, &restructured, &settled); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	// The settlement was quoted on the interest of these terms
+	if settled {
+		sendError(w, http.StatusConflict, HANDOUT_SETTLED, "The handout was settled early and its terms can no longer be changed")
+		return
+	}
+	if restructured {
+		sendError(w, http.StatusConflict, SCHEDULE_RESTRUCTURED, "The handout has been restructured, restructure it again to change its terms")
+		return
+	}
+	if hasPenalties {
+		sendError(w, http.StatusConflict, SCHEDULE_HAS_PENALTIES, "Penalties have accrued on the schedule, it can no longer be changed")
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	interest := flatInterest(amount, req.AnnualRate, req.Installments, req.Frequency)
+	result, err := tx.Exec(UPDATE_FIRST_SCHEDULE, handoutID, amount, req.AnnualRate, interest, req.Installments,
+		req.Frequency, firstDueDate, adminID)
+	var updated int64
+	if err == nil {
+		updated, err = result.RowsAffected()
+	}
+	if err == nil && updated == 0 {
+		_, err = tx.Exec(CREATE_HANDOUT_SCHEDULE, handoutID, 1, amount, req.AnnualRate, interest, req.Installments,
+			req.Frequency, firstDueDate, 0, 0, adminID)
+	}
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	schedule, err := loadSchedule(tx, handoutID, 0, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[RepaymentSchedule]{
+		D:   schedule,
+		Msg: "Schedule saved successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
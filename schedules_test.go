@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// monthlySchedule is version 1 of handout 3's schedule: four monthly
+// installments of 250.00 and 10.00 of interest from 2026-01-10, paid with
+// collected on that day and seen on asOf
+func monthlySchedule(collected Money, asOf time.Time) RepaymentSchedule {
+	schedule := RepaymentSchedule{ID: 4, HandoutID: 3, Version: 1, Principal: 100000, AnnualRate: 12, Interest: 4000,
+		Installments: 4, Frequency: FREQUENCY_MONTHLY, FirstDueDate: date("2026-01-10")}
+	schedule.Schedule = schedule.plan()
+	applyPayments(schedule.Schedule, []Collection{{Date: schedule.FirstDueDate, Amount: collected}}, asOf)
+	return schedule
+}
+
+func TestDueDate(t *testing.T) {
+	tests := []struct {
+		first     string
+		frequency string
+		n         int
+		want      string
+	}{
+		{"2026-01-10", FREQUENCY_DAILY, 1, "2026-01-10"},
+		{"2026-01-10", FREQUENCY_DAILY, 25, "2026-02-03"},
+		{"2026-01-10", FREQUENCY_WEEKLY, 3, "2026-01-24"},
+		{"2026-01-31", FREQUENCY_MONTHLY, 2, "2026-02-28"},
+		{"2026-01-31", FREQUENCY_MONTHLY, 3, "2026-03-31"},
+		{"2026-11-30", FREQUENCY_MONTHLY, 4, "2027-02-28"},
+	}
+
+	for _, tt := range tests {
+		if got := dueDate(date(tt.first), tt.frequency, tt.n); !got.Equal(date(tt.want)) {
+			t.Errorf("installment %d of %s %s: expected %s, got %s", tt.n, tt.frequency, tt.first, tt.want, got.Format(time.DateOnly))
+		}
+	}
+}
+
+func TestSchedulePlan(t *testing.T) {
+	schedule := RepaymentSchedule{Principal: 100000, Installments: 3, Frequency: FREQUENCY_WEEKLY, FirstDueDate: date("2026-01-05")}
+	plan := schedule.plan()
+
+	want := []Money{33333, 33333, 33334}
+	if len(plan) != len(want) {
+		t.Fatalf("expected %d installments, got %d", len(want), len(plan))
+	}
+	for i, amount := range want {
+		if plan[i].Amount != amount || plan[i].Number != i+1 {
+			t.Errorf("installment %d: expected %s, got %+v", i+1, amount, plan[i])
+		}
+	}
+	if !plan[2].DueDate.Equal(date("2026-01-19")) {
+		t.Errorf("expected the last installment due on 2026-01-19, got %s", plan[2].DueDate)
+	}
+}
+
+func TestFlatInterest(t *testing.T) {
+	tests := []struct {
+		frequency    string
+		installments int
+		want         Money
+	}{
+		// 12% a year on 10,000.00
+		{FREQUENCY_MONTHLY, 12, 120000},
+		{FREQUENCY_MONTHLY, 6, 60000},
+		{FREQUENCY_WEEKLY, 52, 119671},
+		{FREQUENCY_DAILY, 365, 120000},
+	}
+
+	for _, tt := range tests {
+		if got := flatInterest(1000000, 12, tt.installments, tt.frequency); got != tt.want {
+			t.Errorf("%d %s installments: expected %s, got %s", tt.installments, tt.frequency, tt.want, got)
+		}
+	}
+}
+
+func TestSchedulePlanWithInterest(t *testing.T) {
+	schedule := RepaymentSchedule{Principal: 100000, Interest: 1000, Installments: 3, Frequency: FREQUENCY_MONTHLY, FirstDueDate: date("2026-01-05")}
+	plan := schedule.plan()
+
+	want := []struct{ principal, interest Money }{{33333, 333}, {33333, 333}, {33334, 334}}
+	for i, w := range want {
+		if plan[i].Principal != w.principal || plan[i].Interest != w.interest || plan[i].Amount != w.principal+w.interest {
+			t.Errorf("installment %d: expected %s principal and %s interest, got %+v", i+1, w.principal, w.interest, plan[i])
+		}
+	}
+}
+
+func TestVersionPayments(t *testing.T) {
+	collections := []Collection{
+		{ID: 1, Amount: 5000},
+		{ID: 2, Amount: 5000},
+		{ID: 3, Amount: 2000},
+	}
+	got := versionPayments(collections, 7000)
+
+	if len(got) != 2 || got[0].ID != 2 || got[0].Amount != 3000 || got[1].ID != 3 || got[1].Amount != 2000 {
+		t.Errorf("expected the rest of collection 2 and collection 3, got %+v", got)
+	}
+	if collections[1].Amount != 5000 {
+		t.Errorf("collections were changed: %+v", collections)
+	}
+	if got := versionPayments(collections, 12000); len(got) != 0 {
+		t.Errorf("expected no payments once everything was collected before, got %+v", got)
+	}
+}
+
+func TestApplyPayments(t *testing.T) {
+	schedule := RepaymentSchedule{Principal: 30000, Installments: 3, Frequency: FREQUENCY_MONTHLY, FirstDueDate: date("2026-01-10")}
+	plan := schedule.plan()
+	collections := []Collection{
+		{Date: date("2026-01-08"), Amount: 10000},
+		{Date: date("2026-02-15"), Amount: 15000},
+	}
+	applyPayments(plan, collections, date("2026-03-20"))
+
+	want := []struct {
+		paid     Money
+		status   string
+		daysLate int
+	}{
+		{10000, INSTALLMENT_PAID, 0},
+		{10000, INSTALLMENT_PAID, 5},
+		{5000, INSTALLMENT_OVERDUE, 10},
+	}
+	for i, w := range want {
+		if plan[i].Paid != w.paid || plan[i].Status != w.status || plan[i].DaysLate != w.daysLate {
+			t.Errorf("installment %d: expected %+v, got %+v", i+1, w, plan[i])
+		}
+	}
+	if plan[1].PaidOn == nil || !plan[1].PaidOn.Equal(date("2026-02-15")) {
+		t.Errorf("expected installment 2 paid on 2026-02-15, got %v", plan[1].PaidOn)
+	}
+
+	amount, installments := overdue(plan)
+	if amount != 5000 || installments != 1 {
+		t.Errorf("expected 50.00 overdue on 1 installment, got %s on %d", amount, installments)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -24,8 +25,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Generate bcrypt hash with cost factor 14 (same as auth.go)
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	// Generate bcrypt hash with the same cost as the app (BCRYPT_COST, default 14)
+	cost := 14
+	if value := os.Getenv("BCRYPT_COST"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Println("❌ BCRYPT_COST must be an integer")
+			os.Exit(1)
+		}
+		cost = parsed
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		fmt.Printf("❌ Error generating hash: %v\n", err)
 		os.Exit(1)
@@ -40,12 +51,13 @@ func main() {
 	fmt.Println("\n📋 SQL Insert Statement:")
 	fmt.Println("───────────────────────────────────────────────────────────")
 	fmt.Printf(`
-INSERT INTO admins (username, password_hash, role, active, created_at, updated_at)
+INSERT INTO admins (username, password_hash, role, active, is_super_admin, created_at, updated_at)
 VALUES (
     'admin',                    -- Change this username
     '%s',
     'admin',                    -- Role: admin, manager, or viewer
     true,
+    true,                       -- Super admin, only for the first admin
     NOW(),
     NOW()
 );
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+// Background jobs started with startBackgroundJob are cancelled through
+// backgroundCtx on shutdown and waited for before the database is closed
+var (
+	backgroundCtx, stopBackgroundJobs = context.WithCancel(context.Background())
+	backgroundJobs                    sync.WaitGroup
+)
+
+// startBackgroundJob runs job in its own goroutine and tracks it for graceful shutdown.
+// The job must return promptly once ctx is cancelled.
+func startBackgroundJob(name string, job func(ctx context.Context)) {
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("background job %s panicked: %v", name, rec)
+			}
+		}()
+		job(backgroundCtx)
+	}()
+}
+
+// startDailyJob runs job every day at clock (HH:MM, validated with the config)
+// in the server's local time until shutdown
+func startDailyJob(name, clock string, job func(ctx context.Context)) {
+	startBackgroundJob(name, func(ctx context.Context) {
+		for {
+			timer := time.NewTimer(time.Until(nextDailyRun(time.Now(), clock)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			job(ctx)
+		}
+	})
+}
+
+// nextDailyRun returns the first time after now at clock (HH:MM) in now's location
+func nextDailyRun(now time.Time, clock string) time.Time {
+	at, err := time.Parse("15:04", clock)
+	if err != nil {
+		// Validated at startup, run a day later rather than never
+		return now.Add(24 * time.Hour)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// buildTLSConfig returns nil when TLS is not configured. Setting a client CA
+// turns on mutual TLS and every client must present a certificate signed by it.
+func buildTLSConfig(cfg config.ServerConfig) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("both TLS_CERT_FILE and TLS_KEY_FILE must be set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.TLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("client CA file contains no certificates")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// runServer serves handler until SIGINT/SIGTERM, then stops accepting new
+// connections, drains in-flight requests and background jobs within the
+// shutdown deadline and finally closes the database.
+func runServer(cfg config.ServerConfig, handler http.Handler) {
+	tlsConfig, err := buildTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		TLSConfig:         tlsConfig,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			log.Printf("service started on:%s (TLS)\n", cfg.Port)
+			// Certificates are already loaded into TLSConfig
+			serverErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("service started on:%s\n", cfg.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to start server on :%s: %v", cfg.Port, err)
+		}
+		return
+	case <-ctx.Done():
+		stop()
+	}
+
+	log.Println("Shutting down, draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server did not drain before the deadline: %v", err)
+	}
+
+	stopBackgroundJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(jobsDone)
+	}()
+
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		log.Println("Background jobs did not finish before the deadline")
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("✅ Shutdown complete")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDailyRun(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		clock string
+		want  time.Time
+	}{
+		{"later today", at(10, 1, 30), "02:00", at(10, 2, 0)},
+		{"already ran today", at(10, 2, 30), "02:00", at(11, 2, 0)},
+		{"exactly at the time", at(10, 2, 0), "02:00", at(11, 2, 0)},
+		{"invalid clock", at(10, 1, 0), "2am", at(11, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDailyRun(tt.now, tt.clock); !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+// AdminSession represents a single login (one issued JWT) of an admin
+type AdminSession struct {
+	ID         int       `json:"id"`
+	AdminID    int       `json:"adminId"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// generateTokenID returns a random identifier used as the JWT "jti" claim
+func generateTokenID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// clientIP returns the originating client address. X-Forwarded-For is only
+// honoured when the direct peer is a trusted proxy, anyone else could forge it.
+func clientIP(r *http.Request) string {
+	return forwardedClientIP(r, appConfig.CORS)
+}
+
+// forwardedClientIP walks X-Forwarded-For from the right, skipping trusted
+// proxies, and returns the first address a trusted proxy vouched for
+func forwardedClientIP(r *http.Request, cors config.CORSConfig) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !cors.TrustsProxy(peer) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !cors.TrustsProxy(hop) {
+			return hop
+		}
+		peer = hop
+	}
+	return peer
+}
+
+// createSession records a newly issued token as an active session
+func createSession(adminID int, tokenID string, expiresAt time.Time, r *http.Request) error {
+	_, err := db.ExecContext(r.Context(), CREATE_ADMIN_SESSION, adminID, tokenID, r.UserAgent(), clientIP(r), expiresAt)
+	return err
+}
+
+// revokeAllSessions signs an admin out everywhere
+func revokeAllSessions(ctx context.Context, adminID int) error {
+	_, err := db.ExecContext(ctx, REVOKE_ALL_ADMIN_SESSIONS, adminID)
+	return err
+}
+
+// isSuperAdmin reports whether the request was made by the built-in super
+// admin, the account flagged is_super_admin (see sql/migration-23.sql)
+func isSuperAdmin(r *http.Request) bool {
+	superAdmin, _ := r.Context().Value("superAdmin").(bool)
+	return superAdmin
+}
+
+// listSessions writes the active sessions of an admin
+func listSessions(w http.ResponseWriter, r *http.Request, adminID int) {
+	rows, err := db.QueryContext(r.Context(), GET_ADMIN_SESSIONS, adminID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	currentSessionID, _ := r.Context().Value("sessionID").(int)
+
+	sessions := []AdminSession{}
+	for rows.Next() {
+		var session AdminSession
+		err := rows.Scan(&session.ID, &session.AdminID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			sendErrorResponse(w, "Failed to scan session", http.StatusInternalServerError)
+			return
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	response := DataResp[[]AdminSession]{
+		D:   sessions,
+		Msg: "Sessions retrieved successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// terminateSession revokes a single session belonging to an admin
+func terminateSession(w http.ResponseWriter, r *http.Request, adminID int, sessionID string) {
+	id, err := strconv.Atoi(sessionID)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	result, err := db.ExecContext(r.Context(), REVOKE_ADMIN_SESSION, id, adminID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to terminate session", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		sendError(w, http.StatusNotFound, SESSION_NOT_FOUND, SESSION_NOT_FOUND_MSG)
+		return
+	}
+
+	response := MsgResp{
+		Msg: "Session terminated successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Get the current admin's active sessions
+func getMySessions(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("adminID").(int)
+	if !ok {
+		sendErrorResponse(w, "Admin not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	listSessions(w, r, adminID)
+}
+
+// Terminate one of the current admin's sessions (terminating the current one logs out)
+func deleteMySession(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("adminID").(int)
+	if !ok {
+		sendErrorResponse(w, "Admin not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	terminateSession(w, r, adminID, mux.Vars(r)["id"])
+}
+
+// Get any admin's active sessions (super admin only)
+func getAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can view other admins' sessions", http.StatusForbidden)
+		return
+	}
+
+	adminID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	listSessions(w, r, adminID)
+}
+
+// Terminate any admin's session (super admin only)
+func deleteAdminSession(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can terminate other admins' sessions", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	adminID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	terminateSession(w, r, adminID, vars["sessionId"])
+}
+
+// Terminate every session of an admin (super admin only)
+func deleteAllAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can terminate other admins' sessions", http.StatusForbidden)
+		return
+	}
+
+	adminID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if err := revokeAllSessions(r.Context(), adminID); err != nil {
+		sendErrorResponse(w, "Failed to terminate sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := MsgResp{
+		Msg: "All sessions terminated successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	cors := config.CORSConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:51234", "", "203.0.113.7"},
+		{"forged by an untrusted peer", "203.0.113.7:51234", "198.51.100.1", "203.0.113.7"},
+		{"through a trusted proxy", "10.1.2.3:51234", "198.51.100.1", "198.51.100.1"},
+		{"spoofed entry ahead of the proxy", "10.1.2.3:51234", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"through two trusted proxies", "10.1.2.3:51234", "198.51.100.1, 192.0.2.1", "198.51.100.1"},
+		{"trusted proxy without the header", "192.0.2.1:51234", "", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := forwardedClientIP(r, cors); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SettlementPolicy says how much of the interest not earned yet is forgiven
+// when a handout is settled early
+type SettlementPolicy struct {
+	RebatePercent float64   `json:"rebatePercent"`
+	UpdatedBy     int       `json:"updatedBy"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type UpdateSettlementPolicyRequest struct {
+	RebatePercent float64 `json:"rebatePercent"`
+}
+
+// SettlementQuote is what closes a handout on a date. Interest is earned
+// installment by installment, the one running on the date in proportion to
+// its days gone by. Penalties include those that will accrue until then.
+type SettlementQuote struct {
+	HandoutID        int       `json:"handoutId"`
+	Date             time.Time `json:"date"`
+	PrincipalDue     Money     `json:"principalDue"`
+	InterestAccrued  Money     `json:"interestAccrued"`
+	UnearnedInterest Money     `json:"unearnedInterest"`
+	Rebate           Money     `json:"rebate"`      // part of the unearned interest forgiven
+	InterestDue      Money     `json:"interestDue"` // accrued and unearned interest not rebated
+	PenaltiesDue     Money     `json:"penaltiesDue"`
+	Total            Money     `json:"total"`
+}
+
+// SettleRequest confirms the total of the quote for the date
+type SettleRequest struct {
+	Amount Money  `json:"amount"`
+	Date   string `json:"date"` // YYYY-MM-DD, today only, today by default
+}
+
+type HandoutSettlement struct {
+	ID           int       `json:"id"`
+	HandoutID    int       `json:"handoutId"`
+	CollectionID int       `json:"collectionId"`
+	SettledOn    time.Time `json:"settledOn"`
+	Principal    Money     `json:"principal"`
+	Interest     Money     `json:"interest"`
+	Penalties    Money     `json:"penalties"`
+	Rebate       Money     `json:"rebate"`
+	Amount       Money     `json:"amount"`
+	SettledBy    int       `json:"settledBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// settlementQuote works out what settles a handout with balance on date. The
+// schedule is nil when the handout has none, it then owes no interest.
+// penalties are those accruing until date, rebateBasisPoints of the unearned
+// interest is forgiven.
+func settlementQuote(balance HandoutBalance, schedule *RepaymentSchedule, penalties Money, rebateBasisPoints int64, date time.Time) SettlementQuote {
+	quote := SettlementQuote{HandoutID: balance.HandoutID, Date: date, PrincipalDue: balance.PrincipalDue,
+		PenaltiesDue: balance.PenaltiesDue + penalties}
+
+	if schedule != nil {
+		running := false
+		for _, item := range schedule.Schedule {
+			unpaid := item.Interest - min(item.Paid, item.Interest)
+			switch {
+			case !dayOf(item.DueDate).After(dayOf(date)):
+				quote.InterestAccrued += unpaid
+			case !running:
+				// Earned for the days of its period gone by
+				running = true
+				start := dueDate(schedule.FirstDueDate, schedule.Frequency, item.Number-1)
+				days := daysBetween(start, item.DueDate)
+				earned := unpaid
+				if days > 0 {
+					earned = unpaid.MulRatio(int64(min(max(daysBetween(start, date), 0), days)), int64(days))
+				}
+				quote.InterestAccrued += earned
+				quote.UnearnedInterest += unpaid - earned
+			default:
+				quote.UnearnedInterest += unpaid
+			}
+		}
+	}
+
+	quote.Rebate = quote.UnearnedInterest.MulRatio(rebateBasisPoints, 10000)
+	quote.InterestDue = quote.InterestAccrued + quote.UnearnedInterest - quote.Rebate
+	quote.Total = quote.PrincipalDue + quote.InterestDue + quote.PenaltiesDue
+	return quote
+}
+
+// parseSettlementDate parses the date a quote is for, today by default. A
+// quote is never for a past date: payments, penalties and interest are only
+// known as of today.
+func parseSettlementDate(field, raw string) (time.Time, *FieldError) {
+	date, fieldErr := parseEffectiveDate(field, raw)
+	if fieldErr == nil && date.Before(today()) {
+		fieldErr = &FieldError{Field: field, Code: INVALID_VALUE, Message: "cannot be in the past"}
+	}
+	return date, fieldErr
+}
+
+// parseSettledOn parses the date a handout is settled on, which can only be
+// today: the collection is taken and the quoted penalties charged right away.
+// A later date is for quotes only.
+func parseSettledOn(field, raw string) (time.Time, *FieldError) {
+	date, fieldErr := parseEffectiveDate(field, raw)
+	if fieldErr == nil && !date.Equal(today()) {
+		fieldErr = &FieldError{Field: field, Code: INVALID_VALUE, Message: "must be today"}
+	}
+	return date, fieldErr
+}
+
+func scanSettlementPolicy(row interface{ Scan(...any) error }) (policy SettlementPolicy, err error) {
+	err = row.Scan(&policy.RebatePercent, &policy.UpdatedBy, &policy.UpdatedAt)
+	return policy, err
+}
+
+func scanSettlement(row interface{ Scan(...any) error }) (settlement HandoutSettlement, err error) {
+	err = row.Scan(&settlement.ID, &settlement.HandoutID, &settlement.CollectionID, &settlement.SettledOn,
+		&settlement.Principal, &settlement.Interest, &settlement.Penalties, &settlement.Rebate, &settlement.Amount,
+		&settlement.SettledBy, &settlement.CreatedAt)
+	return settlement, err
+}
+
+// loadSettlementQuote quotes an active or pending handout with balance on
+// date, with the penalties not accrued yet it would be charged by then
+func loadSettlementQuote(tx *tracedTx, balance HandoutBalance, date time.Time) (SettlementQuote, []Penalty, error) {
+	handoutID := balance.HandoutID
+	policy, err := scanSettlementPolicy(tx.QueryRow(GET_SETTLEMENT_POLICY))
+	if err != nil {
+		return SettlementQuote{}, nil, err
+	}
+	rebateBasisPoints, _ := percentBasisPoints(policy.RebatePercent)
+
+	schedule, err := loadSchedule(tx, handoutID, 0, 0)
+	if err == sql.ErrNoRows {
+		return settlementQuote(balance, nil, 0, rebateBasisPoints, date), nil, nil
+	}
+	if err != nil {
+		return SettlementQuote{}, nil, err
+	}
+
+	// Charged by the nightly accrual up to the day before settling
+	rules, err := scanPenaltyRules(tx.QueryRow(GET_PENALTY_RULES))
+	if err != nil {
+		return SettlementQuote{}, nil, err
+	}
+	collections, err := loadPayments(tx, handoutID)
+	if err != nil {
+		return SettlementQuote{}, nil, err
+	}
+	accrued, err := loadPenalties(tx, handoutID)
+	if err != nil {
+		return SettlementQuote{}, nil, err
+	}
+	pending := computePenalties(schedule, versionPayments(collections, schedule.CollectedBefore), rules, accrued, date.AddDate(0, 0, -1))
+	var penalties Money
+	for _, penalty := range pending {
+		penalties += penalty.Amount
+	}
+	return settlementQuote(balance, &schedule, penalties, rebateBasisPoints, date), pending, nil
+}
+
+// getSettlementQuote answers "how much closes the loan on ?date=", today by
+// default. The quote holds until a collection, waiver or penalty changes the
+// balance.
+func getSettlementQuote(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	date, fieldErr := parseSettlementDate("date", r.URL.Query().Get("date"))
+	if fieldErr != nil {
+		sendValidationErrors(w, []FieldError{*fieldErr})
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	balance, err := loadHandoutBalance(tx, handoutID, branchScope(r))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if balance.Status != "ACTIVE" && balance.Status != "PENDING" {
+		sendError(w, http.StatusConflict, HANDOUT_NOT_ACTIVE, "Only an active or pending handout can be settled")
+		return
+	}
+
+	quote, _, err := loadSettlementQuote(tx, balance, date)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[SettlementQuote]{
+		D:   quote,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// settleHandout records the final collection of a handout and completes it,
+// in one transaction. The amount must be the total quoted for the date, so a
+// stale quote is refused with the current one.
+func settleHandout(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req SettleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.Amount <= 0 {
+		fields = append(fields, FieldError{Field: "amount", Code: INVALID_VALUE, Message: "enter the total of the settlement quote"})
+	}
+	date, fieldErr := parseSettledOn("date", req.Date)
+	if fieldErr != nil {
+		fields = append(fields, *fieldErr)
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	// The collection belongs to the handout's branch
+	branchID, ok := handoutBranch(w, r, handoutID)
+	if !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locked so no collection, waiver or accrual changes the quote meanwhile
+	var customerID int
+	var amount, collected Money
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, branchScope(r)).Scan(&customerID, &amount, &status, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if status != "ACTIVE" && status != "PENDING" {
+		sendError(w, http.StatusConflict, HANDOUT_NOT_ACTIVE, "Only an active or pending handout can be settled")
+		return
+	}
+
+	balance, err := loadHandoutBalance(tx, handoutID, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	quote, pending, err := loadSettlementQuote(tx, balance, date)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if quote.Total <= 0 {
+		sendError(w, http.StatusConflict, NOTHING_DUE, "Nothing is due on the handout, complete it instead")
+		return
+	}
+	if req.Amount != quote.Total {
+		apiErr := newAPIError(http.StatusConflict, SETTLEMENT_AMOUNT_MISMATCH,
+			"The handout settles for "+quote.Total.String()+" on "+date.Format(time.DateOnly))
+		apiErr.Details = quote
+		sendAPIError(w, apiErr)
+		return
+	}
+
+	// The penalties quoted are charged like the nightly accrual would have
+	for _, penalty := range pending {
+		_, err := tx.Exec(CREATE_PENALTY, penalty.HandoutID, penalty.ScheduleID, penalty.Installment, penalty.Kind,
+			penalty.AccruedOn, penalty.Amount)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+	}
+
+	var collectionID int
+	if err := tx.QueryRow(CREATE_COLLECTION, date, quote.Total, handoutID, branchID).Scan(&collectionID); err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	adminID, _ := r.Context().Value("adminID").(int)
+	_, err = tx.Exec(CREATE_HANDOUT_SETTLEMENT, handoutID, collectionID, date, quote.PrincipalDue, quote.InterestDue,
+		quote.PenaltiesDue, quote.Rebate, quote.Total, adminID)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	if _, err := tx.Exec(COMPLETE_HANDOUT, handoutID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	// Completing the handout may earn its referrers a completion bonus
+	if err := accrueReferralRewards(tx, handoutID, adminID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	settlement, err := scanSettlement(tx.QueryRow(GET_HANDOUT_SETTLEMENT, handoutID, 0))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	refreshCustomerRisk(r, customerID)
+
+	resp := DataResp[HandoutSettlement]{
+		D:   settlement,
+		Msg: "Handout settled successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getHandoutSettlement(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	settlement, err := scanSettlement(db.QueryRowContext(r.Context(), GET_HANDOUT_SETTLEMENT, handoutID, branchScope(r)))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, SETTLEMENT_NOT_FOUND, "Handout has not been settled")
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[HandoutSettlement]{
+		D:   settlement,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getSettlementPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := scanSettlementPolicy(db.QueryRowContext(r.Context(), GET_SETTLEMENT_POLICY))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[SettlementPolicy]{
+		D:   policy,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// updateSettlementPolicy replaces the policy, which applies to every branch
+// from the next quote on
+func updateSettlementPolicy(w http.ResponseWriter, r *http.Request) {
+	if !isSuperAdmin(r) {
+		sendErrorResponse(w, "Only the super admin can manage the settlement policy", http.StatusForbidden)
+		return
+	}
+
+	var req UpdateSettlementPolicyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if _, ok := percentBasisPoints(req.RebatePercent); !ok {
+		sendValidationErrors(w, []FieldError{{Field: "rebatePercent", Code: INVALID_VALUE, Message: "must be between 0 and 100 with at most two decimals"}})
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	policy, err := scanSettlementPolicy(db.QueryRowContext(r.Context(), UPDATE_SETTLEMENT_POLICY, req.RebatePercent, adminID))
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+
+	resp := DataResp[SettlementPolicy]{
+		D:   policy,
+		Msg: "Settlement policy updated successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
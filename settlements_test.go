@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettlementQuote(t *testing.T) {
+	// The first installment paid
+	schedule := monthlySchedule(26000, date("2026-02-24"))
+	balance := HandoutBalance{HandoutID: 3, Status: "ACTIVE", Amount: 100000, Collected: 26000, PrincipalDue: 75000, PenaltiesDue: 700}
+
+	tests := []struct {
+		name        string
+		schedule    *RepaymentSchedule
+		penalties   Money
+		rebate      int64
+		date        string
+		accrued     Money
+		unearned    Money
+		interestDue Money
+		total       Money
+	}{
+		// The second installment is due, the third halfway through its 28 days
+		{"full rebate", &schedule, 300, 10000, "2026-02-24", 1500, 1500, 1500, 75000 + 1500 + 1000},
+		{"half rebate", &schedule, 300, 5000, "2026-02-24", 1500, 1500, 2250, 75000 + 2250 + 1000},
+		{"no rebate", &schedule, 0, 0, "2026-02-24", 1500, 1500, 3000, 75000 + 3000 + 700},
+		{"on a due date", &schedule, 0, 10000, "2026-03-10", 2000, 1000, 2000, 75000 + 2000 + 700},
+		{"after the last due date", &schedule, 0, 10000, "2026-05-01", 3000, 0, 3000, 75000 + 3000 + 700},
+		{"without a schedule", nil, 0, 10000, "2026-02-24", 0, 0, 0, 75000 + 700},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := settlementQuote(balance, tt.schedule, tt.penalties, tt.rebate, date(tt.date))
+			if quote.InterestAccrued != tt.accrued || quote.UnearnedInterest != tt.unearned || quote.InterestDue != tt.interestDue {
+				t.Errorf("expected %s accrued, %s unearned and %s interest due, got %+v", tt.accrued, tt.unearned, tt.interestDue, quote)
+			}
+			if quote.Rebate != quote.InterestAccrued+quote.UnearnedInterest-quote.InterestDue {
+				t.Errorf("rebate %s does not add up in %+v", quote.Rebate, quote)
+			}
+			if quote.PrincipalDue != 75000 || quote.PenaltiesDue != 700+tt.penalties || quote.Total != tt.total {
+				t.Errorf("expected a total of %s, got %+v", tt.total, quote)
+			}
+		})
+	}
+}
+
+func TestFrozenHandout(t *testing.T) {
+	completed := handoutTerms{Status: "COMPLETED", Amount: 100000, Date: date("2026-01-10")}
+	reopened := handoutTerms{Status: "ACTIVE", Amount: 100000, Date: date("2026-01-10")}
+	cancelled := handoutTerms{Status: "CANCELLED", Amount: 100000, Date: date("2026-01-10")}
+	increased := handoutTerms{Status: "COMPLETED", Amount: 120000, Date: date("2026-01-10")}
+	redated := handoutTerms{Status: "COMPLETED", Amount: 100000, Date: date("2026-01-11")}
+	writtenOff := handoutTerms{Status: "WRITTEN_OFF", Amount: 100000, Date: date("2026-01-10")}
+
+	tests := []struct {
+		name           string
+		previous, next handoutTerms
+		settled        bool
+		want           ErrorCode
+	}{
+		{"active", reopened, completed, false, ""},
+		{"reopened", completed, reopened, false, ""},
+		{"amount changed", completed, increased, false, ""},
+		{"settled and reopened", completed, reopened, true, HANDOUT_SETTLED},
+		{"settled and cancelled", completed, cancelled, true, HANDOUT_SETTLED},
+		{"settled, amount changed", completed, increased, true, HANDOUT_SETTLED},
+		{"settled, date changed", completed, redated, true, HANDOUT_SETTLED},
+		{"settled, terms kept", completed, completed, true, ""},
+		{"written off", writtenOff, writtenOff, false, HANDOUT_WRITTEN_OFF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ErrorCode
+			if apiErr := frozenHandout(tt.previous, tt.next, tt.settled); apiErr != nil {
+				got = apiErr.Code
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSettlementDate(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  time.Time
+		valid bool
+	}{
+		{"", today(), true},
+		{today().Format(time.DateOnly), today(), true},
+		{today().AddDate(0, 0, 7).Format(time.DateOnly), today().AddDate(0, 0, 7), true},
+		// Quotes are only known as of today, so is a settlement
+		{today().AddDate(0, 0, -1).Format(time.DateOnly), time.Time{}, false},
+		{"31/12/2030", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		date, fieldErr := parseSettlementDate("date", tt.raw)
+		if (fieldErr == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got %+v", tt.raw, tt.valid, fieldErr)
+		}
+		if tt.valid && !date.Equal(tt.want) {
+			t.Errorf("%q: expected %s, got %s", tt.raw, tt.want, date)
+		}
+	}
+}
+
+func TestParseSettledOn(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{"", true},
+		{today().Format(time.DateOnly), true},
+		// Future dates are for quotes, the collection is taken today
+		{today().AddDate(0, 0, 1).Format(time.DateOnly), false},
+		{today().AddDate(0, 0, -1).Format(time.DateOnly), false},
+		{"31/12/2030", false},
+	}
+
+	for _, tt := range tests {
+		date, fieldErr := parseSettledOn("date", tt.raw)
+		if (fieldErr == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got %+v", tt.raw, tt.valid, fieldErr)
+		}
+		if tt.valid && !date.Equal(today()) {
+			t.Errorf("%q: expected today, got %s", tt.raw, date)
+		}
+	}
+}
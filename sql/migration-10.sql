@@ -0,0 +1,51 @@
+-- Migration 10: Branches
+-- Customers, handouts, collections and admins belong to a branch. Existing rows
+-- go to the default "Head Office" branch (id 1).
+CREATE TABLE IF NOT EXISTS branches (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    code VARCHAR(20) UNIQUE NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TRIGGER update_branches_updated_at
+BEFORE UPDATE ON branches
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+INSERT INTO branches (id, name, code) VALUES (1, 'Head Office', 'HO')
+ON CONFLICT (id) DO NOTHING;
+SELECT setval('branches_id_seq', GREATEST((SELECT MAX(id) FROM branches), 1));
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS branch_id INTEGER NOT NULL DEFAULT 1
+    CONSTRAINT customers_branch_id_fkey REFERENCES branches(id);
+ALTER TABLE handouts ADD COLUMN IF NOT EXISTS branch_id INTEGER NOT NULL DEFAULT 1
+    CONSTRAINT handouts_branch_id_fkey REFERENCES branches(id);
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS branch_id INTEGER NOT NULL DEFAULT 1
+    CONSTRAINT collections_branch_id_fkey REFERENCES branches(id);
+ALTER TABLE admins ADD COLUMN IF NOT EXISTS branch_id INTEGER NOT NULL DEFAULT 1
+    CONSTRAINT admins_branch_id_fkey REFERENCES branches(id);
+
+CREATE INDEX IF NOT EXISTS idx_customers_branch_id ON customers(branch_id);
+CREATE INDEX IF NOT EXISTS idx_handouts_branch_id ON handouts(branch_id);
+CREATE INDEX IF NOT EXISTS idx_collections_branch_id ON collections(branch_id);
+CREATE INDEX IF NOT EXISTS idx_admins_branch_id ON admins(branch_id);
+
+-- Audit trail of customers moved between branches together with their loans
+CREATE TABLE IF NOT EXISTS customer_transfers (
+    id SERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    from_branch_id INTEGER NOT NULL REFERENCES branches(id),
+    to_branch_id INTEGER NOT NULL REFERENCES branches(id),
+    handouts_moved INTEGER NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL,
+    transferred_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_transfers_customer_id ON customer_transfers(customer_id);
+
+INSERT INTO schema_migrations (version) VALUES (10)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,24 @@
+-- Migration 11: KYC documents
+-- Only metadata lives here, the contents are in the storage backend under storage_key
+CREATE TYPE document_type AS ENUM ('ID_PROOF', 'ADDRESS_PROOF', 'PHOTO');
+
+CREATE TABLE IF NOT EXISTS customer_documents (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL
+        CONSTRAINT customer_documents_customer_id_fkey REFERENCES customers(id),
+    document_type document_type NOT NULL,
+    file_name TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    storage_backend VARCHAR(20) NOT NULL,
+    storage_key TEXT UNIQUE NOT NULL,
+    expires_on DATE,
+    uploaded_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_documents_customer_id ON customer_documents(customer_id, document_type);
+
+INSERT INTO schema_migrations (version) VALUES (11)
+ON CONFLICT (version) DO NOTHING;
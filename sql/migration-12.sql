@@ -0,0 +1,34 @@
+-- Migration 12: Guarantors and nominees on handouts
+-- Replaces the nominee_id column dropped in migration 4. A party is either an
+-- existing customer (customer_id) or an external person (name, mobile, address).
+CREATE TYPE party_role AS ENUM ('GUARANTOR', 'NOMINEE');
+
+CREATE TABLE IF NOT EXISTS handout_parties (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL REFERENCES handouts(id) ON DELETE CASCADE,
+    role party_role NOT NULL,
+    customer_id BIGINT
+        CONSTRAINT handout_parties_customer_id_fkey REFERENCES customers(id),
+    name TEXT,
+    mobile BIGINT,
+    address TEXT NOT NULL DEFAULT '',
+    relationship TEXT NOT NULL,
+    -- Percent of the loan the guarantor is liable for, or the nominee's entitlement
+    liability_share NUMERIC(5,2) NOT NULL CHECK (liability_share > 0 AND liability_share <= 100),
+    created_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT handout_parties_person_check CHECK ((customer_id IS NULL) <> (name IS NULL)),
+    CONSTRAINT handout_parties_customer_key UNIQUE (handout_id, role, customer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_handout_parties_handout_id ON handout_parties(handout_id);
+CREATE INDEX IF NOT EXISTS idx_handout_parties_customer_id ON handout_parties(customer_id);
+CREATE INDEX IF NOT EXISTS idx_handout_parties_mobile ON handout_parties(mobile);
+
+CREATE TRIGGER update_handout_parties_updated_at
+BEFORE UPDATE ON handout_parties
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+INSERT INTO schema_migrations (version) VALUES (12)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,41 @@
+-- Migration 13: Collateral register replacing the handouts.bond flag
+-- Each handout can hold several items of collateral. An item stays in custody
+-- until it is returned, which is only allowed once the handout is COMPLETED.
+CREATE TYPE collateral_type AS ENUM ('PROMISSORY_NOTE', 'CHEQUE', 'GOLD', 'VEHICLE_PAPERS', 'PROPERTY_PAPERS', 'OTHER');
+
+CREATE TABLE IF NOT EXISTS collaterals (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL
+        CONSTRAINT collaterals_handout_id_fkey REFERENCES handouts(id),
+    collateral_type collateral_type NOT NULL,
+    description TEXT NOT NULL,
+    declared_value DECIMAL(15,2) NOT NULL CHECK (declared_value >= 0),
+    -- NULL until the item has been valued by the branch
+    assessed_value DECIMAL(15,2) CHECK (assessed_value >= 0),
+    custody_location TEXT NOT NULL DEFAULT '',
+    received_on DATE NOT NULL,
+    received_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    returned_on DATE,
+    returned_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT collaterals_returned_check CHECK (returned_on IS NULL OR returned_on >= received_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_collaterals_handout_id ON collaterals(handout_id);
+CREATE INDEX IF NOT EXISTS idx_collaterals_held ON collaterals(handout_id) WHERE returned_on IS NULL;
+
+CREATE TRIGGER update_collaterals_updated_at
+BEFORE UPDATE ON collaterals
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Every bond recorded so far was a promissory note taken when the loan was given
+INSERT INTO collaterals (handout_id, collateral_type, description, declared_value, received_on)
+SELECT id, 'PROMISSORY_NOTE', 'Bond recorded before the collateral register', 0, date::date
+FROM handouts
+WHERE bond;
+
+ALTER TABLE handouts DROP COLUMN IF EXISTS bond;
+
+INSERT INTO schema_migrations (version) VALUES (13)
+ON CONFLICT (version) DO NOTHING;
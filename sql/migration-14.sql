@@ -0,0 +1,23 @@
+-- Migration 14: Referral link history
+-- Every change of customers.referred_by is recorded, unlinking as a change to NULL
+CREATE TABLE IF NOT EXISTS customer_referral_changes (
+    id SERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    previous_referred_by BIGINT REFERENCES customers(id) ON DELETE SET NULL,
+    referred_by BIGINT REFERENCES customers(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    changed_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_referral_changes_customer_id ON customer_referral_changes(customer_id);
+CREATE INDEX IF NOT EXISTS idx_customers_referred_by ON customers(referred_by);
+
+-- Links made before the history was kept
+INSERT INTO customer_referral_changes (customer_id, referred_by, reason)
+SELECT id, referred_by, 'Linked before referral history was kept'
+FROM customers
+WHERE referred_by IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES (14)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,73 @@
+-- Migration 15: Referral rewards
+-- Rules say what a referrer earns when someone they brought in borrows. Level 1
+-- is the borrower's referrer, level 2 that customer's referrer and so on, so a
+-- reward can be split over several levels with one rule per level.
+CREATE TYPE reward_kind AS ENUM ('DISBURSEMENT_PERCENT', 'COMPLETION_BONUS');
+
+CREATE TABLE IF NOT EXISTS referral_reward_rules (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    kind reward_kind NOT NULL,
+    level INTEGER NOT NULL CHECK (level BETWEEN 1 AND 5),
+    -- Percent of the disbursed amount for DISBURSEMENT_PERCENT
+    percent NUMERIC(5,2) CHECK (percent > 0 AND percent <= 100),
+    -- Fixed amount per completed loan for COMPLETION_BONUS
+    amount DECIMAL(15,2) CHECK (amount > 0),
+    active BOOLEAN NOT NULL DEFAULT true,
+    -- Only handouts given on or after this date earn the reward
+    effective_from DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT referral_reward_rules_value_check CHECK (
+        (kind = 'DISBURSEMENT_PERCENT' AND percent IS NOT NULL AND amount IS NULL) OR
+        (kind = 'COMPLETION_BONUS' AND amount IS NOT NULL AND percent IS NULL)
+    )
+);
+
+CREATE TRIGGER update_referral_reward_rules_updated_at
+BEFORE UPDATE ON referral_reward_rules
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- The ledger is append only. Accruals are positive, clawbacks and payouts
+-- negative, so a referrer's balance is the sum of their entries.
+CREATE TYPE reward_entry_type AS ENUM ('ACCRUAL', 'CLAWBACK', 'PAYOUT');
+
+CREATE TABLE IF NOT EXISTS referral_reward_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    referrer_id BIGINT NOT NULL
+        CONSTRAINT referral_reward_ledger_referrer_id_fkey REFERENCES customers(id),
+    entry_type reward_entry_type NOT NULL,
+    amount DECIMAL(15,2) NOT NULL,
+    -- Accruals and clawbacks: the handout and the rule that earned the reward
+    handout_id BIGINT
+        CONSTRAINT referral_reward_ledger_handout_id_fkey REFERENCES handouts(id),
+    rule_id INTEGER REFERENCES referral_reward_rules(id),
+    level INTEGER,
+    -- Clawbacks: the accrual that is reversed
+    reverses_id BIGINT UNIQUE REFERENCES referral_reward_ledger(id),
+    -- Payouts: how the referrer was paid
+    payment_method TEXT NOT NULL DEFAULT '',
+    payment_reference TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    entry_date DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT referral_reward_ledger_sign_check CHECK (
+        (entry_type = 'ACCRUAL' AND amount > 0 AND handout_id IS NOT NULL) OR
+        (entry_type = 'CLAWBACK' AND amount < 0 AND reverses_id IS NOT NULL) OR
+        (entry_type = 'PAYOUT' AND amount < 0)
+    )
+);
+
+-- A rule pays once per handout, however often accrual runs. Keyed on the
+-- level rather than the referrer, so relinking a customer does not pay the
+-- new referrer for what the previous one was already paid.
+CREATE UNIQUE INDEX IF NOT EXISTS referral_reward_ledger_accrual_key
+ON referral_reward_ledger(rule_id, handout_id, level) WHERE entry_type = 'ACCRUAL';
+
+CREATE INDEX IF NOT EXISTS idx_referral_reward_ledger_referrer_id ON referral_reward_ledger(referrer_id);
+CREATE INDEX IF NOT EXISTS idx_referral_reward_ledger_handout_id ON referral_reward_ledger(handout_id);
+
+INSERT INTO schema_migrations (version) VALUES (15)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,29 @@
+-- Migration 16: Duplicate customer detection and merges
+-- Migration 6 renames a customers_mobile_key that was never created, mobile was
+-- added in migration 3 without UNIQUE. Existing duplicates have to be merged
+-- before a unique index can be added, so for now mobiles are checked on create
+-- and update, with these indexes behind the exact and fuzzy lookups.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_customers_mobile ON customers(mobile);
+CREATE INDEX IF NOT EXISTS idx_customers_name_trgm ON customers USING GIN (lower(name) gin_trgm_ops);
+
+-- A merge moves everything of the merged customer to the survivor and deletes
+-- the merged customer, whose last state is kept here
+CREATE TABLE IF NOT EXISTS customer_merges (
+    id SERIAL PRIMARY KEY,
+    survivor_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    merged_id BIGINT NOT NULL,
+    merged_customer JSONB NOT NULL,
+    handouts_moved INTEGER NOT NULL DEFAULT 0,
+    documents_moved INTEGER NOT NULL DEFAULT 0,
+    referrals_moved INTEGER NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL,
+    merged_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_merges_survivor_id ON customer_merges(survivor_id);
+
+INSERT INTO schema_migrations (version) VALUES (16)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,45 @@
+-- Migration 17: Credit limits and eligibility rules
+-- A customer's outstanding on ACTIVE and PENDING handouts, including a new or
+-- increased one, may not go above their credit limit. NULL is no limit.
+ALTER TABLE customers
+ADD COLUMN credit_limit DECIMAL(15,2) CHECK (credit_limit >= 0);
+
+-- The rules every disbursement is checked against, one row for all branches.
+-- A NULL limit disables that rule.
+CREATE TABLE IF NOT EXISTS eligibility_rules (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    max_active_handouts INTEGER CHECK (max_active_handouts >= 0),
+    max_total_exposure DECIMAL(15,2) CHECK (max_total_exposure >= 0),
+    -- Days an owing handout may be overdue: past its oldest unpaid
+    -- installment, or without a collection when it has no schedule
+    max_arrears_days INTEGER CHECK (max_arrears_days >= 0),
+    require_kyc BOOLEAN NOT NULL DEFAULT false,
+    updated_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TRIGGER update_eligibility_rules_updated_at
+BEFORE UPDATE ON eligibility_rules
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Every rule is left off so existing customers keep borrowing until limits
+-- are configured
+INSERT INTO eligibility_rules DEFAULT VALUES
+ON CONFLICT (id) DO NOTHING;
+
+-- Handouts an admin disbursed although rules failed
+CREATE TABLE IF NOT EXISTS eligibility_overrides (
+    id SERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL REFERENCES handouts(id) ON DELETE CASCADE,
+    customer_id BIGINT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    amount DECIMAL(15,2) NOT NULL,
+    failed_rules TEXT[] NOT NULL,
+    reason TEXT NOT NULL,
+    overridden_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_eligibility_overrides_customer_id ON eligibility_overrides(customer_id);
+
+INSERT INTO schema_migrations (version) VALUES (17)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,16 @@
+-- Migration 18: Customer risk scores
+-- Scores are derived from handouts and collections, refreshed nightly and
+-- whenever a collection of the customer changes. Kept out of customers so a
+-- refresh does not touch customers.updated_at.
+CREATE TABLE IF NOT EXISTS customer_risk_scores (
+    customer_id BIGINT PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+    -- 0 is the most reliable borrower, 100 the riskiest
+    score SMALLINT NOT NULL CHECK (score BETWEEN 0 AND 100),
+    factors JSONB NOT NULL,
+    computed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_risk_scores_score ON customer_risk_scores(score);
+
+INSERT INTO schema_migrations (version) VALUES (18)
+ON CONFLICT (version) DO NOTHING;
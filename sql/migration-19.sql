@@ -0,0 +1,78 @@
+-- Migration 19: Repayment schedules and late payment penalties
+-- A schedule splits the principal of a handout into equal installments due
+-- every day, week or month from the first due date. Collections pay the
+-- installments in order, so installment n is paid once the handout's
+-- collections cover the first n installments.
+CREATE TYPE installment_frequency AS ENUM ('DAILY', 'WEEKLY', 'MONTHLY');
+
+CREATE TABLE IF NOT EXISTS handout_schedules (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL UNIQUE REFERENCES handouts(id) ON DELETE CASCADE,
+    principal DECIMAL(15,2) NOT NULL CHECK (principal > 0),
+    installments INTEGER NOT NULL CHECK (installments BETWEEN 1 AND 1000),
+    frequency installment_frequency NOT NULL,
+    first_due_date DATE NOT NULL,
+    created_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TRIGGER update_handout_schedules_updated_at
+BEFORE UPDATE ON handout_schedules
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- The penalty rules for all branches, one row. An installment is missed when
+-- it is still unpaid grace_days after it fell due. It is charged flat_fee
+-- once, and daily_percent of its unpaid part for every further day it stays
+-- unpaid. NULL caps are no cap.
+CREATE TABLE IF NOT EXISTS penalty_rules (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    grace_days INTEGER NOT NULL DEFAULT 0 CHECK (grace_days >= 0),
+    flat_fee DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (flat_fee >= 0),
+    daily_percent NUMERIC(5,2) NOT NULL DEFAULT 0 CHECK (daily_percent BETWEEN 0 AND 100),
+    max_per_installment DECIMAL(15,2) CHECK (max_per_installment >= 0),
+    max_per_handout DECIMAL(15,2) CHECK (max_per_handout >= 0),
+    updated_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TRIGGER update_penalty_rules_updated_at
+BEFORE UPDATE ON penalty_rules
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- No penalties until the rules are configured
+INSERT INTO penalty_rules DEFAULT VALUES
+ON CONFLICT (id) DO NOTHING;
+
+-- Accrued penalties are append only, a rule change applies to the days not
+-- accrued yet. Waivers reduce what is due.
+CREATE TYPE penalty_kind AS ENUM ('FLAT', 'DAILY');
+
+CREATE TABLE IF NOT EXISTS handout_penalties (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL REFERENCES handouts(id) ON DELETE CASCADE,
+    schedule_id BIGINT NOT NULL REFERENCES handout_schedules(id) ON DELETE CASCADE,
+    installment INTEGER NOT NULL,
+    kind penalty_kind NOT NULL,
+    accrued_on DATE NOT NULL,
+    amount DECIMAL(15,2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    -- Accrual can run any number of times a day
+    UNIQUE (schedule_id, installment, kind, accrued_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_handout_penalties_handout_id ON handout_penalties(handout_id);
+
+CREATE TABLE IF NOT EXISTS penalty_waivers (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL REFERENCES handouts(id) ON DELETE CASCADE,
+    amount DECIMAL(15,2) NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    approved_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_penalty_waivers_handout_id ON penalty_waivers(handout_id);
+
+INSERT INTO schema_migrations (version) VALUES (19)
+ON CONFLICT (version) DO NOTHING;
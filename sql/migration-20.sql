@@ -0,0 +1,43 @@
+-- Migration 20: Schedule versions and restructuring
+-- A restructure never edits a schedule, it adds the next version of it. The
+-- latest version is the current one. Collections pay a version's installments
+-- once they go beyond collected_before, what had been collected when the
+-- version was created, of which penalties_paid_before went to penalties.
+ALTER TABLE handout_schedules
+DROP CONSTRAINT IF EXISTS handout_schedules_handout_id_key;
+
+ALTER TABLE handout_schedules
+ADD COLUMN version INTEGER NOT NULL DEFAULT 1 CHECK (version > 0),
+-- Flat interest, percent a year of the principal over the tenure
+ADD COLUMN annual_rate NUMERIC(5,2) NOT NULL DEFAULT 0 CHECK (annual_rate BETWEEN 0 AND 100),
+ADD COLUMN interest DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (interest >= 0),
+ADD COLUMN collected_before DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (collected_before >= 0),
+ADD COLUMN penalties_paid_before DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (penalties_paid_before >= 0),
+ADD CONSTRAINT handout_schedules_version_key UNIQUE (handout_id, version);
+
+CREATE TYPE restructure_operation AS ENUM ('EXTEND_TENURE', 'CHANGE_RATE', 'CAPITALIZE_ARREARS', 'TOP_UP');
+
+-- Links a version to the one it replaced, with what was carried over
+CREATE TABLE IF NOT EXISTS handout_restructures (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL REFERENCES handouts(id) ON DELETE CASCADE,
+    from_schedule_id BIGINT NOT NULL UNIQUE REFERENCES handout_schedules(id) ON DELETE CASCADE,
+    to_schedule_id BIGINT NOT NULL UNIQUE REFERENCES handout_schedules(id) ON DELETE CASCADE,
+    operations restructure_operation[] NOT NULL CHECK (cardinality(operations) > 0),
+    -- Principal of the old version not repaid yet
+    remaining_principal DECIMAL(15,2) NOT NULL,
+    -- Overdue interest and penalties rolled into the new principal
+    capitalized_interest DECIMAL(15,2) NOT NULL DEFAULT 0,
+    capitalized_penalties DECIMAL(15,2) NOT NULL DEFAULT 0,
+    top_up DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (top_up >= 0),
+    -- Interest of the old version's installments not due yet, no longer owed
+    unearned_interest DECIMAL(15,2) NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL,
+    restructured_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_handout_restructures_handout_id ON handout_restructures(handout_id);
+
+INSERT INTO schema_migrations (version) VALUES (20)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,28 @@
+-- Migration 21: Write-offs and recoveries
+-- A handout that will not be repaid is written off by an admin. It keeps its
+-- history, unlike a cancelled handout that was never disbursed, and leaves the
+-- active portfolio. Collections still recorded on it are recoveries.
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'WRITTEN_OFF';
+
+CREATE TABLE IF NOT EXISTS handout_write_offs (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL UNIQUE REFERENCES handouts(id) ON DELETE CASCADE,
+    -- What was due when written off, amount is their total
+    principal DECIMAL(15,2) NOT NULL CHECK (principal >= 0),
+    interest DECIMAL(15,2) NOT NULL CHECK (interest >= 0),
+    penalties DECIMAL(15,2) NOT NULL CHECK (penalties >= 0),
+    amount DECIMAL(15,2) NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    approved_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    written_off_on DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_handout_write_offs_written_off_on ON handout_write_offs(written_off_on);
+
+-- Set when a collection is recorded on a written-off handout
+ALTER TABLE collections
+ADD COLUMN IF NOT EXISTS recovery BOOLEAN NOT NULL DEFAULT false;
+
+INSERT INTO schema_migrations (version) VALUES (21)
+ON CONFLICT (version) DO NOTHING;
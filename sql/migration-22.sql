@@ -0,0 +1,37 @@
+-- Migration 22: Early settlement
+-- A borrower can close a handout early by paying the principal due, the
+-- interest earned up to the settlement date and the penalties due. The
+-- interest of the rest of the tenure is not earned, rebate_percent of it is
+-- forgiven.
+CREATE TABLE IF NOT EXISTS settlement_policy (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    rebate_percent NUMERIC(5,2) NOT NULL DEFAULT 100 CHECK (rebate_percent BETWEEN 0 AND 100),
+    updated_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TRIGGER update_settlement_policy_updated_at
+BEFORE UPDATE ON settlement_policy
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Unearned interest is rebated in full until the policy is changed
+INSERT INTO settlement_policy DEFAULT VALUES
+ON CONFLICT (id) DO NOTHING;
+
+-- The final collection of a settled handout and how it was made up
+CREATE TABLE IF NOT EXISTS handout_settlements (
+    id BIGSERIAL PRIMARY KEY,
+    handout_id BIGINT NOT NULL UNIQUE REFERENCES handouts(id) ON DELETE CASCADE,
+    collection_id BIGINT REFERENCES collections(id) ON DELETE SET NULL,
+    settled_on DATE NOT NULL,
+    principal DECIMAL(15,2) NOT NULL CHECK (principal >= 0),
+    interest DECIMAL(15,2) NOT NULL CHECK (interest >= 0),
+    penalties DECIMAL(15,2) NOT NULL CHECK (penalties >= 0),
+    rebate DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (rebate >= 0),
+    amount DECIMAL(15,2) NOT NULL CHECK (amount > 0),
+    settled_by INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version) VALUES (22)
+ON CONFLICT (version) DO NOTHING;
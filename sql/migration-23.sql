@@ -0,0 +1,37 @@
+-- Migration 23: Super admin flag
+-- The super admin used to be whoever was named "admin", so renaming accounts
+-- moved the privilege. It is now the account flagged here, there is at most
+-- one and the flag cannot be changed afterwards.
+ALTER TABLE admins
+ADD COLUMN IF NOT EXISTS is_super_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE admins SET is_super_admin = TRUE
+WHERE username = 'admin' AND role = 'admin';
+
+CREATE UNIQUE INDEX IF NOT EXISTS admins_super_admin_key
+ON admins (is_super_admin) WHERE is_super_admin;
+
+ALTER TABLE admins
+DROP CONSTRAINT IF EXISTS admins_super_admin_role_check;
+
+ALTER TABLE admins
+ADD CONSTRAINT admins_super_admin_role_check CHECK (NOT is_super_admin OR role = 'admin');
+
+CREATE OR REPLACE FUNCTION keep_super_admin_flag()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.is_super_admin IS DISTINCT FROM OLD.is_super_admin THEN
+        RAISE EXCEPTION 'is_super_admin cannot be changed';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS keep_super_admin_flag ON admins;
+
+CREATE TRIGGER keep_super_admin_flag
+BEFORE UPDATE OF is_super_admin ON admins
+FOR EACH ROW EXECUTE FUNCTION keep_super_admin_flag();
+
+INSERT INTO schema_migrations (version) VALUES (23)
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Migration 7: Create api_keys table for machine-to-machine integrations
+-- Only the SHA-256 hash of each key is stored; the secret is shown once on creation
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    admin_id INTEGER NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+    key_prefix VARCHAR(12) NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_admin_id ON api_keys(admin_id);
@@ -0,0 +1,15 @@
+-- Migration 8: Track issued logins as admin sessions
+-- Each JWT carries its session's token_id (jti) so sessions can be listed and revoked
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id SERIAL PRIMARY KEY,
+    admin_id INTEGER NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+    token_id VARCHAR(64) UNIQUE NOT NULL,
+    user_agent TEXT,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_admin_id ON admin_sessions(admin_id);
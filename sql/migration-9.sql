@@ -0,0 +1,10 @@
+-- Migration 9: Record applied schema version for readiness checks
+-- Every later migration must insert its own version at the end
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version)
+SELECT generate_series(1, 9)
+ON CONFLICT (version) DO NOTHING;
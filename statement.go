@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Statement entry types
+const (
+	ENTRY_DISBURSEMENT      = "DISBURSEMENT" // the handout or a top-up
+	ENTRY_INTEREST          = "INTEREST"
+	ENTRY_INTEREST_REVERSAL = "INTEREST_REVERSAL" // interest not due yet when restructured
+	ENTRY_PENALTY           = "PENALTY"
+	ENTRY_COLLECTION        = "COLLECTION"
+	ENTRY_RECOVERY          = "RECOVERY" // collected after the handout was written off
+	ENTRY_WAIVER            = "WAIVER"
+	ENTRY_REBATE            = "REBATE" // interest forgiven on early settlement
+)
+
+// HandoutBalance is what is owed on a handout. Collections pay the amount
+// first, anything above it pays penalties. With a schedule they pay its
+// current version's installments, interest included, instead.
+type HandoutBalance struct {
+	HandoutID            int    `json:"handoutId"`
+	Status               string `json:"status"`
+	Amount               Money  `json:"amount"`
+	Collected            Money  `json:"collected"`
+	PrincipalDue         Money  `json:"principalDue"`
+	InterestDue          Money  `json:"interestDue"`
+	PenaltiesAccrued     Money  `json:"penaltiesAccrued"`
+	PenaltiesWaived      Money  `json:"penaltiesWaived"`
+	PenaltiesCapitalized Money  `json:"penaltiesCapitalized"` // moved into the principal by a restructure
+	PenaltiesDue         Money  `json:"penaltiesDue"`
+	Balance              Money  `json:"balance"`             // principal, interest and penalties due
+	Overdue              Money  `json:"overdue"`             // unpaid part of installments past their due date
+	OverdueInstallments  int    `json:"overdueInstallments"` // 0 without a schedule
+	WrittenOff           Money  `json:"writtenOff"`          // nothing is due once written off
+	Recovered            Money  `json:"recovered"`           // collected since
+}
+
+// StatementEntry is a line of a customer statement. Disbursements, interest
+// and penalties are debits, collections, recoveries, waivers, reversals and
+// rebates credits. A write-off is not a line, the customer still owes what it
+// covers.
+type StatementEntry struct {
+	Date      time.Time `json:"date"`
+	Type      string    `json:"type"`
+	HandoutID int       `json:"handoutId"`
+	Debit     Money     `json:"debit"`
+	Credit    Money     `json:"credit"`
+	Balance   Money     `json:"balance"` // running total over all the customer's handouts
+}
+
+type CustomerStatement struct {
+	CustomerID int              `json:"customerId"`
+	Disbursed  Money            `json:"disbursed"`
+	Interest   Money            `json:"interest"` // net of reversals and rebates
+	Penalties  Money            `json:"penalties"`
+	Collected  Money            `json:"collected"`
+	Recovered  Money            `json:"recovered"`
+	Waived     Money            `json:"waived"`
+	Balance    Money            `json:"balance"`
+	Entries    []StatementEntry `json:"entries"`
+}
+
+// handoutBalance fills in what is due from the amounts
+func handoutBalance(balance HandoutBalance) HandoutBalance {
+	balance.PrincipalDue = outstanding(balance.Status, balance.Amount, balance.Collected)
+	overpaid := max(balance.Collected-balance.Amount, 0)
+	balance.PenaltiesDue = 0
+	if balance.Status != "CANCELLED" && balance.Status != "WRITTEN_OFF" {
+		balance.PenaltiesDue = penaltiesDue(balance, overpaid)
+	}
+	balance.Balance = balance.PrincipalDue + balance.PenaltiesDue
+	return balance
+}
+
+// scheduleBalance fills in what is due from the current version of the
+// handout's schedule, whose installments have been paid
+func scheduleBalance(balance HandoutBalance, schedule RepaymentSchedule) HandoutBalance {
+	balance.PrincipalDue, balance.InterestDue, balance.PenaltiesDue = 0, 0, 0
+	balance.Overdue, balance.OverdueInstallments = 0, 0
+	if balance.Status != "ACTIVE" && balance.Status != "PENDING" {
+		return handoutBalance(balance)
+	}
+
+	var paid Money
+	for _, item := range schedule.Schedule {
+		interestPaid := min(item.Paid, item.Interest)
+		balance.InterestDue += item.Interest - interestPaid
+		balance.PrincipalDue += item.Principal - (item.Paid - interestPaid)
+		paid += item.Paid
+	}
+	overpaid := max(balance.Collected-schedule.CollectedBefore-paid, 0) + schedule.PenaltiesPaidBefore
+	balance.PenaltiesDue = penaltiesDue(balance, overpaid)
+	balance.Overdue, balance.OverdueInstallments = overdue(schedule.Schedule)
+	balance.Balance = balance.PrincipalDue + balance.InterestDue + balance.PenaltiesDue
+	return balance
+}
+
+// penaltiesDue is what is left of the penalties after waivers, capitalization
+// and what was paid beyond the principal and interest
+func penaltiesDue(balance HandoutBalance, overpaid Money) Money {
+	return max(balance.PenaltiesAccrued-balance.PenaltiesWaived-balance.PenaltiesCapitalized-overpaid, 0)
+}
+
+// loadHandoutBalance returns sql.ErrNoRows when the handout is not in scope
+func loadHandoutBalance(tx *tracedTx, handoutID, scope int) (HandoutBalance, error) {
+	balance := HandoutBalance{HandoutID: handoutID}
+	err := tx.QueryRow(GET_HANDOUT_BALANCE, handoutID, scope).Scan(&balance.Status, &balance.Amount,
+		&balance.Collected, &balance.PenaltiesAccrued, &balance.PenaltiesWaived, &balance.PenaltiesCapitalized,
+		&balance.WrittenOff, &balance.Recovered)
+	if err != nil {
+		return balance, err
+	}
+
+	schedule, err := loadSchedule(tx, handoutID, 0, 0)
+	if err == sql.ErrNoRows {
+		return handoutBalance(balance), nil
+	}
+	if err != nil {
+		return balance, err
+	}
+	return scheduleBalance(balance, schedule), nil
+}
+
+// statement adds up the entries and fills in their running balance
+func statement(customerID int, entries []StatementEntry) CustomerStatement {
+	result := CustomerStatement{CustomerID: customerID, Entries: entries}
+	for i := range entries {
+		entry := &entries[i]
+		switch entry.Type {
+		case ENTRY_DISBURSEMENT:
+			result.Disbursed += entry.Debit
+		case ENTRY_INTEREST:
+			result.Interest += entry.Debit
+		case ENTRY_INTEREST_REVERSAL, ENTRY_REBATE:
+			result.Interest -= entry.Credit
+		case ENTRY_PENALTY:
+			result.Penalties += entry.Debit
+		case ENTRY_COLLECTION:
+			result.Collected += entry.Credit
+		case ENTRY_RECOVERY:
+			result.Recovered += entry.Credit
+		case ENTRY_WAIVER:
+			result.Waived += entry.Credit
+		}
+		result.Balance += entry.Debit - entry.Credit
+		entry.Balance = result.Balance
+	}
+	return result
+}
+
+func getHandoutBalance(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	balance, err := loadHandoutBalance(tx, handoutID, branchScope(r))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[HandoutBalance]{
+		D:   balance,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getCustomerStatement lists a customer's disbursements, interest, penalties,
+// collections, recoveries and waivers by date with a running balance
+func getCustomerStatement(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := customerBranch(w, r, customerID); !ok {
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_CUSTOMER_STATEMENT, customerID)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []StatementEntry{}
+	for rows.Next() {
+		var entry StatementEntry
+		var amount Money
+		if err := rows.Scan(&entry.Date, &entry.Type, &entry.HandoutID, &amount); err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		switch entry.Type {
+		case ENTRY_DISBURSEMENT, ENTRY_INTEREST, ENTRY_PENALTY:
+			entry.Debit = amount
+		default:
+			entry.Credit = amount
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[CustomerStatement]{
+		D:   statement(customerID, entries),
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestHandoutBalance(t *testing.T) {
+	tests := []struct {
+		name                 string
+		balance              HandoutBalance
+		principal, penalties Money
+	}{
+		{"penalties on top", HandoutBalance{Status: "ACTIVE", Amount: 10000, Collected: 4000, PenaltiesAccrued: 700, PenaltiesWaived: 200}, 6000, 500},
+		{"overpayment pays penalties", HandoutBalance{Status: "ACTIVE", Amount: 10000, Collected: 10300, PenaltiesAccrued: 700}, 0, 400},
+		{"fully waived", HandoutBalance{Status: "ACTIVE", Amount: 10000, PenaltiesAccrued: 700, PenaltiesWaived: 700}, 10000, 0},
+		{"cancelled owes nothing", HandoutBalance{Status: "CANCELLED", Amount: 10000, PenaltiesAccrued: 700}, 0, 0},
+		{"written off owes nothing", HandoutBalance{Status: "WRITTEN_OFF", Amount: 10000, Collected: 3000, PenaltiesAccrued: 700, WrittenOff: 7700}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := handoutBalance(tt.balance)
+			if got.PrincipalDue != tt.principal || got.PenaltiesDue != tt.penalties || got.Balance != tt.principal+tt.penalties {
+				t.Errorf("expected %s principal and %s penalties due, got %+v", tt.principal, tt.penalties, got)
+			}
+		})
+	}
+}
+
+func TestScheduleBalance(t *testing.T) {
+	// Version 2 of a schedule, 30.00 collected before it of which 5.00 paid
+	// penalties, three monthly installments of 100.00 and 10.00 of interest
+	schedule := RepaymentSchedule{Principal: 30000, Interest: 3000, Installments: 3, Frequency: FREQUENCY_MONTHLY,
+		FirstDueDate: date("2026-01-10"), CollectedBefore: 3000, PenaltiesPaidBefore: 500}
+	schedule.Schedule = schedule.plan()
+	collections := []Collection{{Date: date("2026-01-01"), Amount: 3000}, {Date: date("2026-01-10"), Amount: 11500}}
+	applyPayments(schedule.Schedule, versionPayments(collections, schedule.CollectedBefore), date("2026-02-15"))
+
+	balance := HandoutBalance{Status: "ACTIVE", Collected: 14500, PenaltiesAccrued: 1500, PenaltiesWaived: 200, PenaltiesCapitalized: 300}
+	got := scheduleBalance(balance, schedule)
+
+	// 115.00 paid the first installment and 5.00 of the second's interest
+	if got.InterestDue != 1500 || got.PrincipalDue != 20000 || got.PenaltiesDue != 500 || got.Balance != 22000 {
+		t.Errorf("expected 200.00 principal, 15.00 interest and 5.00 penalties due, got %+v", got)
+	}
+	if got.Overdue != 10500 || got.OverdueInstallments != 1 {
+		t.Errorf("expected the second installment overdue, got %s on %d", got.Overdue, got.OverdueInstallments)
+	}
+
+	balance.Status = "COMPLETED"
+	if got := scheduleBalance(balance, schedule); got.Balance != 0 || got.Overdue != 0 {
+		t.Errorf("expected nothing due on a completed handout, got %+v", got)
+	}
+}
+
+func TestStatement(t *testing.T) {
+	entries := []StatementEntry{
+		{Type: ENTRY_DISBURSEMENT, Debit: 10000},
+		{Type: ENTRY_PENALTY, Debit: 500},
+		{Type: ENTRY_COLLECTION, Credit: 6000},
+		{Type: ENTRY_WAIVER, Credit: 200},
+		{Type: ENTRY_INTEREST, Debit: 900},
+		{Type: ENTRY_INTEREST_REVERSAL, Credit: 300},
+		{Type: ENTRY_RECOVERY, Credit: 100},
+		{Type: ENTRY_REBATE, Credit: 100},
+	}
+	got := statement(1, entries)
+
+	if got.Disbursed != 10000 || got.Interest != 500 || got.Penalties != 500 || got.Collected != 6000 || got.Waived != 200 || got.Recovered != 100 || got.Balance != 4700 {
+		t.Errorf("unexpected totals %+v", got)
+	}
+	for i, want := range []Money{10000, 10500, 4500, 4300, 5200, 4900, 4800, 4700} {
+		if got.Entries[i].Balance != want {
+			t.Errorf("entry %d: expected running balance %s, got %s", i, want, got.Entries[i].Balance)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yogesh-k64/middleware-finance-app/config"
+)
+
+// DocumentStore keeps the contents of uploaded documents. The database only
+// holds their metadata and the key they are stored under.
+type DocumentStore interface {
+	// Name is recorded with each document so a later backend switch can be detected
+	Name() string
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get returns errDocumentContentMissing when nothing is stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds when nothing is stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+var errDocumentContentMissing = errors.New("document content not found in storage")
+
+// documentStore is the backend selected by STORAGE_BACKEND, set by initStorage
+var documentStore DocumentStore
+
+func initStorage(cfg config.StorageConfig) error {
+	switch cfg.Backend {
+	case "s3":
+		documentStore = newS3Store(cfg)
+	default:
+		store, err := newLocalStore(cfg.LocalDir)
+		if err != nil {
+			return err
+		}
+		documentStore = store
+	}
+	return nil
+}
+
+// localStore keeps documents as files under a directory
+type localStore struct {
+	dir string
+}
+
+func newLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create document directory: %w", err)
+	}
+	return &localStore{dir: dir}, nil
+}
+
+func (s *localStore) Name() string { return "local" }
+
+// path maps a key to a file, refusing keys that would leave the directory
+func (s *localStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid document key %q", key)
+	}
+	return path, nil
+}
+
+func (s *localStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed upload never leaves half a document
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errDocumentContentMissing
+	}
+	return file, err
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// s3Store keeps documents in an S3 bucket or an S3-compatible service
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Store(cfg config.StorageConfig) *s3Store {
+	client := s3.New(s3.Options{
+		Region:       cfg.S3Region,
+		Credentials:  credentials.NewStaticCredentialsProvider(cfg.S3AccessKeyID, cfg.S3SecretAccessKey, ""),
+		UsePathStyle: cfg.S3PathStyle,
+		BaseEndpoint: optionalString(cfg.S3Endpoint),
+	})
+	return &s3Store{client: client, bucket: cfg.S3Bucket}
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+func (s *s3Store) Name() string { return "s3" }
+
+func (s *s3Store) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	})
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, errDocumentContentMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
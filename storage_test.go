@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(ctx, "customers/1/abc", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	body, err := store.Get(ctx, "customers/1/abc")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	content, _ := io.ReadAll(body)
+	body.Close()
+	if string(content) != "hello" {
+		t.Errorf("got %q, want %q", content, "hello")
+	}
+
+	if err := store.Delete(ctx, "customers/1/abc"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "customers/1/abc"); err != errDocumentContentMissing {
+		t.Errorf("expected errDocumentContentMissing after delete, got %v", err)
+	}
+	if err := store.Delete(ctx, "customers/1/abc"); err != nil {
+		t.Errorf("deleting a missing document should succeed, got %v", err)
+	}
+
+	if err := store.Put(ctx, "../outside", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("expected keys leaving the directory to be rejected")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yogesh-k64/middleware-finance-app/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/yogesh-k64/middleware-finance-app"
+
+// tracer is a no-op until initTracing installs a real provider
+var tracer = otel.Tracer(tracerName)
+
+// initTracing installs the global tracer provider for the configured exporter.
+// The returned function flushes pending spans and must be called on shutdown.
+func initTracing(cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		options := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		var file *os.File
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("service.version", getBuildCommit()),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer(tracerName)
+
+	return provider.Shutdown, nil
+}
+
+// startHTTPSpan continues an incoming W3C trace (traceparent header) or starts a new one.
+// The span is renamed to the route template once the router has matched.
+func startHTTPSpan(ctx context.Context, r *http.Request) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("client.address", clientIP(r)),
+		),
+	)
+}
+
+// endHTTPSpan records the outcome of a request handled by requestLogger
+func endHTTPSpan(span trace.Span, r *http.Request, info *requestInfo, route string, status int) {
+	span.SetName(r.Method + " " + route)
+	span.SetAttributes(
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", status),
+		attribute.String("request.id", info.ID),
+	)
+	if info.AdminID != 0 {
+		span.SetAttributes(attribute.Int("enduser.id", info.AdminID))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// traceID returns the current trace ID for log correlation, or "" when not sampled
+func traceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// queryName maps SQL text to its constant name in querys.go. Inline queries
+// fall back to "<OPERATION> <table>".
+func queryName(query string) string {
+	if name, ok := queryNames[query]; ok {
+		return name
+	}
+
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	operation := strings.ToUpper(fields[0])
+	for i, field := range fields[:len(fields)-1] {
+		switch strings.ToUpper(field) {
+		case "FROM", "INTO", "UPDATE":
+			return operation + " " + strings.Trim(fields[i+1], "(;")
+		}
+	}
+	return operation
+}
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := queryName(query)
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.query.name", name),
+			attribute.String("db.operation.name", strings.ToUpper(strings.Fields(query + " QUERY")[0])),
+			attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+		),
+	)
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "query failed")
+	}
+	span.End()
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// tracedRows counts rows as they are read and ends the query span on Close
+type tracedRows struct {
+	*sql.Rows
+	span  trace.Span
+	count int
+	once  sync.Once
+}
+
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		r.count++
+		return true
+	}
+	return false
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(func() {
+		r.span.SetAttributes(attribute.Int("db.response.returned_rows", r.count))
+		endQuerySpan(r.span, r.Rows.Err())
+	})
+	return err
+}
+
+// tracedRow ends the query span when the single row is scanned
+type tracedRow struct {
+	row  *sql.Row
+	span trace.Span
+}
+
+func (r *tracedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	returned := 1
+	if err != nil {
+		returned = 0
+	}
+	r.span.SetAttributes(attribute.Int("db.response.returned_rows", returned))
+	endQuerySpan(r.span, err)
+	return err
+}
+
+func tracedQuery(ctx context.Context, q sqlQuerier, query string, args ...any) (*tracedRows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		endQuerySpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func tracedQueryRow(ctx context.Context, q sqlQuerier, query string, args ...any) *tracedRow {
+	ctx, span := startQuerySpan(ctx, query)
+	return &tracedRow{row: q.QueryRowContext(ctx, query, args...), span: span}
+}
+
+func tracedExec(ctx context.Context, q sqlQuerier, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := q.ExecContext(ctx, query, args...)
+	if err == nil {
+		if affected, rowsErr := result.RowsAffected(); rowsErr == nil {
+			span.SetAttributes(attribute.Int64("db.response.affected_rows", affected))
+		}
+	}
+	endQuerySpan(span, err)
+	return result, err
+}
+
+// tracedDB wraps the connection pool so every query gets a span named after
+// its querys.go constant. Use the Context variants inside handlers so query
+// spans are children of the request span.
+type tracedDB struct {
+	*sql.DB
+}
+
+func (d *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*tracedRows, error) {
+	return tracedQuery(ctx, d.DB, query, args...)
+}
+
+func (d *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *tracedRow {
+	return tracedQueryRow(ctx, d.DB, query, args...)
+}
+
+func (d *tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tracedExec(ctx, d.DB, query, args...)
+}
+
+func (d *tracedDB) Query(query string, args ...any) (*tracedRows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+func (d *tracedDB) QueryRow(query string, args ...any) *tracedRow {
+	return d.QueryRowContext(context.Background(), query, args...)
+}
+
+func (d *tracedDB) Exec(query string, args ...any) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+// BeginTx starts a transaction whose queries are traced under ctx
+func (d *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	tx, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, ctx: ctx}, nil
+}
+
+// tracedTx mirrors tracedDB for queries run inside a transaction
+type tracedTx struct {
+	*sql.Tx
+	ctx context.Context
+}
+
+func (t *tracedTx) Query(query string, args ...any) (*tracedRows, error) {
+	return tracedQuery(t.ctx, t.Tx, query, args...)
+}
+
+func (t *tracedTx) QueryRow(query string, args ...any) *tracedRow {
+	return tracedQueryRow(t.ctx, t.Tx, query, args...)
+}
+
+func (t *tracedTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tracedExec(t.ctx, t.Tx, query, args...)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HandoutWriteOff records what was still due on a handout when an admin gave
+// up on it. Collections recorded on it since are recoveries.
+type HandoutWriteOff struct {
+	ID           int                    `json:"id"`
+	HandoutID    int                    `json:"handoutId"`
+	Customer     HandoutCustomerDetails `json:"customer"`
+	Principal    Money                  `json:"principal"`
+	Interest     Money                  `json:"interest"`
+	Penalties    Money                  `json:"penalties"`
+	Amount       Money                  `json:"amount"`
+	Recovered    Money                  `json:"recovered"`
+	NetLoss      Money                  `json:"netLoss"` // amount not recovered
+	Reason       string                 `json:"reason"`
+	ApprovedBy   int                    `json:"approvedBy"`
+	WrittenOffOn time.Time              `json:"writtenOffOn"`
+	CreatedAt    time.Time              `json:"createdAt"`
+}
+
+type WriteOffRequest struct {
+	Reason string `json:"reason"`
+}
+
+// WriteOffReport adds up write-offs and their recoveries, which are left out
+// of the collections of the active portfolio
+type WriteOffReport struct {
+	Loans           []HandoutWriteOff `json:"loans"`
+	TotalWrittenOff Money             `json:"totalWrittenOff"`
+	TotalRecovered  Money             `json:"totalRecovered"`
+	TotalNetLoss    Money             `json:"totalNetLoss"`
+}
+
+// writeOffReport adds up the write-offs
+func writeOffReport(writeOffs []HandoutWriteOff) WriteOffReport {
+	report := WriteOffReport{Loans: writeOffs}
+	for _, writeOff := range writeOffs {
+		report.TotalWrittenOff += writeOff.Amount
+		report.TotalRecovered += writeOff.Recovered
+		report.TotalNetLoss += writeOff.NetLoss
+	}
+	return report
+}
+
+// netLoss is what is still lost of a write-off, nothing once recoveries cover it
+func netLoss(amount, recovered Money) Money {
+	return max(amount-recovered, 0)
+}
+
+func scanWriteOff(row interface{ Scan(...any) error }) (writeOff HandoutWriteOff, err error) {
+	err = row.Scan(&writeOff.ID, &writeOff.HandoutID, &writeOff.Customer.ID, &writeOff.Customer.Name, &writeOff.Customer.Mobile,
+		&writeOff.Principal, &writeOff.Interest, &writeOff.Penalties, &writeOff.Amount, &writeOff.Recovered,
+		&writeOff.Reason, &writeOff.ApprovedBy, &writeOff.WrittenOffOn, &writeOff.CreatedAt)
+	writeOff.NetLoss = netLoss(writeOff.Amount, writeOff.Recovered)
+	return writeOff, err
+}
+
+// parseDateParam parses an optional YYYY-MM-DD query parameter, nil when omitted
+func parseDateParam(r *http.Request, name string) (*time.Time, *FieldError) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, &FieldError{Field: name, Code: INVALID_VALUE, Message: "must be a date like 2025-12-31"}
+	}
+	return &date, nil
+}
+
+// writeOffHandout writes off what is due on an active or pending handout.
+// Only admins can approve a write-off. The referral rewards it earned are
+// taken back, and no rewards or penalties accrue on it afterwards.
+func writeOffHandout(w http.ResponseWriter, r *http.Request) {
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		sendErrorResponse(w, "Only admins can write off handouts", http.StatusForbidden)
+		return
+	}
+
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	var req WriteOffRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Reason == "" {
+		sendValidationErrors(w, []FieldError{{Field: "reason", Code: REQUIRED, Message: "a reason is required to write off a handout"}})
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var customerID int
+	var amount, collected Money
+	var status string
+	err = tx.QueryRow(LOCK_HANDOUT_BALANCE, handoutID, branchScope(r)).Scan(&customerID, &amount, &status, &collected)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, HANDOUT_NOT_FOUND, HANDOUTS_NOT_FOUND_MSG)
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if status != "ACTIVE" && status != "PENDING" {
+		sendError(w, http.StatusConflict, HANDOUT_NOT_ACTIVE, "Only an active or pending handout can be written off")
+		return
+	}
+
+	balance, err := loadHandoutBalance(tx, handoutID, 0)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if balance.Balance <= 0 {
+		sendError(w, http.StatusConflict, NOTHING_DUE, "Nothing is due on the handout, complete it instead")
+		return
+	}
+
+	adminID, _ := r.Context().Value("adminID").(int)
+	_, err = tx.Exec(CREATE_HANDOUT_WRITE_OFF, handoutID, balance.PrincipalDue, balance.InterestDue,
+		balance.PenaltiesDue, balance.Balance, req.Reason, adminID)
+	if err != nil {
+		sendDBError(w, r, err)
+		return
+	}
+	if _, err := tx.Exec(WRITE_OFF_HANDOUT, handoutID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	if _, err := clawBackReferralRewards(tx, handoutID, "Handout written off", adminID); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	writeOff, err := scanWriteOff(tx.QueryRow(GET_WRITE_OFFS, handoutID, 0, nil, nil))
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	refreshCustomerRisk(r, customerID)
+
+	resp := DataResp[HandoutWriteOff]{
+		D:   writeOff,
+		Msg: "Handout written off successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getHandoutWriteOff returns the write-off of a handout with what was recovered since
+func getHandoutWriteOff(w http.ResponseWriter, r *http.Request) {
+	handoutID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, INVALID_ID, INVALID_ID_MSG)
+		return
+	}
+
+	if _, ok := handoutBranch(w, r, handoutID); !ok {
+		return
+	}
+
+	writeOff, err := scanWriteOff(db.QueryRowContext(r.Context(), GET_WRITE_OFFS, handoutID, branchScope(r), nil, nil))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, WRITE_OFF_NOT_FOUND, "Handout has not been written off")
+		return
+	}
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[HandoutWriteOff]{
+		D:   writeOff,
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getWriteOffReport lists the write-offs in the caller's branch, written off
+// between ?from= and ?to= when given, with their recoveries and net loss
+func getWriteOffReport(w http.ResponseWriter, r *http.Request) {
+	from, fromErr := parseDateParam(r, "from")
+	to, toErr := parseDateParam(r, "to")
+	var fields []FieldError
+	for _, fieldErr := range []*FieldError{fromErr, toErr} {
+		if fieldErr != nil {
+			fields = append(fields, *fieldErr)
+		}
+	}
+	if len(fields) > 0 {
+		sendValidationErrors(w, fields)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), GET_WRITE_OFFS, 0, branchScope(r), from, to)
+	if err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	writeOffs := []HandoutWriteOff{}
+	for rows.Next() {
+		writeOff, err := scanWriteOff(rows)
+		if err != nil {
+			sendInternalError(w, r, err)
+			return
+		}
+		writeOffs = append(writeOffs, writeOff)
+	}
+	if err := rows.Err(); err != nil {
+		sendInternalError(w, r, err)
+		return
+	}
+
+	resp := DataResp[WriteOffReport]{
+		D:   writeOffReport(writeOffs),
+		Msg: SUCCESS_MSG,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
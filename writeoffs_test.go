@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestNetLoss(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    Money
+		recovered Money
+		want      Money
+	}{
+		{"nothing recovered", 50000, 0, 50000},
+		{"partly recovered", 50000, 12000, 38000},
+		{"fully recovered", 50000, 50000, 0},
+		{"recovered more than written off", 5000, 6000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := netLoss(tt.amount, tt.recovered); got != tt.want {
+				t.Errorf("expected a net loss of %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteOffReport(t *testing.T) {
+	writeOffs := []HandoutWriteOff{
+		{HandoutID: 1, Amount: 50000, Recovered: 12000},
+		{HandoutID: 2, Amount: 20000},
+		// Recovered in full and then some, no loss
+		{HandoutID: 3, Amount: 5000, Recovered: 6000},
+	}
+	for i := range writeOffs {
+		writeOffs[i].NetLoss = netLoss(writeOffs[i].Amount, writeOffs[i].Recovered)
+	}
+	got := writeOffReport(writeOffs)
+
+	if len(got.Loans) != 3 || got.TotalWrittenOff != 75000 || got.TotalRecovered != 18000 || got.TotalNetLoss != 58000 {
+		t.Errorf("unexpected totals %+v", got)
+	}
+}